@@ -22,6 +22,17 @@ type CmdManifestlistParams struct {
 	Count   *int    `form:"count,omitempty" json:"count,omitempty"`
 }
 
+// CmdNegativeclearParams defines parameters for CmdNegativeclear.
+type CmdNegativeclearParams struct {
+	Pattern *string `form:"pattern,omitempty" json:"pattern,omitempty"`
+}
+
+// CmdNegativelistParams defines parameters for CmdNegativelist.
+type CmdNegativelistParams struct {
+	Pattern *string `form:"pattern,omitempty" json:"pattern,omitempty"`
+	Count   *int    `form:"count,omitempty" json:"count,omitempty"`
+}
+
 // CmdPruneParams defines parameters for CmdPrune.
 type CmdPruneParams struct {
 	Type   string  `form:"type" json:"type"`
@@ -31,72 +42,15 @@ type CmdPruneParams struct {
 	Count  *int    `form:"count,omitempty" json:"count,omitempty"`
 }
 
-// V2AuthParams defines parameters for V2Auth.
-type V2AuthParams struct {
-	Scope         *string `form:"scope,omitempty" json:"scope,omitempty"`
-	Service       *string `form:"service,omitempty" json:"service,omitempty"`
-	Authorization string  `json:"authorization"`
-}
-
-// V2PostNameBlobsUploadsParams defines parameters for V2PostNameBlobsUploads.
-type V2PostNameBlobsUploadsParams struct {
-	Digest *string `form:"digest,omitempty" json:"digest,omitempty"`
-	Mount  *string `form:"mount,omitempty" json:"mount,omitempty"`
-	From   *string `form:"from,omitempty" json:"from,omitempty"`
-}
-
-// V2PutNameBlobsUploadsReferenceParams defines parameters for V2PutNameBlobsUploadsReference.
-type V2PutNameBlobsUploadsReferenceParams struct {
-	Digest *string `form:"digest,omitempty" json:"digest,omitempty"`
-}
-
-// V2GetNameReferrersDigestParams defines parameters for V2GetNameReferrersDigest.
-type V2GetNameReferrersDigestParams struct {
-	ArtifactType *string `form:"artifactType,omitempty" json:"artifactType,omitempty"`
-}
-
-// V2GetNameTagsListParams defines parameters for V2GetNameTagsList.
-type V2GetNameTagsListParams struct {
+// V2GetCatalogParams defines parameters for V2GetCatalog.
+type V2GetCatalogParams struct {
 	N    *string `form:"n,omitempty" json:"n,omitempty"`
 	Last *string `form:"last,omitempty" json:"last,omitempty"`
 }
 
-// V2GetS1ManifestsReferenceParams defines parameters for V2GetS1ManifestsReference.
-type V2GetS1ManifestsReferenceParams struct {
-	Ns *string `form:"ns,omitempty" json:"ns,omitempty"`
-}
-
-// V2HeadS1ManifestsReferenceParams defines parameters for V2HeadS1ManifestsReference.
-type V2HeadS1ManifestsReferenceParams struct {
-	Ns *string `form:"ns,omitempty" json:"ns,omitempty"`
-}
-
-// V2GetS1S2ManifestsReferenceParams defines parameters for V2GetS1S2ManifestsReference.
-type V2GetS1S2ManifestsReferenceParams struct {
-	Ns *string `form:"ns,omitempty" json:"ns,omitempty"`
-}
-
-// V2HeadS1S2ManifestsReferenceParams defines parameters for V2HeadS1S2ManifestsReference.
-type V2HeadS1S2ManifestsReferenceParams struct {
-	Ns *string `form:"ns,omitempty" json:"ns,omitempty"`
-}
-
-// V2GetS1S2S3ManifestsReferenceParams defines parameters for V2GetS1S2S3ManifestsReference.
-type V2GetS1S2S3ManifestsReferenceParams struct {
-	Ns *string `form:"ns,omitempty" json:"ns,omitempty"`
-}
-
-// V2HeadS1S2S3ManifestsReferenceParams defines parameters for V2HeadS1S2S3ManifestsReference.
-type V2HeadS1S2S3ManifestsReferenceParams struct {
-	Ns *string `form:"ns,omitempty" json:"ns,omitempty"`
-}
-
-// V2GetS1S2S3S4ManifestsReferenceParams defines parameters for V2GetS1S2S3S4ManifestsReference.
-type V2GetS1S2S3S4ManifestsReferenceParams struct {
-	Ns *string `form:"ns,omitempty" json:"ns,omitempty"`
-}
-
-// V2HeadS1S2S3S4ManifestsReferenceParams defines parameters for V2HeadS1S2S3S4ManifestsReference.
-type V2HeadS1S2S3S4ManifestsReferenceParams struct {
-	Ns *string `form:"ns,omitempty" json:"ns,omitempty"`
+// V2AuthParams defines parameters for V2Auth.
+type V2AuthParams struct {
+	Scope         *string `form:"scope,omitempty" json:"scope,omitempty"`
+	Service       *string `form:"service,omitempty" json:"service,omitempty"`
+	Authorization *string `json:"authorization,omitempty"`
 }
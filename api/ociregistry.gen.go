@@ -28,12 +28,21 @@ type ServerInterface interface {
 	// (GET /cmd/blob/list)
 	CmdBloblist(ctx echo.Context, params CmdBloblistParams) error
 
+	// (GET /cmd/breaker/list)
+	CmdBreakerlist(ctx echo.Context) error
+
 	// (GET /cmd/image/list)
 	CmdImagelist(ctx echo.Context, params CmdImagelistParams) error
 
 	// (GET /cmd/manifest/list)
 	CmdManifestlist(ctx echo.Context, params CmdManifestlistParams) error
 
+	// (DELETE /cmd/negative/clear)
+	CmdNegativeclear(ctx echo.Context, params CmdNegativeclearParams) error
+
+	// (GET /cmd/negative/list)
+	CmdNegativelist(ctx echo.Context, params CmdNegativelistParams) error
+
 	// (DELETE /cmd/prune)
 	CmdPrune(ctx echo.Context, params CmdPruneParams) error
 
@@ -46,110 +55,11 @@ type ServerInterface interface {
 	// (HEAD /v2/)
 	V2HeadDefault(ctx echo.Context) error
 
+	// (GET /v2/_catalog)
+	V2GetCatalog(ctx echo.Context, params V2GetCatalogParams) error
+
 	// (GET /v2/auth)
 	V2Auth(ctx echo.Context, params V2AuthParams) error
-
-	// (POST /v2/{name}/blobs/uploads)
-	V2PostNameBlobsUploads(ctx echo.Context, name string, params V2PostNameBlobsUploadsParams) error
-
-	// (GET /v2/{name}/blobs/uploads/{reference})
-	V2GetNameBlobsUploadsReference(ctx echo.Context, name string, reference string) error
-
-	// (PATCH /v2/{name}/blobs/uploads/{reference})
-	V2PatchNameBlobsUploadsReference(ctx echo.Context, name string, reference string) error
-
-	// (PUT /v2/{name}/blobs/uploads/{reference})
-	V2PutNameBlobsUploadsReference(ctx echo.Context, name string, reference string, params V2PutNameBlobsUploadsReferenceParams) error
-
-	// (GET /v2/{name}/referrers/{digest})
-	V2GetNameReferrersDigest(ctx echo.Context, name string, digest string, params V2GetNameReferrersDigestParams) error
-
-	// (GET /v2/{name}/tags/list)
-	V2GetNameTagsList(ctx echo.Context, name string, params V2GetNameTagsListParams) error
-
-	// (DELETE /v2/{s1}/blobs/{digest})
-	V2DeleteS1BlobsDigest(ctx echo.Context, s1 string, digest string) error
-
-	// (GET /v2/{s1}/blobs/{digest})
-	V2GetS1BlobsDigest(ctx echo.Context, s1 string, digest string) error
-
-	// (HEAD /v2/{s1}/blobs/{digest})
-	V2HeadS1BlobsDigest(ctx echo.Context, s1 string, digest string) error
-
-	// (DELETE /v2/{s1}/manifests/{reference})
-	V2DeleteS1ManifestsReference(ctx echo.Context, s1 string, reference string) error
-
-	// (GET /v2/{s1}/manifests/{reference})
-	V2GetS1ManifestsReference(ctx echo.Context, s1 string, reference string, params V2GetS1ManifestsReferenceParams) error
-
-	// (HEAD /v2/{s1}/manifests/{reference})
-	V2HeadS1ManifestsReference(ctx echo.Context, s1 string, reference string, params V2HeadS1ManifestsReferenceParams) error
-
-	// (PUT /v2/{s1}/manifests/{reference})
-	V2PutS1ManifestsReference(ctx echo.Context, s1 string, reference string) error
-
-	// (DELETE /v2/{s1}/{s2}/blobs/{digest})
-	V2DeleteS1S2BlobsDigest(ctx echo.Context, s1 string, s2 string, digest string) error
-
-	// (GET /v2/{s1}/{s2}/blobs/{digest})
-	V2GetS1S2BlobsDigest(ctx echo.Context, s1 string, s2 string, digest string) error
-
-	// (HEAD /v2/{s1}/{s2}/blobs/{digest})
-	V2HeadS1S2BlobsDigest(ctx echo.Context, s1 string, s2 string, digest string) error
-
-	// (DELETE /v2/{s1}/{s2}/manifests/{reference})
-	V2DeleteS1S2ManifestsReference(ctx echo.Context, s1 string, s2 string, reference string) error
-
-	// (GET /v2/{s1}/{s2}/manifests/{reference})
-	V2GetS1S2ManifestsReference(ctx echo.Context, s1 string, s2 string, reference string, params V2GetS1S2ManifestsReferenceParams) error
-
-	// (HEAD /v2/{s1}/{s2}/manifests/{reference})
-	V2HeadS1S2ManifestsReference(ctx echo.Context, s1 string, s2 string, reference string, params V2HeadS1S2ManifestsReferenceParams) error
-
-	// (PUT /v2/{s1}/{s2}/manifests/{reference})
-	V2PutS1S2ManifestsReference(ctx echo.Context, s1 string, s2 string, reference string) error
-
-	// (DELETE /v2/{s1}/{s2}/{s3}/blobs/{digest})
-	V2DeleteS1S2S3BlobsDigest(ctx echo.Context, s1 string, s2 string, s3 string, digest string) error
-
-	// (GET /v2/{s1}/{s2}/{s3}/blobs/{digest})
-	V2GetS1S2S3BlobsDigest(ctx echo.Context, s1 string, s2 string, s3 string, digest string) error
-
-	// (HEAD /v2/{s1}/{s2}/{s3}/blobs/{digest})
-	V2HeadS1S2S3BlobsDigest(ctx echo.Context, s1 string, s2 string, s3 string, digest string) error
-
-	// (DELETE /v2/{s1}/{s2}/{s3}/manifests/{reference})
-	V2DeleteS1S2S3ManifestsReference(ctx echo.Context, s1 string, s2 string, s3 string, reference string) error
-
-	// (GET /v2/{s1}/{s2}/{s3}/manifests/{reference})
-	V2GetS1S2S3ManifestsReference(ctx echo.Context, s1 string, s2 string, s3 string, reference string, params V2GetS1S2S3ManifestsReferenceParams) error
-
-	// (HEAD /v2/{s1}/{s2}/{s3}/manifests/{reference})
-	V2HeadS1S2S3ManifestsReference(ctx echo.Context, s1 string, s2 string, s3 string, reference string, params V2HeadS1S2S3ManifestsReferenceParams) error
-
-	// (PUT /v2/{s1}/{s2}/{s3}/manifests/{reference})
-	V2PutS1S2S3ManifestsReference(ctx echo.Context, s1 string, s2 string, s3 string, reference string) error
-
-	// (DELETE /v2/{s1}/{s2}/{s3}/{s4}/blobs/{digest})
-	V2DeleteS1S2S3S4BlobsDigest(ctx echo.Context, s1 string, s2 string, s3 string, s4 string, digest string) error
-
-	// (GET /v2/{s1}/{s2}/{s3}/{s4}/blobs/{digest})
-	V2GetS1S2S3S4BlobsDigest(ctx echo.Context, s1 string, s2 string, s3 string, s4 string, digest string) error
-
-	// (HEAD /v2/{s1}/{s2}/{s3}/{s4}/blobs/{digest})
-	V2HeadS1S2S3S4BlobsDigest(ctx echo.Context, s1 string, s2 string, s3 string, s4 string, digest string) error
-
-	// (DELETE /v2/{s1}/{s2}/{s3}/{s4}/manifests/{reference})
-	V2DeleteS1S2S3S4ManifestsReference(ctx echo.Context, s1 string, s2 string, s3 string, s4 string, reference string) error
-
-	// (GET /v2/{s1}/{s2}/{s3}/{s4}/manifests/{reference})
-	V2GetS1S2S3S4ManifestsReference(ctx echo.Context, s1 string, s2 string, s3 string, s4 string, reference string, params V2GetS1S2S3S4ManifestsReferenceParams) error
-
-	// (HEAD /v2/{s1}/{s2}/{s3}/{s4}/manifests/{reference})
-	V2HeadS1S2S3S4ManifestsReference(ctx echo.Context, s1 string, s2 string, s3 string, s4 string, reference string, params V2HeadS1S2S3S4ManifestsReferenceParams) error
-
-	// (PUT /v2/{s1}/{s2}/{s3}/{s4}/manifests/{reference})
-	V2PutS1S2S3S4ManifestsReference(ctx echo.Context, s1 string, s2 string, s3 string, s4 string, reference string) error
 }
 
 // ServerInterfaceWrapper converts echo contexts to parameters.
@@ -191,6 +101,15 @@ func (w *ServerInterfaceWrapper) CmdBloblist(ctx echo.Context) error {
 	return err
 }
 
+// CmdBreakerlist converts echo context to params.
+func (w *ServerInterfaceWrapper) CmdBreakerlist(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.CmdBreakerlist(ctx)
+	return err
+}
+
 // CmdImagelist converts echo context to params.
 func (w *ServerInterfaceWrapper) CmdImagelist(ctx echo.Context) error {
 	var err error
@@ -248,6 +167,49 @@ func (w *ServerInterfaceWrapper) CmdManifestlist(ctx echo.Context) error {
 	return err
 }
 
+// CmdNegativeclear converts echo context to params.
+func (w *ServerInterfaceWrapper) CmdNegativeclear(ctx echo.Context) error {
+	var err error
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params CmdNegativeclearParams
+	// ------------- Optional query parameter "pattern" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "pattern", ctx.QueryParams(), &params.Pattern)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter pattern: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.CmdNegativeclear(ctx, params)
+	return err
+}
+
+// CmdNegativelist converts echo context to params.
+func (w *ServerInterfaceWrapper) CmdNegativelist(ctx echo.Context) error {
+	var err error
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params CmdNegativelistParams
+	// ------------- Optional query parameter "pattern" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "pattern", ctx.QueryParams(), &params.Pattern)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter pattern: %s", err))
+	}
+
+	// ------------- Optional query parameter "count" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "count", ctx.QueryParams(), &params.Count)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter count: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.CmdNegativelist(ctx, params)
+	return err
+}
+
 // CmdPrune converts echo context to params.
 func (w *ServerInterfaceWrapper) CmdPrune(ctx echo.Context) error {
 	var err error
@@ -321,216 +283,12 @@ func (w *ServerInterfaceWrapper) V2HeadDefault(ctx echo.Context) error {
 	return err
 }
 
-// V2Auth converts echo context to params.
-func (w *ServerInterfaceWrapper) V2Auth(ctx echo.Context) error {
-	var err error
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params V2AuthParams
-	// ------------- Optional query parameter "scope" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "scope", ctx.QueryParams(), &params.Scope)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter scope: %s", err))
-	}
-
-	// ------------- Optional query parameter "service" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "service", ctx.QueryParams(), &params.Service)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter service: %s", err))
-	}
-
-	headers := ctx.Request().Header
-	// ------------- Required header parameter "authorization" -------------
-	if valueList, found := headers[http.CanonicalHeaderKey("authorization")]; found {
-		var Authorization string
-		n := len(valueList)
-		if n != 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for authorization, got %d", n))
-		}
-
-		err = runtime.BindStyledParameterWithOptions("simple", "authorization", valueList[0], &Authorization, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: true})
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter authorization: %s", err))
-		}
-
-		params.Authorization = Authorization
-	} else {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Header parameter authorization is required, but not found"))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2Auth(ctx, params)
-	return err
-}
-
-// V2PostNameBlobsUploads converts echo context to params.
-func (w *ServerInterfaceWrapper) V2PostNameBlobsUploads(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "name" -------------
-	var name string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "name", ctx.Param("name"), &name, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter name: %s", err))
-	}
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params V2PostNameBlobsUploadsParams
-	// ------------- Optional query parameter "digest" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "digest", ctx.QueryParams(), &params.Digest)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// ------------- Optional query parameter "mount" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "mount", ctx.QueryParams(), &params.Mount)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter mount: %s", err))
-	}
-
-	// ------------- Optional query parameter "from" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "from", ctx.QueryParams(), &params.From)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter from: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2PostNameBlobsUploads(ctx, name, params)
-	return err
-}
-
-// V2GetNameBlobsUploadsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2GetNameBlobsUploadsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "name" -------------
-	var name string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "name", ctx.Param("name"), &name, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter name: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2GetNameBlobsUploadsReference(ctx, name, reference)
-	return err
-}
-
-// V2PatchNameBlobsUploadsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2PatchNameBlobsUploadsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "name" -------------
-	var name string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "name", ctx.Param("name"), &name, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter name: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2PatchNameBlobsUploadsReference(ctx, name, reference)
-	return err
-}
-
-// V2PutNameBlobsUploadsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2PutNameBlobsUploadsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "name" -------------
-	var name string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "name", ctx.Param("name"), &name, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter name: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params V2PutNameBlobsUploadsReferenceParams
-	// ------------- Optional query parameter "digest" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "digest", ctx.QueryParams(), &params.Digest)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2PutNameBlobsUploadsReference(ctx, name, reference, params)
-	return err
-}
-
-// V2GetNameReferrersDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2GetNameReferrersDigest(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "name" -------------
-	var name string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "name", ctx.Param("name"), &name, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter name: %s", err))
-	}
-
-	// ------------- Path parameter "digest" -------------
-	var digest string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params V2GetNameReferrersDigestParams
-	// ------------- Optional query parameter "artifactType" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "artifactType", ctx.QueryParams(), &params.ArtifactType)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter artifactType: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2GetNameReferrersDigest(ctx, name, digest, params)
-	return err
-}
-
-// V2GetNameTagsList converts echo context to params.
-func (w *ServerInterfaceWrapper) V2GetNameTagsList(ctx echo.Context) error {
+// V2GetCatalog converts echo context to params.
+func (w *ServerInterfaceWrapper) V2GetCatalog(ctx echo.Context) error {
 	var err error
-	// ------------- Path parameter "name" -------------
-	var name string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "name", ctx.Param("name"), &name, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter name: %s", err))
-	}
 
 	// Parameter object where we will unmarshal all parameters from the context
-	var params V2GetNameTagsListParams
+	var params V2GetCatalogParams
 	// ------------- Optional query parameter "n" -------------
 
 	err = runtime.BindQueryParameter("form", true, false, "n", ctx.QueryParams(), &params.N)
@@ -546,1087 +304,49 @@ func (w *ServerInterfaceWrapper) V2GetNameTagsList(ctx echo.Context) error {
 	}
 
 	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2GetNameTagsList(ctx, name, params)
+	err = w.Handler.V2GetCatalog(ctx, params)
 	return err
 }
 
-// V2DeleteS1BlobsDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2DeleteS1BlobsDigest(ctx echo.Context) error {
+// V2Auth converts echo context to params.
+func (w *ServerInterfaceWrapper) V2Auth(ctx echo.Context) error {
 	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "digest" -------------
-	var digest string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2DeleteS1BlobsDigest(ctx, s1, digest)
-	return err
-}
 
-// V2GetS1BlobsDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2GetS1BlobsDigest(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
+	// Parameter object where we will unmarshal all parameters from the context
+	var params V2AuthParams
+	// ------------- Optional query parameter "scope" -------------
 
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	err = runtime.BindQueryParameter("form", true, false, "scope", ctx.QueryParams(), &params.Scope)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter scope: %s", err))
 	}
 
-	// ------------- Path parameter "digest" -------------
-	var digest string
+	// ------------- Optional query parameter "service" -------------
 
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	err = runtime.BindQueryParameter("form", true, false, "service", ctx.QueryParams(), &params.Service)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter service: %s", err))
 	}
 
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2GetS1BlobsDigest(ctx, s1, digest)
-	return err
-}
-
-// V2HeadS1BlobsDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2HeadS1BlobsDigest(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "authorization" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("authorization")]; found {
+		var Authorization string
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for authorization, got %d", n))
+		}
 
-	// ------------- Path parameter "digest" -------------
-	var digest string
+		err = runtime.BindStyledParameterWithOptions("simple", "authorization", valueList[0], &Authorization, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter authorization: %s", err))
+		}
 
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
+		params.Authorization = &Authorization
 	}
 
 	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2HeadS1BlobsDigest(ctx, s1, digest)
-	return err
-}
-
-// V2DeleteS1ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2DeleteS1ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2DeleteS1ManifestsReference(ctx, s1, reference)
-	return err
-}
-
-// V2GetS1ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2GetS1ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params V2GetS1ManifestsReferenceParams
-	// ------------- Optional query parameter "ns" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "ns", ctx.QueryParams(), &params.Ns)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter ns: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2GetS1ManifestsReference(ctx, s1, reference, params)
-	return err
-}
-
-// V2HeadS1ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2HeadS1ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params V2HeadS1ManifestsReferenceParams
-	// ------------- Optional query parameter "ns" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "ns", ctx.QueryParams(), &params.Ns)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter ns: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2HeadS1ManifestsReference(ctx, s1, reference, params)
-	return err
-}
-
-// V2PutS1ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2PutS1ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2PutS1ManifestsReference(ctx, s1, reference)
-	return err
-}
-
-// V2DeleteS1S2BlobsDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2DeleteS1S2BlobsDigest(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "digest" -------------
-	var digest string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2DeleteS1S2BlobsDigest(ctx, s1, s2, digest)
-	return err
-}
-
-// V2GetS1S2BlobsDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2GetS1S2BlobsDigest(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "digest" -------------
-	var digest string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2GetS1S2BlobsDigest(ctx, s1, s2, digest)
-	return err
-}
-
-// V2HeadS1S2BlobsDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2HeadS1S2BlobsDigest(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "digest" -------------
-	var digest string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2HeadS1S2BlobsDigest(ctx, s1, s2, digest)
-	return err
-}
-
-// V2DeleteS1S2ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2DeleteS1S2ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2DeleteS1S2ManifestsReference(ctx, s1, s2, reference)
-	return err
-}
-
-// V2GetS1S2ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2GetS1S2ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params V2GetS1S2ManifestsReferenceParams
-	// ------------- Optional query parameter "ns" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "ns", ctx.QueryParams(), &params.Ns)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter ns: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2GetS1S2ManifestsReference(ctx, s1, s2, reference, params)
-	return err
-}
-
-// V2HeadS1S2ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2HeadS1S2ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params V2HeadS1S2ManifestsReferenceParams
-	// ------------- Optional query parameter "ns" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "ns", ctx.QueryParams(), &params.Ns)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter ns: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2HeadS1S2ManifestsReference(ctx, s1, s2, reference, params)
-	return err
-}
-
-// V2PutS1S2ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2PutS1S2ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2PutS1S2ManifestsReference(ctx, s1, s2, reference)
-	return err
-}
-
-// V2DeleteS1S2S3BlobsDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2DeleteS1S2S3BlobsDigest(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "digest" -------------
-	var digest string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2DeleteS1S2S3BlobsDigest(ctx, s1, s2, s3, digest)
-	return err
-}
-
-// V2GetS1S2S3BlobsDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2GetS1S2S3BlobsDigest(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "digest" -------------
-	var digest string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2GetS1S2S3BlobsDigest(ctx, s1, s2, s3, digest)
-	return err
-}
-
-// V2HeadS1S2S3BlobsDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2HeadS1S2S3BlobsDigest(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "digest" -------------
-	var digest string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2HeadS1S2S3BlobsDigest(ctx, s1, s2, s3, digest)
-	return err
-}
-
-// V2DeleteS1S2S3ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2DeleteS1S2S3ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2DeleteS1S2S3ManifestsReference(ctx, s1, s2, s3, reference)
-	return err
-}
-
-// V2GetS1S2S3ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2GetS1S2S3ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params V2GetS1S2S3ManifestsReferenceParams
-	// ------------- Optional query parameter "ns" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "ns", ctx.QueryParams(), &params.Ns)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter ns: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2GetS1S2S3ManifestsReference(ctx, s1, s2, s3, reference, params)
-	return err
-}
-
-// V2HeadS1S2S3ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2HeadS1S2S3ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params V2HeadS1S2S3ManifestsReferenceParams
-	// ------------- Optional query parameter "ns" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "ns", ctx.QueryParams(), &params.Ns)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter ns: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2HeadS1S2S3ManifestsReference(ctx, s1, s2, s3, reference, params)
-	return err
-}
-
-// V2PutS1S2S3ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2PutS1S2S3ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2PutS1S2S3ManifestsReference(ctx, s1, s2, s3, reference)
-	return err
-}
-
-// V2DeleteS1S2S3S4BlobsDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2DeleteS1S2S3S4BlobsDigest(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "s4" -------------
-	var s4 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s4", ctx.Param("s4"), &s4, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s4: %s", err))
-	}
-
-	// ------------- Path parameter "digest" -------------
-	var digest string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2DeleteS1S2S3S4BlobsDigest(ctx, s1, s2, s3, s4, digest)
-	return err
-}
-
-// V2GetS1S2S3S4BlobsDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2GetS1S2S3S4BlobsDigest(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "s4" -------------
-	var s4 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s4", ctx.Param("s4"), &s4, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s4: %s", err))
-	}
-
-	// ------------- Path parameter "digest" -------------
-	var digest string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2GetS1S2S3S4BlobsDigest(ctx, s1, s2, s3, s4, digest)
-	return err
-}
-
-// V2HeadS1S2S3S4BlobsDigest converts echo context to params.
-func (w *ServerInterfaceWrapper) V2HeadS1S2S3S4BlobsDigest(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "s4" -------------
-	var s4 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s4", ctx.Param("s4"), &s4, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s4: %s", err))
-	}
-
-	// ------------- Path parameter "digest" -------------
-	var digest string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "digest", ctx.Param("digest"), &digest, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter digest: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2HeadS1S2S3S4BlobsDigest(ctx, s1, s2, s3, s4, digest)
-	return err
-}
-
-// V2DeleteS1S2S3S4ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2DeleteS1S2S3S4ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "s4" -------------
-	var s4 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s4", ctx.Param("s4"), &s4, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s4: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2DeleteS1S2S3S4ManifestsReference(ctx, s1, s2, s3, s4, reference)
-	return err
-}
-
-// V2GetS1S2S3S4ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2GetS1S2S3S4ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "s4" -------------
-	var s4 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s4", ctx.Param("s4"), &s4, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s4: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params V2GetS1S2S3S4ManifestsReferenceParams
-	// ------------- Optional query parameter "ns" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "ns", ctx.QueryParams(), &params.Ns)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter ns: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2GetS1S2S3S4ManifestsReference(ctx, s1, s2, s3, s4, reference, params)
-	return err
-}
-
-// V2HeadS1S2S3S4ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2HeadS1S2S3S4ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "s4" -------------
-	var s4 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s4", ctx.Param("s4"), &s4, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s4: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params V2HeadS1S2S3S4ManifestsReferenceParams
-	// ------------- Optional query parameter "ns" -------------
-
-	err = runtime.BindQueryParameter("form", true, false, "ns", ctx.QueryParams(), &params.Ns)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter ns: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2HeadS1S2S3S4ManifestsReference(ctx, s1, s2, s3, s4, reference, params)
-	return err
-}
-
-// V2PutS1S2S3S4ManifestsReference converts echo context to params.
-func (w *ServerInterfaceWrapper) V2PutS1S2S3S4ManifestsReference(ctx echo.Context) error {
-	var err error
-	// ------------- Path parameter "s1" -------------
-	var s1 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s1", ctx.Param("s1"), &s1, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s1: %s", err))
-	}
-
-	// ------------- Path parameter "s2" -------------
-	var s2 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s2", ctx.Param("s2"), &s2, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s2: %s", err))
-	}
-
-	// ------------- Path parameter "s3" -------------
-	var s3 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s3", ctx.Param("s3"), &s3, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s3: %s", err))
-	}
-
-	// ------------- Path parameter "s4" -------------
-	var s4 string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "s4", ctx.Param("s4"), &s4, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter s4: %s", err))
-	}
-
-	// ------------- Path parameter "reference" -------------
-	var reference string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "reference", ctx.Param("reference"), &reference, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter reference: %s", err))
-	}
-
-	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.V2PutS1S2S3S4ManifestsReference(ctx, s1, s2, s3, s4, reference)
+	err = w.Handler.V2Auth(ctx, params)
 	return err
 }
 
@@ -1660,72 +380,35 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 
 	router.GET(baseURL+"/", wrapper.Root)
 	router.GET(baseURL+"/cmd/blob/list", wrapper.CmdBloblist)
+	router.GET(baseURL+"/cmd/breaker/list", wrapper.CmdBreakerlist)
 	router.GET(baseURL+"/cmd/image/list", wrapper.CmdImagelist)
 	router.GET(baseURL+"/cmd/manifest/list", wrapper.CmdManifestlist)
+	router.DELETE(baseURL+"/cmd/negative/clear", wrapper.CmdNegativeclear)
+	router.GET(baseURL+"/cmd/negative/list", wrapper.CmdNegativelist)
 	router.DELETE(baseURL+"/cmd/prune", wrapper.CmdPrune)
 	router.GET(baseURL+"/cmd/stop", wrapper.CmdStop)
 	router.GET(baseURL+"/v2/", wrapper.V2Default)
 	router.HEAD(baseURL+"/v2/", wrapper.V2HeadDefault)
+	router.GET(baseURL+"/v2/_catalog", wrapper.V2GetCatalog)
 	router.GET(baseURL+"/v2/auth", wrapper.V2Auth)
-	router.POST(baseURL+"/v2/:name/blobs/uploads", wrapper.V2PostNameBlobsUploads)
-	router.GET(baseURL+"/v2/:name/blobs/uploads/:reference", wrapper.V2GetNameBlobsUploadsReference)
-	router.PATCH(baseURL+"/v2/:name/blobs/uploads/:reference", wrapper.V2PatchNameBlobsUploadsReference)
-	router.PUT(baseURL+"/v2/:name/blobs/uploads/:reference", wrapper.V2PutNameBlobsUploadsReference)
-	router.GET(baseURL+"/v2/:name/referrers/:digest", wrapper.V2GetNameReferrersDigest)
-	router.GET(baseURL+"/v2/:name/tags/list", wrapper.V2GetNameTagsList)
-	router.DELETE(baseURL+"/v2/:s1/blobs/:digest", wrapper.V2DeleteS1BlobsDigest)
-	router.GET(baseURL+"/v2/:s1/blobs/:digest", wrapper.V2GetS1BlobsDigest)
-	router.HEAD(baseURL+"/v2/:s1/blobs/:digest", wrapper.V2HeadS1BlobsDigest)
-	router.DELETE(baseURL+"/v2/:s1/manifests/:reference", wrapper.V2DeleteS1ManifestsReference)
-	router.GET(baseURL+"/v2/:s1/manifests/:reference", wrapper.V2GetS1ManifestsReference)
-	router.HEAD(baseURL+"/v2/:s1/manifests/:reference", wrapper.V2HeadS1ManifestsReference)
-	router.PUT(baseURL+"/v2/:s1/manifests/:reference", wrapper.V2PutS1ManifestsReference)
-	router.DELETE(baseURL+"/v2/:s1/:s2/blobs/:digest", wrapper.V2DeleteS1S2BlobsDigest)
-	router.GET(baseURL+"/v2/:s1/:s2/blobs/:digest", wrapper.V2GetS1S2BlobsDigest)
-	router.HEAD(baseURL+"/v2/:s1/:s2/blobs/:digest", wrapper.V2HeadS1S2BlobsDigest)
-	router.DELETE(baseURL+"/v2/:s1/:s2/manifests/:reference", wrapper.V2DeleteS1S2ManifestsReference)
-	router.GET(baseURL+"/v2/:s1/:s2/manifests/:reference", wrapper.V2GetS1S2ManifestsReference)
-	router.HEAD(baseURL+"/v2/:s1/:s2/manifests/:reference", wrapper.V2HeadS1S2ManifestsReference)
-	router.PUT(baseURL+"/v2/:s1/:s2/manifests/:reference", wrapper.V2PutS1S2ManifestsReference)
-	router.DELETE(baseURL+"/v2/:s1/:s2/:s3/blobs/:digest", wrapper.V2DeleteS1S2S3BlobsDigest)
-	router.GET(baseURL+"/v2/:s1/:s2/:s3/blobs/:digest", wrapper.V2GetS1S2S3BlobsDigest)
-	router.HEAD(baseURL+"/v2/:s1/:s2/:s3/blobs/:digest", wrapper.V2HeadS1S2S3BlobsDigest)
-	router.DELETE(baseURL+"/v2/:s1/:s2/:s3/manifests/:reference", wrapper.V2DeleteS1S2S3ManifestsReference)
-	router.GET(baseURL+"/v2/:s1/:s2/:s3/manifests/:reference", wrapper.V2GetS1S2S3ManifestsReference)
-	router.HEAD(baseURL+"/v2/:s1/:s2/:s3/manifests/:reference", wrapper.V2HeadS1S2S3ManifestsReference)
-	router.PUT(baseURL+"/v2/:s1/:s2/:s3/manifests/:reference", wrapper.V2PutS1S2S3ManifestsReference)
-	router.DELETE(baseURL+"/v2/:s1/:s2/:s3/:s4/blobs/:digest", wrapper.V2DeleteS1S2S3S4BlobsDigest)
-	router.GET(baseURL+"/v2/:s1/:s2/:s3/:s4/blobs/:digest", wrapper.V2GetS1S2S3S4BlobsDigest)
-	router.HEAD(baseURL+"/v2/:s1/:s2/:s3/:s4/blobs/:digest", wrapper.V2HeadS1S2S3S4BlobsDigest)
-	router.DELETE(baseURL+"/v2/:s1/:s2/:s3/:s4/manifests/:reference", wrapper.V2DeleteS1S2S3S4ManifestsReference)
-	router.GET(baseURL+"/v2/:s1/:s2/:s3/:s4/manifests/:reference", wrapper.V2GetS1S2S3S4ManifestsReference)
-	router.HEAD(baseURL+"/v2/:s1/:s2/:s3/:s4/manifests/:reference", wrapper.V2HeadS1S2S3S4ManifestsReference)
-	router.PUT(baseURL+"/v2/:s1/:s2/:s3/:s4/manifests/:reference", wrapper.V2PutS1S2S3S4ManifestsReference)
 
 }
 
 // Base64 encoded, gzipped, json marshaled Swagger object
 var swaggerSpec = []string{
 
-	"H4sIAAAAAAAC/+xYW2/bNhT+KwKfNkC1ZFnbg5/WJUVrIE2CKNvLsAeaOpYISCTLS1bX0H8fqNhdOku2",
-	"JSvxTS+JE5KHPN93Lt/xAsFXDZLh7JoThcYLFIMikgpNOUNj9JHqT2bqKG4kAYfwGJCLjMzQGKVaCzX2",
-	"vITq1EwHhOceJgCSEo8TKiGhSss5KlxE2Yxb04QzjYm2HyHH1BrBQnybJ/PfhOSas0EOdv+PT7g3WfaO",
-	"s2zuCPtJp5KbJHUIJilliXN3NXGu7VV0auwJJwL5BBK5KKMEmAJ7HcM5oDH6PHl0bp7/6/yU85jOKMQ/",
-	"r3kk8T+DZ6+MAmlfDUzXOehJmCkvBRwrL8eUeTeTqw+30QfriAaZq7uZfREl9gG3nFkANdWZ/dO+/WFp",
-	"x3nn3Alg7+8nzmjgIxc9gVTPCAwH/sC39rgAhgVFYzQa+IMRcpHAOi1p8+yPBEpwuQCJLRaTGI3RA+ca",
-	"uUiCEpwpKHcHvr/ONSqKonCRR/LYm2Z86mVU6VqzV3n8e8an5R77Dolz0CAVGv+1QNTa+2JAzpG7Al+Z",
-	"qdKWF0VSyLG1qOeiXNGSsgQVhVt9knDDdNVByjQkIFFR/L2bgy4K6xfCzZDQHCewFZOJ3bU7KAJrm35t",
-	"UIlpAkqfLp45ZnQGSm+F9PNy45ugehzYCGkYPG/JQEMlLPflnp3wKF2wr/5iqIQYjbU00CbkTKv8ha+i",
-	"1blYzh/MCbOoNBebAjuy600q81NQX+b/DK5hhk22a63f6Niw+g0usn2u6u5PgOPXv3+JATY63YDDe7tc",
-	"nRf2+aU2WMaJtcQl/VYe7yJDFOFlqjU/uNQIm442CNnNAC7spUXZ5JVnRMZxXBoUXFUies+VvsU52Iav",
-	"/ljur0bYCpL/vCp/dVF4Wve6vK4SbDs4kzxvyMawmo3AD7qsLDX8eQsJM5DACBQbkuMjrDH5sDr3upT+",
-	"aEa+uHR3W+uYhw0RLCUzSSuj3C6cFThBI3BcFA5/rUXNVFcGc8rh1L7a7Jz8++Z46ZgEqbzF87N2SO6H",
-	"1ZnrlSNvxsN36PYmAUtNZ5jox7norCvuR4XGido8rHxn4BEn6oa+NvT/Q6yVUs5w40hvi6IarlrWy1Cu",
-	"m3CsnrUr0bAsLg1CWQ0PE8gd1F//l7r6Wx9vpwyQ37h7bxpALgmJl0m1+iZlTQNuz63VdysNu3YnuL26",
-	"yvHfJv1OD8O6BqLQYdO3R7LBGFMryM8jq4d7FMSFCtpJjSg4SAdRwYUplovHuevK2QO6b7nYQ0RFwSEL",
-	"biccnIsWu1gqjlXS9YQclTLsS1V3AnOhRm1VZjQ63XatRhcnVnu6jlPz9rwchXQuC+Fe+jkanXxb6oTP",
-	"85HhPaPnqeZ7Xs9xKOhZPcbZYqHC9gNGFF64NlLhBc4pPevhOY87Pb3heU1NZYnfc3SKwr55dxYb5zSB",
-	"9YFx8MA45kGuD48LDo9t82AfHEfYVBqPlUXxbwAAAP//kwCp3+E/AAA=",
+	"H4sIAAAAAAAC/9yWW0/cOhDHv0o0T+dIIVkWnvJ0OAuClbgJqr5UVeV1ZhOrjm3G4y0LynevnF2EKvZG",
+	"SqvSFxTW/xnP/ze+PQLeM5IR+thKD8UjlOglKcfKGijgVPFZmCTeBpKYSFsipBBIQwE1s/NFnleK6zDJ",
+	"pG1yIRFJydxKRVgpzzSHNgVlpjamltawkBw/sREqJhHOPcyr+X+OLFuTNRj1P5ZwHbTes0bPExe/uCYb",
+	"qjqRQtbKVMnVaJwcx6nUJMSI5BZphgQpaCXReIzTGdEgFHAx/pCcL35N/mlsqaYKy39fOCLxLVu4Ch4p",
+	"Vo2G1xnMCac+r1GUPm+EMvn5eHRyeXsSjTBS46+msSIlEQq4tAYhBVas47+x9ptlnmQvuXJojq7HyUE2",
+	"gBRmSH5BYD8bZIOYzzo0wiko4CAbZAeQghNcd23L458KO7jWIYnIYlxCATfWMqRA6J01Hjv1cDB42Wto",
+	"27ZNIZdNmU+0neRaeV6bdtSU/2s76TSxDhINMpKH4tMjqJjvLiDNIX2C78PEM0EKXtbYiJiR564bYVKm",
+	"grZNV0dKGwyvClSGsUKCtv28m8EUDtcPHG5BQii+Im2nstAtwbwOu2pEhVtnGEfV7uCd4LjF+5AvVYWe",
+	"32/PGmHUFD1vRXqxFP4Wqn8GG4OVYDXDXGoUtNBqZFzJ53IpXmjfDNDP2lzhZlunn5z8LZ1+RuAoGNzS",
+	"x+tOs5PtrtJY3F1QhCUUTAH7nCGh16GP965XXEnzm/COt6Vn6zat39s4/pp7ZTZc/zb4ODzGqQh615tq",
+	"o7H91TWkEB9Hq+Y+Q1H++vmXDL5IwULbagOLU+TRUrTTDum1yrTYcqG+zYEwG+YicL3B7VEcXu0zNgzp",
+	"ueaYyZJ66ML7mPbSOuwVuHw6vxGwdUukbb8PAC16v7oJDQAA",
 }
 
 // GetSwagger returns the content of the embedded swagger specification file
@@ -21,6 +21,8 @@ import (
 	"github.com/aceeric/ociregistry/impl/globals"
 	"github.com/aceeric/ociregistry/impl/metrics"
 	"github.com/aceeric/ociregistry/impl/preload"
+	"github.com/aceeric/ociregistry/impl/serverauth"
+	"github.com/aceeric/ociregistry/impl/upload"
 
 	"github.com/labstack/echo/v4"
 	middleware "github.com/oapi-codegen/echo-middleware"
@@ -70,6 +72,10 @@ func Serve(buildVer string, buildDtm string) error {
 		}
 	}
 
+	if err := serverauth.Init(config.GetServerAuthCfg()); err != nil {
+		return fmt.Errorf("error initializing server auth: %s", err)
+	}
+
 	// clear out the servers array in the swagger spec, that skips validating
 	// that server names match. We don't know how this thing will be run.
 	swagger.Servers = nil
@@ -86,11 +92,18 @@ func Serve(buildVer string, buildDtm string) error {
 	e := echo.New()
 	e.HideBanner = true
 	e.HidePort = true
+	e.HTTPErrorHandler = impl.HTTPErrorHandler
+
+	// Authenticate clients if server auth is configured, and check the policy for the /cmd endpoints.
+	e.Use(impl.Authenticate)
+	e.Use(ociRegistry.AuthorizeCmd)
 
-	// Use our validation middleware to check all requests against the OpenAPI schema.
-	e.Use(middleware.OapiRequestValidator(swagger))
+	// Use our validation middleware to check all requests against the OpenAPI schema, except
+	// for the repository endpoints which are not in the schema.
+	e.Use(middleware.OapiRequestValidatorWithOptions(swagger, &middleware.Options{Skipper: impl.SkipValidation}))
 
 	api.RegisterHandlers(e, ociRegistry)
+	impl.RegisterRepositoryHandlers(e, ociRegistry)
 
 	e.Use(globals.GetEchoLoggingFunc())
 
@@ -104,6 +117,13 @@ func Serve(buildVer string, buildDtm string) error {
 		return fmt.Errorf("error loading the image cache: %s", err)
 	}
 
+	if err := upload.Clean(config.GetImagePath()); err != nil {
+		return fmt.Errorf("error removing incomplete uploads: %s", err)
+	}
+	if config.GetHostedConfig().Enabled {
+		go expireUploads(config.GetImagePath())
+	}
+
 	fmt.Fprintf(os.Stderr, startupBanner, buildVer, buildDtm, time.Unix(0, time.Now().UnixNano()), config.GetPort(),
 		os.Getuid(), os.Getgid(), os.Getpid(), tlsMsg(), strings.Join(os.Args, " "))
 
@@ -189,6 +209,18 @@ func health() {
 	}
 }
 
+// expireUploads periodically removes abandoned uploads and uploaded blobs that no pushed
+// manifest references.
+func expireUploads(imagePath string) {
+	ttl := upload.Ttl()
+	ticker := time.NewTicker(max(ttl/10, time.Second))
+	defer ticker.Stop()
+	for range ticker.C {
+		upload.Expire()
+		cache.ExpireUploadedBlobs(imagePath, ttl)
+	}
+}
+
 // getEchoListener gets the Echo listener. Supports unit testing.
 func getEchoListener(e *echo.Echo) net.Listener {
 	if e.Listener != nil {
@@ -18,6 +18,7 @@ require (
 require (
 	github.com/aceeric/imgpull v1.15.1
 	github.com/opencontainers/go-digest v1.0.0
+	golang.org/x/crypto v0.54.0
 )
 
 require (
@@ -53,7 +54,6 @@ require (
 	github.com/santhosh-tekuri/jsonschema/v6 v6.0.2 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
-	golang.org/x/crypto v0.54.0 // indirect
 	golang.org/x/net v0.57.0 // indirect
 	golang.org/x/sys v0.47.0 // indirect
 	golang.org/x/text v0.40.0 // indirect
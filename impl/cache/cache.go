@@ -1,9 +1,14 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,21 +18,49 @@ import (
 	"github.com/aceeric/ociregistry/impl/metrics"
 	"github.com/aceeric/ociregistry/impl/pullrequest"
 	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/impl/upstream"
 
 	"github.com/aceeric/imgpull/pkg/imgpull"
 	log "github.com/sirupsen/logrus"
 )
 
 // Type concurrentPulls handles the case where multiple goroutines request a manifest from
-// an upstream concurrently. When that happens, the first-in goroutine will actually do
-// the pull and all other goroutines will wait on the first puller. The thing to know is
+// an upstream concurrently. When that happens, the first-in goroutine starts the pull and
+// all the goroutines wait on the same pull - see 'pull'. The thing to know is
 // that pulling an image manifest *also* pulls the image blobs. This can be relatively
 // time-consuming may use a lot of network bandwidth. This type avoids multiple goroutines
 // pulling the same manifests (and hence blobs) from the upstream at the same time, thus
 // more efficiently utilizing system resources.
 type concurrentPulls struct {
 	sync.Mutex
-	pulls map[string][]chan bool
+	pulls map[string]*pull
+}
+
+// Type pull is one pull from an upstream that is in progress, shared by every goroutine that
+// requests the same manifest while it is in progress. The pull runs in its own goroutine with
+// a context that has the pull timeout as its deadline so that it is not tied to any one client.
+type pull struct {
+	// ctx is the context of the pull and cancel cancels it. Raise raises the priority of the
+	// pull for the concurrent pull limits.
+	ctx    context.Context
+	cancel context.CancelFunc
+	raise  func(upstream.Priority)
+	// done is closed when the pull completes or its deadline passes, after mh and err are set
+	done chan struct{}
+	once sync.Once
+	mh   imgpull.ManifestHolder
+	err  error
+	// waiters is the count of goroutines waiting for the pull whose clients haven't gone away
+	waiters int
+	// prev is the pull of the same url that was still in progress past its deadline when this
+	// pull was started. This pull doesn't go to the upstream until prev returns.
+	prev *pull
+	// returned is closed when the pull function returns, after result, resultErr, and expired
+	// are set. Expired is true if the deadline passed before the pull function returned.
+	returned  chan struct{}
+	result    imgpull.ManifestHolder
+	resultErr error
+	expired   bool
 }
 
 // Type manifestCache is the in-mem representation of the manifest cache. The key of each
@@ -45,21 +78,21 @@ type manifestCache struct {
 // blob. When a manifest is added the ref count is inc'd and when a manifest is removed the ref
 // count is dec'd. This ref count is used to prune the blobs. A blob with no refs can be safely
 // removed. Blobs are downloaded from upstreams infrequently, but pulled frequently, so reads
-// vastly outnumber updates or deletes. Hence a RWMutex for a little better concurrency.
+// vastly outnumber updates or deletes. Hence a RWMutex for a little better concurrency. Blobs
+// uploaded to the hosted namespace have no refs until a manifest that references them is pushed,
+// so until then they are in the uploaded map, with the time of the upload.
 type blobCache struct {
 	sync.RWMutex
-	blobs map[string]int
+	blobs    map[string]int
+	uploaded map[string]time.Time
 }
 
 var (
 	// cp has pulls in progress. Will only have entries when a pull from an upstream is actively
-	// ocurring otherwise empty. The key is a manifest url, and the value is a map of channels for
-	// parked goroutines waiting for the image to be pulled by a pulling goroutine. If only the
-	// pulling goroutine is running then the map of channels is empty. The instant any other goroutine
-	// concurrently requests the image, a channel is created for that goroutine and added to the map
-	// and each parked goroutine will wait to be signaled on its channel.
+	// ocurring otherwise empty. The key is a manifest url, and the value is the pull that every
+	// goroutine requesting the url waits on.
 	cp concurrentPulls = concurrentPulls{
-		pulls: make(map[string][]chan bool),
+		pulls: make(map[string]*pull),
 	}
 	// mc is the manifest in-mem cache, keyed by url. When a manifest is pulled by tag
 	// it is placed in the map twice for efficient retrieval - once by tag and a second
@@ -71,8 +104,12 @@ var (
 	// bc is the blob cache, keyed by digest with a ref count of cached manifests that
 	// reference each blob
 	bc blobCache = blobCache{
-		blobs: map[string]int{},
+		blobs:    map[string]int{},
+		uploaded: map[string]time.Time{},
 	}
+	// background has the pulls that the server starts on its own - the platform pulls of a
+	// manifest list and the revalidations of tags - so they can be waited for
+	background          sync.WaitGroup
 	emptyManifestHolder = imgpull.ManifestHolder{}
 )
 
@@ -81,48 +118,110 @@ var (
 // function blocks until the pull is complete and then the manifest is added to the in-mem cache and
 // returned. An error is returned if the manifest can't be pulled or times out. If an *image* manifest
 // is requested and it is not already cached, then all the blobs for the image will also be pulled from
-// the upstream and added to the blob cache.
+// the upstream and added to the blob cache - unless the server is configured to pull blobs lazily in
+// which case the blobs are pulled by FetchBlob when they are first requested. If a manifest list is
+// pulled, then the image manifests for the platforms configured for the upstream are pulled in the
+// background - see 'pullPlatforms'.
+//
+// If multiple goroutines request to pull the same image at the same time, then only one pull from the
+// upstream is performed, and all the goroutines wait for it and get its result - the manifest, or the
+// error the pull failed with. The pull runs in its own goroutine and has a deadline of pullTimeout
+// milliseconds. The passed context is the context of the client: if it is done then the function
+// returns without waiting for the pull, and if the server is configured to cancel pulls then the pull
+// is cancelled once every goroutine waiting for it has returned that way.
 //
-// If multiple goroutines request to pull the same image at the same time, then only the first goroutine
-// will actually perform the pull, and all other goroutines will wait for the first goroutine to complete
-// the pull and add the image to the cache. Then, the waiting goroutine(s) will simply get the manifest
-// from the cache entry created by the first goroutine.
-func GetManifest(pr pullrequest.PullRequest, imagePath string, pullTimeout int, forcePull bool) (imgpull.ManifestHolder, error) {
+// If negative caching is enabled then a url that the upstream answered with a 404, 401, or 403 is
+// remembered for the configured ttl, and the error is returned without contacting the upstream
+// until the entry expires - see 'negativeLookup'.
+//
+// If forcePull is true and the upstream is unavailable - including when the circuit breaker for the
+// registry is open - then the cached manifest, if there is one, is returned rather than the error.
+func GetManifest(ctx context.Context, pr pullrequest.PullRequest, imagePath string, pullTimeout int, forcePull bool) (imgpull.ManifestHolder, error) {
 	url := pr.Url()
-	if mh, ch, exists := getManifestOrEnqueue(pr, imagePath, forcePull); exists {
-		log.Infof("serving manifest from cache: %q", url)
-		metrics.IncCachedPullsByNs(pr.Remote)
-		return mh, nil
-	} else if ch == nil {
-		log.Infof("pulling manifest from upstream: %q", url)
-		defer signalWaiters(url)
-		mh, err := doPull(pr, imagePath)
-		if err != nil {
-			log.Errorf("doPull failed for %q: %s", url, err)
-			return emptyManifestHolder, err
+	if !forcePull {
+		if mh, exists := getManifestFromCache(pr, imagePath); exists {
+			log.Infof("serving manifest from cache: %q", url)
+			metrics.IncCachedPullsByNs(pr.Remote)
+			return mh, nil
 		}
-		if forcePull {
-			if err := replaceInCache(pr, mh, imagePath); err != nil {
-				return emptyManifestHolder, err
-			}
-		} else {
-			if err := addToCache(pr, mh, imagePath); err != nil {
-				return emptyManifestHolder, err
+	}
+	p, started := joinPull(url, pullTimeout, upstream.PriorityOf(ctx))
+	if started {
+		go p.run(url, func(ctx context.Context) (imgpull.ManifestHolder, error) {
+			return pullToCache(ctx, pr, imagePath, pullTimeout, forcePull)
+		})
+	}
+	mh, err := p.wait(ctx, url)
+	if err != nil {
+		if forcePull && ctx.Err() == nil && upstream.Unavailable(err) {
+			if mh, exists := getManifestFromCache(pr, imagePath); exists {
+				log.Warnf("upstream unavailable, serving manifest from cache: %q", url)
+				metrics.IncCachedPullsByNs(pr.Remote)
+				return mh, nil
 			}
 		}
-		return mh, nil
+		return emptyManifestHolder, err
+	}
+	if !started {
+		log.Infof("serving manifest from cache (after wait): %q", url)
+		metrics.IncCachedPullsByNs(pr.Remote)
+	}
+	return mh, nil
+}
+
+// pullToCache implements the pull for 'GetManifest' with the passed context. The pulled manifest
+// is added to the cache, or replaces the cached manifest if forcePull is true.
+func pullToCache(ctx context.Context, pr pullrequest.PullRequest, imagePath string, pullTimeout int, forcePull bool) (imgpull.ManifestHolder, error) {
+	url := pr.Url()
+	if err := negativeLookup(pr); err != nil {
+		log.Infof("serving error from negative cache: %q", url)
+		metrics.IncNegativeCacheHitsByNs(pr.Remote)
+		return emptyManifestHolder, err
+	}
+	log.Infof("pulling manifest from upstream: %q", url)
+	mh, err := doPull(ctx, pr, imagePath)
+	if err != nil {
+		log.Errorf("doPull failed for %q: %s", url, err)
+		addNegative(pr, err)
+		return emptyManifestHolder, err
+	}
+	if forcePull {
+		if err := replaceInCache(pr, mh, imagePath); err != nil {
+			return emptyManifestHolder, err
+		}
+		setChecked(url)
 	} else {
-		select {
-		case <-ch:
-			log.Infof("serving manifest from cache (after wait): %q", url)
-			metrics.IncCachedPullsByNs(pr.Remote)
-			mh, exists := getManifestFromCache(pr, imagePath)
-			if !exists {
-				return emptyManifestHolder, fmt.Errorf("manifest not found (after wait) %q", url)
-			}
-			return mh, nil
-		case <-time.After(time.Duration(pullTimeout) * time.Millisecond):
-			return emptyManifestHolder, fmt.Errorf("timeout exceeded (%d millis) waiting for signal on %q", pullTimeout, url)
+		if err := addToCache(pr, mh, imagePath); err != nil {
+			return emptyManifestHolder, err
+		}
+	}
+	if mh.IsManifestList() {
+		background.Go(func() {
+			pullPlatforms(pr, mh, imagePath, pullTimeout)
+		})
+	}
+	return mh, nil
+}
+
+// pullPlatforms pulls the image manifests - and blobs - from the passed manifest list for
+// each platform configured for the upstream so a cluster with nodes of more than one platform
+// gets cache hits for every platform, including when air-gapped later. The pulls are done the
+// same way as a client pull, so they are shared with any concurrent client pulls of the same
+// image manifests, and image manifests that are already cached are not pulled again.
+func pullPlatforms(pr pullrequest.PullRequest, mh imgpull.ManifestHolder, imagePath string, pullTimeout int) {
+	platforms := config.PlatformsFor(pr.Remote)
+	if len(platforms) == 0 {
+		return
+	}
+	for _, digest := range helpers.PlatformDigests(mh, platforms) {
+		ipr, err := pullrequest.NewPullRequestFromUrl(pr.UrlWithDigest(digest))
+		if err != nil {
+			log.Errorf("unable to parse image manifest url %q, the error was: %s", pr.UrlWithDigest(digest), err)
+			continue
+		}
+		ctx := upstream.WithPriority(context.Background(), upstream.PriorityPrefetch)
+		if _, err := GetManifest(ctx, ipr, imagePath, pullTimeout, false); err != nil {
+			log.Errorf("error pulling platform image manifest %q, the error was: %s", ipr.Url(), err)
 		}
 	}
 }
@@ -138,48 +237,121 @@ func GetBlob(digest string) int {
 }
 
 // doPull gets an image list manifest - or image manifest - from an upstream OCI distribution
-// server. If an image manifest is pulled, then all the blobs for the image manifest are
-// also pulled. On return, the pulled manifest will have been serialized to the file system by
-// the function (along with blobs, if an image manifest.)
-func doPull(pr pullrequest.PullRequest, imagePath string) (imgpull.ManifestHolder, error) {
-	metrics.IncUpstreamPullsByNs(pr.Remote)
-	opts, err := config.ConfigFor(pr.Remote)
+// server. If an image manifest is pulled, then all the blobs for the image manifest are also
+// pulled, or marked pending if blobs are pulled lazily. On return, the pulled manifest will have
+// been serialized to the file system by the function (along with blobs, if an image manifest.) If
+// the upstream answered with an HTTP error status then the returned error is an upstream.Error. If
+// the registry has mirrors then each mirror is tried in order until one succeeds, and the error
+// from the last one is returned if none do. If the circuit breaker for the registry is open then
+// the pull fails fast without contacting the upstream. The pull waits for the concurrent pull
+// limits with the priority of the passed context - see 'upstream.Acquire'. If the passed context is
+// done - cancelled or past its deadline - then the pull stops without trying the other mirrors and
+// the failure is not held against the endpoint or the registry. An endpoint whose pulls are held
+// back because it is out of pull quota is skipped - see 'upstream.ProbeRateLimit'.
+func doPull(ctx context.Context, pr pullrequest.PullRequest, imagePath string) (imgpull.ManifestHolder, error) {
+	if err := upstream.CheckBreaker(pr.Remote); err != nil {
+		return emptyManifestHolder, err
+	}
+	release, err := upstream.Acquire(ctx, pr.Remote)
 	if err != nil {
 		return emptyManifestHolder, err
 	}
-	opts.Url = pr.Url()
-	puller, err := imgpull.NewPullerWith(opts)
+	defer release()
+	metrics.IncUpstreamPullsByNs(pr.Remote)
+	for _, ep := range upstream.Endpoints(pr.Remote) {
+		var mh imgpull.ManifestHolder
+		if mh, err = pullFrom(ctx, pr, ep, imagePath); err != nil {
+			if ctx.Err() != nil {
+				return emptyManifestHolder, err
+			} else if !errors.Is(err, upstream.ErrRateLimited) {
+				// an endpoint that is held back didn't fail
+				upstream.RecordRateLimitError(ep.Host(), err)
+				ep.Failed(err)
+			}
+			continue
+		}
+		ep.Succeeded()
+		upstream.RecordRateLimitError(ep.Host(), nil)
+		upstream.RecordPull(pr.Remote, nil)
+		if ep.Mirror != "" {
+			log.Infof("pulled %q from mirror %s", pr.Url(), ep.Mirror)
+		}
+		metrics.IncUpstreamPullsByMirror(pr.Remote, ep.Name())
+		return mh, nil
+	}
+	if !errors.Is(err, upstream.ErrRateLimited) {
+		upstream.RecordPull(pr.Remote, err)
+	}
+	return emptyManifestHolder, err
+}
+
+// pullFrom implements doPull for one endpoint. The manifest is serialized with the url of the
+// passed PullRequest even if it was pulled from a mirror, so it is cached - and re-loaded on
+// startup - under the registry rather than the mirror. The imgpull library doesn't take a
+// context so a request to the upstream that is in flight can't be interrupted. Instead the
+// passed context is checked before the manifest is pulled and again before the blobs are.
+// The pull quota of the endpoint is checked before the manifest is pulled.
+func pullFrom(ctx context.Context, pr pullrequest.PullRequest, ep upstream.Endpoint, imagePath string) (imgpull.ManifestHolder, error) {
+	if err := ctx.Err(); err != nil {
+		return emptyManifestHolder, context.Cause(ctx)
+	}
+	if err := upstream.ProbeRateLimit(ctx, ep, pr.Repository, pr.Reference); err != nil {
+		return emptyManifestHolder, err
+	}
+	puller, err := pullerFor(pr, ep)
 	if err != nil {
 		return emptyManifestHolder, err
 	}
 	defer puller.Close()
 	mh, err := puller.GetManifest()
 	if err != nil {
-		return emptyManifestHolder, err
+		return emptyManifestHolder, upstream.FromPullError(err)
+	}
+	if err := ctx.Err(); err != nil {
+		return emptyManifestHolder, context.Cause(ctx)
 	}
+	mh.ImageUrl = pr.Url()
 	mh.Created = globals.CurTime()
 	mh.Pulled = globals.CurTime()
 	if err := serialize.MhToFilesystem(mh, imagePath, true); err != nil {
 		return emptyManifestHolder, err
 	}
-	if mh.IsImageManifest() {
+	if mh.IsImageManifest() && lazy(pr) {
+		addPending(pr, mh, imagePath)
+	} else if mh.IsImageManifest() {
 		blobDir := filepath.Join(imagePath, globals.BlobPath)
 		err = puller.PullBlobs(mh, blobDir)
 		if err != nil {
 			log.Error(err)
-			return emptyManifestHolder, err
+			return emptyManifestHolder, upstream.FromPullError(err)
 		}
 	}
 	return mh, nil
 }
 
+// pullerFor returns a puller for the passed PullRequest from the passed endpoint. The puller is
+// configured from the registry configuration for the endpoint host, and for a mirror the image
+// url is the url of the image on the mirror.
+func pullerFor(pr pullrequest.PullRequest, ep upstream.Endpoint) (imgpull.Puller, error) {
+	opts, err := config.ConfigFor(ep.Host())
+	if err != nil {
+		return nil, err
+	}
+	opts.Url = pr.Url()
+	if ep.Mirror != "" {
+		opts.Url = ep.Url(pr.Repository, pr.Reference)
+	}
+	return imgpull.NewPullerWith(opts)
+}
+
 // Load copies all the manifests and blobs from the file system into the two in-memory
 // caches - mc (manifests), and bc (blobs.) The manifests are loaded in their entirety. For
 // the blobs, only the digests are loaded with a ref count indicating the number of
 // manifests that ref each blob. This function performs a consistency check: if any blobs
 // associated with a manifest are not present on the file system then the function will
 // not load the manifest into cache. The manifest technically does not exist then from
-// the perspective of a client. (A re-pull will heal that.)
+// the perspective of a client. (A re-pull will heal that.) If blobs are pulled lazily then
+// missing blobs are marked pending instead, and the manifest is loaded.
 func Load(imagePath string) error {
 	start := time.Now()
 	log.Infof("load in-mem cache from file system")
@@ -192,6 +364,9 @@ func Load(imagePath string) error {
 			return err
 		}
 		log.Debugf("loading manifest for %s", mh.ImageUrl)
+		if mh.IsImageManifest() && lazy(pr) {
+			addPending(pr, mh, imagePath)
+		}
 		if canAdd(mh, imagePath) {
 			metrics.DeltaManifestBytesOnDisk(float64(fi.Size()))
 			addToCache(pr, mh, imagePath)
@@ -205,14 +380,27 @@ func Load(imagePath string) error {
 	return outerErr
 }
 
-// WaitPulls waits 60 seconds for any in-progress pulls to complete and then
-// returns. If a pull in progress is partially complete (some of the blobs are written
-// and some aren't) then the cache will be in an inconsistent state. When the server
-// is restarted - it will detect this and exclude any such manifests from being
-// loaded into the in-mem cache.
+// WaitPulls waits up to 60 seconds for any in-progress pulls - including the background
+// pulls - to complete and then returns. If a pull in progress is partially complete (some
+// of the blobs are written and some aren't) then the cache will be in an inconsistent
+// state. When the server is restarted - it will detect this and exclude any such manifests
+// from being loaded into the in-mem cache.
 func WaitPulls() {
 	log.Info("checking for pulls in progress")
-	if pullsInProgress() == 0 {
+	backgroundDone := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(backgroundDone)
+	}()
+	done := func() bool {
+		select {
+		case <-backgroundDone:
+			return pullsInProgress() == 0
+		default:
+			return false
+		}
+	}
+	if done() {
 		log.Info("no pulls in progress")
 		return
 	}
@@ -220,11 +408,12 @@ func WaitPulls() {
 	start := time.Now()
 	for {
 		time.Sleep(time.Second * 2)
-		if pullsInProgress() == 0 {
+		if done() {
 			break
 		}
 		if time.Since(start) > time.Minute {
-			log.Error("timeout waiting for pulls in progress to complete")
+			log.Errorf("timeout waiting for pulls in progress to complete, %d still in progress", pullsInProgress())
+			return
 		}
 	}
 	log.Info("in-progress pulls have completed")
@@ -239,18 +428,103 @@ func IsCached(pr pullrequest.PullRequest) bool {
 	return exists
 }
 
-// ResetCache supports unit tests
-func ResetCache() {
-	cp = concurrentPulls{
-		pulls: make(map[string][]chan bool),
+// GetTags returns the sorted list of tags in the in-mem manifest cache for the passed remote
+// and repository. This supports serving the tags list when the upstream can't be reached. For
+// DockerHub both the 'library/foo' and 'foo' forms of the repository are matched. The tags that
+// referrers indexes are cached under are left out.
+func GetTags(remote, repository string) []string {
+	prefixes := []string{fmt.Sprintf("%s/%s:", remote, repository)}
+	if remote == "docker.io" {
+		if after, found := strings.CutPrefix(repository, "library/"); found {
+			prefixes = append(prefixes, fmt.Sprintf("%s/%s:", remote, after))
+		} else {
+			prefixes = append(prefixes, fmt.Sprintf("%s/library/%s:", remote, repository))
+		}
 	}
-	mc = manifestCache{
-		manifests: map[string]imgpull.ManifestHolder{},
-		latest:    map[string]imgpull.ManifestHolder{},
+	mc.Lock()
+	defer mc.Unlock()
+	tags := []string{}
+	for url := range mc.allManifests {
+		for _, prefix := range prefixes {
+			if tag, found := strings.CutPrefix(url, prefix); found && !isReferrersTag(tag) && !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
 	}
-	bc = blobCache{
-		blobs: map[string]int{},
+	slices.Sort(tags)
+	return tags
+}
+
+// GetRepositories returns the sorted list of repositories in the in-mem manifest cache. Each
+// repository has the upstream registry as the first path component, e.g.
+// 'docker.io/library/hello-world'. Referrers indexes are left out.
+func GetRepositories() []string {
+	mc.Lock()
+	defer mc.Unlock()
+	set := map[string]bool{}
+	for url, mh := range mc.allManifests {
+		pr, err := pullrequest.NewPullRequestFromUrl(url)
+		if err != nil || isReferrersIndex(mh) {
+			continue
+		}
+		set[pr.Remote+"/"+pr.Repository] = true
+	}
+	repos := slices.AppendSeq([]string{}, maps.Keys(set))
+	slices.Sort(repos)
+	return repos
+}
+
+// BlobRepositories returns the sorted repositories of the cached image manifests that reference
+// the passed blob. Each repository has the upstream registry as the first path component.
+func BlobRepositories(digest string) []string {
+	mc.Lock()
+	defer mc.Unlock()
+	set := map[string]bool{}
+	for url, mh := range mc.allManifests {
+		if !mh.IsImageManifest() {
+			continue
+		}
+		for _, layer := range mh.Layers() {
+			if helpers.GetDigestFrom(layer.Digest) != digest {
+				continue
+			}
+			if pr, err := pullrequest.NewPullRequestFromUrl(url); err == nil {
+				set[pr.Remote+"/"+pr.Repository] = true
+			}
+			break
+		}
 	}
+	repos := slices.AppendSeq([]string{}, maps.Keys(set))
+	slices.Sort(repos)
+	return repos
+}
+
+// ResetCache supports unit tests. It waits for the background pulls of the prior test to
+// complete and then empties the in-mem caches.
+func ResetCache() {
+	background.Wait()
+	cp.Lock()
+	clear(cp.pulls)
+	cp.Unlock()
+	mc.Lock()
+	clear(mc.manifests)
+	clear(mc.latest)
+	mc.Unlock()
+	bc.Lock()
+	clear(bc.blobs)
+	clear(bc.uploaded)
+	bc.Unlock()
+	lb.Lock()
+	clear(lb.pending)
+	clear(lb.fetches)
+	lb.Unlock()
+	rv.Lock()
+	clear(rv.checked)
+	clear(rv.inProgress)
+	rv.Unlock()
+	nc.Lock()
+	clear(nc.entries)
+	nc.Unlock()
 }
 
 // allManifests is an iterator over the in-mem manifest cache. It first returns non-latest
@@ -274,13 +548,13 @@ func (mc *manifestCache) len() int {
 }
 
 // canAdd checks to see if all the blobs referenced by the passed manifest exist on the file
-// system (and can therefore be served.) If all blobs exist then true is returned, else
-// false.
+// system or are pending (and can therefore be served.) If all blobs exist then true is returned,
+// else false.
 func canAdd(mh imgpull.ManifestHolder, imagePath string) bool {
 	canAdd := true
 	for _, layer := range mh.Layers() {
 		digest := helpers.GetDigestFrom(layer.Digest)
-		if exists, _ := serialize.BlobExists(imagePath, digest); !exists {
+		if exists, _ := serialize.BlobExists(imagePath, digest); !exists && !isPending(digest) {
 			canAdd = false
 			// don't break - display all the errors
 			log.Debugf("load: blob %q referenced by manifest %q not found on the filesystem", digest, mh.ImageUrl)
@@ -385,14 +659,19 @@ func addManifestToCache(pr pullrequest.PullRequest, mh imgpull.ManifestHolder) {
 
 // addBlobsToCache adds entries to the in-mem blob map and/or increments the ref count
 // for existing blobs in the blob map based on the layers (and the config blob) in the
-// passed manifest. The blobs are expected to already exist on the file system before
-// this function is called.
+// passed manifest. The blobs are expected to already exist on the file system - or be
+// pending - before this function is called. Pending blobs are counted in the metrics
+// when they are fetched.
 func addBlobsToCache(mh imgpull.ManifestHolder, imagePath string) error {
 	for _, layer := range mh.Layers() {
 		digest := helpers.GetDigestFrom(layer.Digest)
 		// if not in the map, is added
 		bc.blobs[digest]++
+		delete(bc.uploaded, digest)
 		if exists, size := serialize.BlobExists(imagePath, digest); !exists {
+			if isPending(digest) {
+				continue
+			}
 			return fmt.Errorf("blob %q referenced by manifest %q not found on the filesystem", digest, mh.ImageUrl)
 		} else if bc.blobs[digest] == 1 {
 			metrics.DeltaBlobBytesOnDisk(float64(size))
@@ -402,51 +681,6 @@ func addBlobsToCache(mh imgpull.ManifestHolder, imagePath string) error {
 	return nil
 }
 
-// getManifestOrEnqueue looks in the in-mem manifest cache for the passed manifest URL. If found,
-// then the manifest holder is returned. If not in cache, then the function enqueues a pull for
-// the manifest from the upstream. In that case, then the return values are to be handled by the
-// caller in specific ways as follows:
-//
-// 1) If the current goroutine is the first to enqueue a pull, then a nil channel is returned. This
-// means the caller must pull the image and signal any other goroutines waiting for the pull
-// to complete.
-//
-// 2) If a non-nil channel is returned, it means another goroutine is already doing the pull for the
-// requested url so the caller in *this* goroutine should wait to be signaled on the non-nil
-// channel. (The pulling go routine will signal all waiters when the image has been added to cache.)
-//
-// In summary, the return values from the function are interpreted as follows:
-//
-// Manifest in cache:
-//
-//	ManifestHolder: The manifest
-//	channel: nil
-//	bool: true
-//
-// Manifest NOT in cache, and NO other goroutine is already pulling from the upstream
-//
-//	ManifestHolder: Empty
-//	channel: nil - caller must pull and then signal waiting goroutines after pull
-//	bool: false
-//
-// Manifest NOT in cache, and another goroutine IS already pulling from the upstream
-//
-//	ManifestHolder: Empty
-//	channel: non-nil - this channel will be signaled when the pulling goroutine finishes
-//	bool: false
-//
-// As a final bit of complexity, if forcePull is true then the manifest is always enqueued.
-// In this case the server acts like a simple proxy meaning it will always pull from the
-// upstream.
-func getManifestOrEnqueue(pr pullrequest.PullRequest, imagePath string, forcePull bool) (imgpull.ManifestHolder, chan bool, bool) {
-	if !forcePull {
-		if mh, exists := getManifestFromCache(pr, imagePath); exists {
-			return mh, nil, true
-		}
-	}
-	return emptyManifestHolder, enqueuePull(pr), false
-}
-
 // fromCache is a low-level function that checks the non-latest in-mem cache and the
 // latest in-mem cache for the passed image.
 func fromCache(url string) (imgpull.ManifestHolder, bool) {
@@ -490,37 +724,100 @@ func getManifestFromCache(pr pullrequest.PullRequest, imagePath string) (imgpull
 	return emptyManifestHolder, false
 }
 
-// enqueuePull enqueues a pull request from the upstream. A return value of nil means
-// the pull request has not already been enqueued by another goroutine. Non-nil means another
-// goroutine HAS already enqueued the pull and the caller must wait on the returned
-// channel to be signalled when the pull completes by the pulling goroutine.
-func enqueuePull(pr pullrequest.PullRequest) chan bool {
+// joinPull returns the pull in progress for the passed url, and false. If there is no pull in
+// progress - or the pull in progress is past its deadline - then a new pull is returned with a
+// deadline of pullTimeout milliseconds and the passed priority, and true, meaning the caller must
+// start it with 'run'. Either way the caller must wait for the pull with 'wait'. So a caller never
+// gets the timeout of a pull that it joined after the deadline passed. If the caller joins a pull
+// in progress with a lower priority - e.g. a client joining a preload - then the priority of the
+// pull is raised to the priority of the caller.
+func joinPull(url string, pullTimeout int, priority upstream.Priority) (*pull, bool) {
 	cp.Lock()
 	defer cp.Unlock()
-	url := pr.Url()
-	if chans, exists := cp.pulls[url]; exists {
-		ch := make(chan bool)
-		cp.pulls[url] = append(chans, ch)
-		return ch
+	prev, exists := cp.pulls[url]
+	if exists && prev.ctx.Err() == nil {
+		prev.waiters++
+		prev.raise(priority)
+		return prev, false
 	}
-	cp.pulls[url] = []chan bool{}
-	return nil
+	timeout := time.Duration(pullTimeout) * time.Millisecond
+	pctx, raise := upstream.WithRaisablePriority(context.Background(), priority)
+	ctx, cancel := context.WithTimeoutCause(pctx, timeout, fmt.Errorf("timeout exceeded (%d millis) pulling %q", pullTimeout, url))
+	p := &pull{
+		ctx:      ctx,
+		cancel:   cancel,
+		raise:    raise,
+		done:     make(chan struct{}),
+		waiters:  1,
+		returned: make(chan struct{}),
+	}
+	if exists {
+		p.prev = prev
+	}
+	cp.pulls[url] = p
+	return p, true
 }
 
-// signalWaiters signals any goroutines waiting on the passed url to be pulled. (Which could
-// be none.)
-func signalWaiters(url string) {
+// run runs the passed pull function for the receiver. The waiters are released when the function
+// returns or when the deadline of the receiver passes, whichever is first. The receiver stays in
+// progress until the function returns even if the deadline passed, so that the same url is never
+// pulled from the upstream by two goroutines at once. For the same reason, if the receiver was
+// started after a prior pull of the url passed its deadline, then the receiver waits for the prior
+// pull to return, and gets its result unless the prior pull was cut off by its deadline.
+func (p *pull) run(url string, pullFn func(context.Context) (imgpull.ManifestHolder, error)) {
+	go func() {
+		<-p.ctx.Done()
+		p.finish(emptyManifestHolder, context.Cause(p.ctx))
+	}()
+	var mh imgpull.ManifestHolder
+	var err error
+	if p.prev != nil {
+		<-p.prev.returned
+	}
+	switch {
+	case p.prev != nil && (p.prev.resultErr == nil || !p.prev.expired):
+		mh, err = p.prev.result, p.prev.resultErr
+	case p.ctx.Err() != nil:
+		mh, err = emptyManifestHolder, context.Cause(p.ctx)
+	default:
+		mh, err = pullFn(p.ctx)
+	}
+	p.result, p.resultErr, p.expired = mh, err, p.ctx.Err() != nil
+	p.prev = nil
+	close(p.returned)
 	cp.Lock()
-	defer cp.Unlock()
-	if chans, exists := cp.pulls[url]; exists {
-		for _, ch := range chans {
-			defer func() {
-				if err := recover(); err != nil {
-					log.Errorf("attempt to write to closed channel for url %q", url)
-				}
-			}()
-			ch <- true
-		}
+	if cp.pulls[url] == p {
 		delete(cp.pulls, url)
 	}
+	cp.Unlock()
+	p.finish(mh, err)
+	p.cancel()
+}
+
+// finish sets the result of the receiver and releases the waiters. Only the first call has
+// any effect.
+func (p *pull) finish(mh imgpull.ManifestHolder, err error) {
+	p.once.Do(func() {
+		p.mh, p.err = mh, err
+		close(p.done)
+	})
+}
+
+// wait waits for the receiver to finish and returns its result. If the passed client context is
+// done first, then the error from the context is returned, and if the server is configured to
+// cancel pulls and no other goroutines are waiting, then the receiver is cancelled.
+func (p *pull) wait(ctx context.Context, url string) (imgpull.ManifestHolder, error) {
+	select {
+	case <-p.done:
+		return p.mh, p.err
+	case <-ctx.Done():
+		cp.Lock()
+		defer cp.Unlock()
+		p.waiters--
+		if p.waiters == 0 && p.ctx.Err() == nil && config.GetCancelPulls() {
+			log.Infof("every client waiting for %q went away, cancelling the pull", url)
+			p.cancel()
+		}
+		return emptyManifestHolder, fmt.Errorf("stopped waiting for %q: %w", url, context.Cause(ctx))
+	}
 }
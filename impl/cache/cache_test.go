@@ -1,23 +1,30 @@
 package cache
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
 	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/aceeric/ociregistry/impl/config"
 	"github.com/aceeric/ociregistry/impl/globals"
 	"github.com/aceeric/ociregistry/impl/pullrequest"
 	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/impl/upstream"
 	"github.com/aceeric/ociregistry/mock"
 
 	"github.com/aceeric/imgpull/pkg/imgpull"
@@ -218,7 +225,7 @@ func TestConcurrentGet(t *testing.T) {
 	for range 3 {
 		wg.Go(func() {
 			const twoSeconds = 2000
-			if _, err := GetManifest(pr, td, twoSeconds, false); err != nil {
+			if _, err := GetManifest(context.Background(), pr, td, twoSeconds, false); err != nil {
 				errs.Add(1)
 			}
 		})
@@ -368,6 +375,57 @@ func validateReplace(expCachedBlobs []string, testDir string, pr pullrequest.Pul
 	return true
 }
 
+// Caches two tags of the same manifest - which share one manifest file - then moves one of the
+// tags and checks that the other tag keeps its manifest in memory, on the file system, and
+// after a re-load.
+func TestReplaceSharedManifest(t *testing.T) {
+	ResetCache()
+	td, _ := os.MkdirTemp("", "")
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	shared := strings.Repeat("1", 64)
+	moved := strings.Repeat("2", 64)
+	v1, err := pullrequest.NewPullRequestFromUrl("foo.io/my-image:v1")
+	if err != nil {
+		t.FailNow()
+	}
+	stable, err := pullrequest.NewPullRequestFromUrl("foo.io/my-image:stable")
+	if err != nil {
+		t.FailNow()
+	}
+	put := func(pr pullrequest.PullRequest, digest string) {
+		mh := imgpull.ManifestHolder{Type: imgpull.V1ociIndex, ImageUrl: pr.Url(), Digest: digest}
+		if err := serialize.MhToFilesystem(mh, td, true); err != nil || replaceInCache(pr, mh, td) != nil {
+			t.FailNow()
+		}
+	}
+	put(v1, shared)
+	put(stable, shared)
+	put(stable, moved)
+	check := func() {
+		mc.Lock()
+		defer mc.Unlock()
+		for url, digest := range map[string]string{
+			v1.Url():                             shared,
+			v1.UrlWithDigest("sha256:" + shared): shared,
+			stable.Url():                         moved,
+		} {
+			if mh, exists := fromCache(url); !exists || mh.Digest != digest {
+				t.Errorf("expected %s to have digest %s", url, digest)
+			}
+		}
+	}
+	check()
+	if mh, found := serialize.MhFromFilesystem(shared, false, td); !found || mh.ImageUrl != v1.Url() {
+		t.FailNow()
+	}
+	ResetCache()
+	if err := Load(td); err != nil {
+		t.FailNow()
+	}
+	check()
+}
+
 // Tests what happens when a "v1" and "v2" manifest are cached, then "latest" comes
 // in with the same digest as "v1", then latest comes in again with the same digest as "v2",
 // and then latest comes in AGAIN with the same digest as "v1". The cache has to handle
@@ -475,3 +533,552 @@ func TestLatestChangesTagAlignment(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+// Tests that the repositories are listed once each, sorted, with the registry first, and that
+// the tags that referrers indexes are cached under are not listed as tags or repositories.
+func TestGetRepositories(t *testing.T) {
+	ResetCache()
+	for _, url := range []string{
+		"quay.io/argoproj/argocd:v2.11.11",
+		"docker.io/library/hello-world:latest",
+		"quay.io/argoproj/argocd:v2.11.12",
+		"quay.io/argoproj/argocd:sha256-3333333333333333333333333333333333333333333333333333333333333333",
+		"ghcr.io/sigstore/signed:sha256-3333333333333333333333333333333333333333333333333333333333333333",
+		"registry.k8s.io/pause@sha256:1111111111111111111111111111111111111111111111111111111111111111",
+	} {
+		pr, err := pullrequest.NewPullRequestFromUrl(url)
+		if err != nil {
+			t.FailNow()
+		}
+		addManifestToCache(pr, imgpull.ManifestHolder{ImageUrl: url, Digest: "2222222222222222222222222222222222222222222222222222222222222222"})
+	}
+	expect := []string{"docker.io/library/hello-world", "quay.io/argoproj/argocd", "registry.k8s.io/pause"}
+	if repos := GetRepositories(); !reflect.DeepEqual(repos, expect) {
+		t.Fail()
+	}
+	if tags := GetTags("quay.io", "argoproj/argocd"); !reflect.DeepEqual(tags, []string{"v2.11.11", "v2.11.12"}) {
+		t.Fail()
+	}
+}
+
+var platformsConfig = `
+---
+registries:
+  - name: %s
+    scheme: http
+    platforms:
+      - linux/amd64
+`
+
+// Pulls a manifest list with a configured platform and checks that the image manifest and blobs
+// for the platform are pulled in the background.
+func TestPullPlatforms(t *testing.T) {
+	ResetCache()
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	defer server.Close()
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(platformsConfig, url))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	pr, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world:latest")
+	if err != nil {
+		t.FailNow()
+	}
+	if mh, err := GetManifest(context.Background(), pr, td, 2000, false); err != nil || !mh.IsManifestList() {
+		t.FailNow()
+	}
+	ipr, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world@sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57")
+	if err != nil {
+		t.FailNow()
+	}
+	for start := time.Now(); !IsCached(ipr); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.FailNow()
+		}
+	}
+	if GetBlob("d2c94e258dcb3c5ac2798d32e1249e42ef01cba4841c2234249495f87264ac5a") != 1 {
+		t.Fail()
+	}
+}
+
+var mirrorsConfig = `
+---
+registries:
+  - name: mirrored.io
+    mirrors: [%[1]s, %[2]s]
+    mirrorCooldown: 1h
+  - name: %[1]s
+    scheme: http
+  - name: %[2]s
+    scheme: http
+`
+
+// Pulls through a registry whose first mirror is down and checks that the image is pulled from
+// the second mirror, that it is cached under the registry name, and that the first mirror is then
+// skipped.
+func TestMirrors(t *testing.T) {
+	ResetCache()
+	defer upstream.ResetMirrors()
+	var pulls atomic.Int32
+	callback := func(string) {
+		pulls.Add(1)
+	}
+	server, url := mock.ServerWithCallback(mock.NewMockParams(mock.NONE, mock.HTTP), &callback)
+	defer server.Close()
+	down := httptest.NewServer(nil)
+	down.Close()
+	downUrl := strings.TrimPrefix(down.URL, "http://")
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(mirrorsConfig, downUrl, url))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	pr, err := pullrequest.NewPullRequestFromUrl("mirrored.io/hello-world@sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57")
+	if err != nil {
+		t.FailNow()
+	}
+	mh, err := GetManifest(context.Background(), pr, td, 2000, false)
+	if err != nil || mh.ImageUrl != pr.Url() || pulls.Load() == 0 {
+		t.FailNow()
+	}
+	if eps := upstream.Endpoints("mirrored.io"); len(eps) != 1 || eps[0].Mirror != url {
+		t.FailNow()
+	}
+	// the manifest is re-loaded under the registry name
+	ResetCache()
+	if err := Load(td); err != nil || !IsCached(pr) {
+		t.FailNow()
+	}
+}
+
+var revalidateConfig = `
+---
+registries:
+  - name: %s
+    scheme: http
+`
+
+// Caches a stale tag and checks that concurrent revalidations of the tag pull the new manifest
+// from the upstream once, and that the tag is not checked again within the interval.
+func TestRevalidate(t *testing.T) {
+	ResetCache()
+	var tagRequests atomic.Int32
+	callback := func(path string) {
+		if strings.HasSuffix(path, "/manifests/latest") {
+			tagRequests.Add(1)
+		}
+	}
+	server, url := mock.ServerWithCallback(mock.NewMockParams(mock.NONE, mock.HTTP), &callback)
+	defer server.Close()
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(revalidateConfig, url))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	ipr, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world@sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57")
+	if err != nil {
+		t.FailNow()
+	}
+	mh, err := GetManifest(context.Background(), ipr, td, 2000, false)
+	if err != nil {
+		t.FailNow()
+	}
+	// cache the image manifest as a tag that was pulled two hours ago so it differs from the upstream
+	pr, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world:latest")
+	if err != nil {
+		t.FailNow()
+	}
+	mh.ImageUrl = pr.Url()
+	mh.Created = time.Now().Add(-2 * time.Hour).Format(globals.DateFormat)
+	if err := serialize.MhToFilesystem(mh, td, true); err != nil {
+		t.FailNow()
+	}
+	if err := addToCache(pr, mh, td); err != nil {
+		t.FailNow()
+	}
+	waitRevalidated := func() {
+		for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+			rv.Lock()
+			done := len(rv.inProgress) == 0
+			rv.Unlock()
+			if done {
+				return
+			}
+			if time.Since(start) > 2*time.Second {
+				t.FailNow()
+			}
+		}
+	}
+	for range 5 {
+		Revalidate(pr, mh, td, 2000, time.Hour)
+	}
+	waitRevalidated()
+	mhNew, exists := getManifestFromCache(pr, td)
+	if !exists || mhNew.Digest != "e4ccfd825622441dcee5123f9d4a48b2eb8787d858de346106a83f0c745cc255" {
+		t.FailNow()
+	}
+	// a puller connects with a HEAD request so one revalidation is a HEAD to connect and a HEAD
+	// for the digest, and then a HEAD to connect and a GET to pull the changed manifest
+	requests := tagRequests.Load()
+	if requests != 4 {
+		t.Errorf("expected 4 upstream requests for the tag, got %d", requests)
+	}
+	Revalidate(pr, mhNew, td, 2000, time.Hour)
+	waitRevalidated()
+	if tagRequests.Load() != requests {
+		t.Errorf("expected no upstream requests within the interval, got %d", tagRequests.Load()-requests)
+	}
+	// deleting the tag forgets when it was checked
+	if !DeleteManifest(pr, td) {
+		t.FailNow()
+	}
+	rv.Lock()
+	defer rv.Unlock()
+	if _, exists := rv.checked[pr.Url()]; exists {
+		t.Errorf("expected the checked time of the deleted tag to be removed")
+	}
+}
+
+var breakerConfig = `
+---
+registries:
+  - name: %s
+    scheme: http
+breakerConfig:
+  enabled: true
+  failures: 1
+  openFor: 1h
+`
+
+// Caches a tag, takes the upstream down, and checks that a forced pull of the tag falls back to
+// the cached manifest and opens the circuit breaker, and that a pull of an un-cached tag then
+// fails fast.
+func TestBreakerFallback(t *testing.T) {
+	ResetCache()
+	defer upstream.ResetBreakers()
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(breakerConfig, url))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	pr, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world:latest")
+	if err != nil {
+		t.FailNow()
+	}
+	mh, err := GetManifest(context.Background(), pr, td, 2000, false)
+	if err != nil {
+		t.FailNow()
+	}
+	server.Close()
+	mhCached, err := GetManifest(context.Background(), pr, td, 2000, true)
+	if err != nil || mhCached.Digest != mh.Digest {
+		t.FailNow()
+	}
+	if statuses := upstream.GetBreakers(); len(statuses) != 1 || statuses[0].State != upstream.BreakerOpen {
+		t.FailNow()
+	}
+	upr, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world:v1")
+	if err != nil {
+		t.FailNow()
+	}
+	if _, err := GetManifest(context.Background(), upr, td, 2000, false); !errors.Is(err, upstream.ErrCircuitOpen) {
+		t.FailNow()
+	}
+}
+
+var cancelPullsConfig = `
+---
+cancelPulls: %t
+registries:
+  - name: %s
+    scheme: http
+`
+
+// Checks that goroutines waiting on a pull get the error the pull failed with, and that a pull
+// that is slower than the pull timeout fails with a timeout rather than blocking its waiters.
+func TestPullWaiters(t *testing.T) {
+	ResetCache()
+	callback := func(path string) {
+		if strings.HasSuffix(path, "/manifests/nope") {
+			time.Sleep(200 * time.Millisecond)
+		} else if strings.HasSuffix(path, "/manifests/latest") {
+			time.Sleep(time.Second)
+		}
+	}
+	server, url := mock.ServerWithCallback(mock.NewMockParams(mock.NONE, mock.HTTP), &callback)
+	defer server.Close()
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(cancelPullsConfig, false, url))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	pr, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world:nope")
+	if err != nil {
+		t.FailNow()
+	}
+	var wg sync.WaitGroup
+	var notFound atomic.Int32
+	for range 3 {
+		wg.Go(func() {
+			_, err := GetManifest(context.Background(), pr, td, 2000, false)
+			var ue *upstream.Error
+			if errors.As(err, &ue) && ue.Status == http.StatusNotFound {
+				notFound.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if notFound.Load() != 3 {
+		t.Errorf("expected 3 waiters to get a 404, got %d", notFound.Load())
+	}
+	pr, err = pullrequest.NewPullRequestFromUrl(url + "/hello-world:latest")
+	if err != nil {
+		t.FailNow()
+	}
+	start := time.Now()
+	if _, err := GetManifest(context.Background(), pr, td, 100, false); err == nil || !strings.Contains(err.Error(), "timeout exceeded") {
+		t.FailNow()
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("expected the pull to time out after 100 millis, took %s", time.Since(start))
+	}
+	waitPulls(t)
+}
+
+// Checks that a pull that passes its deadline isn't held against the registry, and that a client
+// that asks for the same manifest while the expired pull is still in progress waits for it with
+// its own deadline rather than getting the timeout.
+func TestPullDeadline(t *testing.T) {
+	ResetCache()
+	defer upstream.ResetBreakers()
+	callback := func(path string) {
+		if strings.HasSuffix(path, "/manifests/latest") {
+			time.Sleep(300 * time.Millisecond)
+		}
+	}
+	server, url := mock.ServerWithCallback(mock.NewMockParams(mock.NONE, mock.HTTP), &callback)
+	defer server.Close()
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(breakerConfig, url))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	pr, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world:latest")
+	if err != nil {
+		t.FailNow()
+	}
+	if _, err := GetManifest(context.Background(), pr, td, 100, false); err == nil || !strings.Contains(err.Error(), "timeout exceeded") {
+		t.FailNow()
+	}
+	if _, err := GetManifest(context.Background(), pr, td, 5000, false); err != nil {
+		t.Errorf("expected the pull to succeed, the error was: %s", err)
+	}
+	for _, status := range upstream.GetBreakers() {
+		if status.State != upstream.BreakerClosed || status.Failures != 0 {
+			t.Errorf("expected the expired pull not to count against the breaker, got %+v", status)
+		}
+	}
+	waitPulls(t)
+}
+
+var joinPriorityConfig = `
+---
+registries:
+  - name: %s
+    scheme: http
+pullLimits:
+  perRegistry: 1
+`
+
+// Queues a prefetch pull and then a preload pull behind a pull that holds the only slot for the
+// registry, and checks that a client that joins the preload pull raises its priority so it goes
+// ahead of the prefetch pull.
+func TestJoinPriority(t *testing.T) {
+	ResetCache()
+	defer upstream.ResetLimits()
+	var mu sync.Mutex
+	manifests := []string{}
+	callback := func(path string) {
+		if strings.Contains(path, "/manifests/") {
+			mu.Lock()
+			manifests = append(manifests, path)
+			mu.Unlock()
+		}
+	}
+	server, url := mock.ServerWithCallback(mock.NewMockParams(mock.NONE, mock.HTTP), &callback)
+	defer server.Close()
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(joinPriorityConfig, url))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	prefetch, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world@sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57")
+	if err != nil {
+		t.FailNow()
+	}
+	preload, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world:latest")
+	if err != nil {
+		t.FailNow()
+	}
+	// until waits for the passed condition
+	until := func(cond func() bool) {
+		for start := time.Now(); !cond(); time.Sleep(5 * time.Millisecond) {
+			if time.Since(start) > 5*time.Second {
+				t.FailNow()
+			}
+		}
+	}
+	release, err := upstream.Acquire(context.Background(), url)
+	if err != nil {
+		t.FailNow()
+	}
+	var wg sync.WaitGroup
+	for i, tst := range []struct {
+		pr       pullrequest.PullRequest
+		priority upstream.Priority
+	}{
+		{prefetch, upstream.PriorityPrefetch},
+		{preload, upstream.PriorityPreload},
+	} {
+		wg.Go(func() {
+			GetManifest(upstream.WithPriority(context.Background(), tst.priority), tst.pr, td, 5000, false)
+		})
+		until(func() bool { return upstream.QueuedPulls(url) == i+1 })
+	}
+	wg.Go(func() {
+		if _, err := GetManifest(context.Background(), preload, td, 5000, false); err != nil {
+			t.Errorf("expected the joined pull to succeed, the error was: %s", err)
+		}
+	})
+	until(func() bool {
+		cp.Lock()
+		defer cp.Unlock()
+		p, exists := cp.pulls[preload.Url()]
+		return exists && p.waiters == 2
+	})
+	release()
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(manifests) == 0 || !strings.HasSuffix(manifests[0], "/manifests/latest") {
+		t.Errorf("expected the joined pull to go first, got %v", manifests)
+	}
+}
+
+// Checks that when the only client waiting on a pull goes away the pull is cancelled and the
+// manifest is not cached.
+func TestCancelPull(t *testing.T) {
+	ResetCache()
+	callback := func(path string) {
+		if strings.HasSuffix(path, "/manifests/latest") {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}
+	server, url := mock.ServerWithCallback(mock.NewMockParams(mock.NONE, mock.HTTP), &callback)
+	defer server.Close()
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(cancelPullsConfig, true, url))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	pr, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world:latest")
+	if err != nil {
+		t.FailNow()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := GetManifest(ctx, pr, td, 2000, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.FailNow()
+	}
+	waitPulls(t)
+	if IsCached(pr) {
+		t.Errorf("expected the cancelled pull not to be cached")
+	}
+}
+
+// waitPulls waits for the pulls in progress - including any that were released at their
+// deadline - to complete.
+func waitPulls(t *testing.T) {
+	for start := time.Now(); pullsInProgress() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.FailNow()
+		}
+	}
+}
+
+// Caches the referrers index of a subject and then checks that once the upstream has no
+// referrers for the subject the cached index is removed, so it isn't served when the upstream
+// can't be reached.
+func TestReferrersRemoved(t *testing.T) {
+	ResetCache()
+	var found atomic.Bool
+	found.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !found.Load() || !strings.Contains(r.URL.Path, "/referrers/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", indexMediaType)
+		w.Write([]byte(`{"schemaVersion":2,"mediaType":"` + indexMediaType + `","manifests":[]}`))
+	}))
+	remote := strings.TrimPrefix(server.URL, "http://")
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf("registries:\n  - name: %s\n    scheme: http\n", remote))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	pr, err := pullrequest.NewPullRequestFromUrl(remote + "/hello-world@sha256:" + strings.Repeat("1", 64))
+	if err != nil {
+		t.FailNow()
+	}
+	ipr := referrersPr(pr.Remote, pr.Repository, pr.Reference)
+	if _, err := GetReferrers(pr, td, 2000, false); err != nil || !IsCached(ipr) {
+		t.FailNow()
+	}
+	found.Store(false)
+	if idx, err := GetReferrers(pr, td, 2000, false); err != nil || len(idx.Manifests) != 0 || IsCached(ipr) {
+		t.FailNow()
+	}
+	server.Close()
+	if _, err := GetReferrers(pr, td, 2000, false); err == nil {
+		t.Errorf("expected no referrers from the cache")
+	}
+}
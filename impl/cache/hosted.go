@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/globals"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/serialize"
+
+	"github.com/aceeric/imgpull/pkg/imgpull"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrManifestBlobUnknown means that a pushed manifest references a blob, or a manifest,
+// that is not in the cache.
+var ErrManifestBlobUnknown = errors.New("manifest references content that is not in the cache")
+
+// PutManifest adds a manifest pushed by a client to the cache. The blobs referenced by an image
+// manifest must already have been uploaded, and the manifests referenced by a manifest list must
+// already have been pushed. The manifest is written to the file system and added to the in-mem
+// cache, replacing any manifest already cached with the same tag. Blob ref counts are handled
+// exactly as for pulled manifests so pushed and pulled images can share blobs.
+func PutManifest(pr pullrequest.PullRequest, mh imgpull.ManifestHolder, imagePath string) error {
+	if mh.IsImageManifest() && !canAdd(mh, imagePath) {
+		return fmt.Errorf("%w: blobs referenced by %q have not been uploaded", ErrManifestBlobUnknown, pr.Url())
+	}
+	for _, digest := range mh.ImageManifestDigests() {
+		ipr := pullrequest.PullRequest{
+			PullType:   pullrequest.ByDigest,
+			Remote:     pr.Remote,
+			Repository: pr.Repository,
+			Reference:  digest,
+		}
+		if !IsCached(ipr) {
+			return fmt.Errorf("%w: manifest %q referenced by %q has not been pushed", ErrManifestBlobUnknown, digest, pr.Url())
+		}
+	}
+	mh.Created = globals.CurTime()
+	mh.Pulled = globals.CurTime()
+	if err := serialize.MhToFilesystem(mh, imagePath, true); err != nil {
+		return err
+	}
+	log.Infof("pushed manifest %q, digest %s", pr.Url(), mh.Digest)
+	return replaceInCache(pr, mh, imagePath)
+}
+
+// AddUploadedBlob records a blob that a client uploaded - or mounted - to the hosted namespace.
+// Clients check that the blobs of an image exist before they push its manifest, and until then
+// no manifest references the blobs. A blob that a cached manifest already references is left
+// alone.
+func AddUploadedBlob(digest string) {
+	bc.Lock()
+	defer bc.Unlock()
+	if bc.blobs[digest] <= 0 {
+		bc.uploaded[digest] = time.Now()
+	}
+}
+
+// HasBlob returns true if the passed blob is referenced by a cached manifest or has been uploaded
+// to the hosted namespace.
+func HasBlob(digest string) bool {
+	bc.RLock()
+	defer bc.RUnlock()
+	_, uploaded := bc.uploaded[digest]
+	return bc.blobs[digest] > 0 || uploaded
+}
+
+// ExpireUploadedBlobs removes the blobs that were uploaded to the hosted namespace more than the
+// passed ttl ago and that no pushed manifest references, from the cache and the file system. The
+// count of removed blobs is returned.
+func ExpireUploadedBlobs(imagePath string, ttl time.Duration) int {
+	bc.Lock()
+	defer bc.Unlock()
+	cnt := 0
+	for digest, uploaded := range bc.uploaded {
+		if time.Since(uploaded) <= ttl {
+			continue
+		}
+		delete(bc.uploaded, digest)
+		log.Infof("removing uploaded blob that no manifest references: %s", digest)
+		if err := os.Remove(filepath.Join(imagePath, globals.BlobPath, digest)); err != nil && !os.IsNotExist(err) {
+			log.Errorf("error removing uploaded blob %s: %s", digest, err)
+			continue
+		}
+		cnt++
+	}
+	return cnt
+}
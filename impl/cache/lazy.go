@@ -0,0 +1,387 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/globals"
+	"github.com/aceeric/ociregistry/impl/helpers"
+	"github.com/aceeric/ociregistry/impl/metrics"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/impl/upstream"
+
+	"github.com/aceeric/imgpull/pkg/imgpull"
+	log "github.com/sirupsen/logrus"
+)
+
+// Type pendingBlob identifies where to fetch a blob from when the server is configured to pull
+// blobs lazily. The size is from the manifest layer and supports answering a HEAD request for
+// the blob before the blob is fetched.
+type pendingBlob struct {
+	remote     string
+	repository string
+	size       int
+}
+
+// Type blobFetch is one in-progress download of a blob. The blob is written to a temp file and
+// the count of bytes written so far is updated as the download progresses, which allows clients
+// to be streamed the blob while it downloads - see blobReader. Once the digest is verified the
+// temp file is moved into the blob directory and the path is updated. Goroutines that just need
+// the blob on the file system wait for the done channel to be closed and then check err.
+type blobFetch struct {
+	sync.Mutex
+	cond     *sync.Cond
+	path     string
+	written  int64
+	complete bool
+	err      error
+	done     chan struct{}
+}
+
+// Type blobReader reads a blob that is being downloaded by following the file that the download
+// is written to. A read blocks until the download has written more bytes than the reader has
+// read. If the download fails - or the blob doesn't match its digest - then the reader returns
+// the error from the download. A read also stops waiting once the context of the request that
+// the blob is being streamed to is done.
+type blobReader struct {
+	bf   *blobFetch
+	ctx  context.Context
+	stop func() bool
+	f    *os.File
+	off  int64
+}
+
+// Type lazyBlobs tracks the blobs that are referenced by cached image manifests but have not
+// been downloaded yet. Pending blobs are counted in the blob cache ref counts like any other
+// blob so they are pruned the same way.
+type lazyBlobs struct {
+	sync.Mutex
+	pending map[string]pendingBlob
+	fetches map[string]*blobFetch
+}
+
+var (
+	// lb has the pending blobs, keyed by digest, and the downloads in progress. The lock
+	// order is mc, then bc, then lb.
+	lb lazyBlobs = lazyBlobs{
+		pending: map[string]pendingBlob{},
+		fetches: map[string]*blobFetch{},
+	}
+	// ErrBlobNotPending is returned by FetchBlob when the blob is neither on the file system
+	// nor pending.
+	ErrBlobNotPending = errors.New("blob is not pending")
+)
+
+// PendingBlob returns the size of the passed blob and true if the blob is referenced by a
+// cached manifest but has not been downloaded yet.
+func PendingBlob(digest string) (int, bool) {
+	lb.Lock()
+	defer lb.Unlock()
+	pb, exists := lb.pending[digest]
+	return pb.size, exists
+}
+
+// FetchBlob ensures that the passed blob is on the file system. If the blob is pending then it
+// is downloaded from the upstream that the manifest referencing it was pulled from. If multiple
+// goroutines request the same blob concurrently then only one download is performed and all the
+// goroutines get the result of that download. The download runs independently of the passed
+// context so that a client that goes away doesn't cancel the download for the other clients.
+// The context only bounds how long the caller waits.
+func FetchBlob(ctx context.Context, digest string, imagePath string) error {
+	if exists, _ := serialize.BlobExists(imagePath, digest); exists {
+		return nil
+	}
+	bf, _, err := startFetch(digest, imagePath)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-bf.done:
+		return bf.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StreamBlob returns a reader for the passed pending blob that reads the blob as it is downloaded
+// from the upstream, along with the size of the blob from the manifest. The download is shared
+// the same way as FetchBlob, so any number of clients can be streamed the same blob while it
+// downloads. The caller must close the returned reader. ErrBlobNotPending is returned if the
+// blob is not pending, e.g. because the download just completed. The function doesn't return
+// until the download has written the first bytes of the blob, so if the upstream answers with
+// an error status - or the download fails before any data arrives - then the error from the
+// download is returned instead of a reader, and the caller can send the error to its client.
+// Reads from the returned reader fail with the error of the passed context once it is done, but
+// - like FetchBlob - the download continues.
+func StreamBlob(ctx context.Context, digest string, imagePath string) (io.ReadCloser, int, error) {
+	bf, size, err := startFetch(digest, imagePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	// wake up a read - or the wait for the first bytes - that is waiting on the download
+	stop := context.AfterFunc(ctx, func() {
+		bf.Lock()
+		bf.cond.Broadcast()
+		bf.Unlock()
+	})
+	bf.Lock()
+	for bf.written == 0 && !bf.complete && ctx.Err() == nil {
+		bf.cond.Wait()
+	}
+	switch {
+	case bf.complete && bf.err != nil:
+		err = bf.err
+	case bf.written == 0 && !bf.complete:
+		err = ctx.Err()
+	}
+	bf.Unlock()
+	if err != nil {
+		stop()
+		return nil, 0, err
+	}
+	return &blobReader{bf: bf, ctx: ctx, stop: stop}, size, nil
+}
+
+// startFetch returns the in-progress download of the passed pending blob, starting the download
+// if it is not already in progress. The size of the blob from the manifest is also returned.
+func startFetch(digest string, imagePath string) (*blobFetch, int, error) {
+	lb.Lock()
+	defer lb.Unlock()
+	pb, pending := lb.pending[digest]
+	if !pending {
+		return nil, 0, ErrBlobNotPending
+	}
+	bf, inProgress := lb.fetches[digest]
+	if !inProgress {
+		bf = &blobFetch{done: make(chan struct{})}
+		bf.cond = sync.NewCond(bf)
+		lb.fetches[digest] = bf
+		go func() {
+			err := fetchBlob(digest, pb, imagePath, bf)
+			lb.Lock()
+			delete(lb.fetches, digest)
+			if err == nil {
+				delete(lb.pending, digest)
+			}
+			lb.Unlock()
+			bf.finish(err)
+		}()
+	}
+	return bf, pb.size, nil
+}
+
+// fetchBlob downloads the passed blob from the upstream into the uploads directory, verifies the
+// digest, and then moves it into the blob directory. Progress is recorded in the passed blobFetch.
+// There is no overall timeout for the download because - like the image puller - a layer can be
+// arbitrarily large, but the download fails if the upstream sends no data for the configured pull
+// timeout - see 'upstream.NewStreamClient'. The download waits at most the pull timeout for the
+// concurrent pull limits for the registry, and counts against them until it completes or fails -
+// including when the upstream stops sending data - so a stalled download frees its slot. The move
+// is done with the blob cache locked so a blob that was pruned during the download is discarded
+// rather than orphaned on the file system.
+func fetchBlob(digest string, pb pendingBlob, imagePath string, bf *blobFetch) error {
+	log.Infof("fetching blob %q from upstream %s/%s", digest, pb.remote, pb.repository)
+	metrics.IncUpstreamPullsByNs(pb.remote)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.GetPullTimeout())*time.Millisecond)
+	release, err := upstream.Acquire(ctx, pb.remote)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer release()
+	resp, client, err := getBlob(digest, pb)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dir := filepath.Join(imagePath, globals.UploadPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "blob-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	bf.Lock()
+	bf.path = f.Name()
+	bf.Unlock()
+	hash := sha256.New()
+	// the file is written before the progress so readers never read past the end of the file
+	size, err := io.Copy(io.MultiWriter(f, hash, bf), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != digest {
+		return fmt.Errorf("blob %q from %q has digest %q", digest, client.Repository(), actual)
+	}
+	bc.Lock()
+	defer bc.Unlock()
+	if bc.blobs[digest] == 0 {
+		return fmt.Errorf("blob %q was removed from the cache during the download", digest)
+	}
+	blobPath := filepath.Join(imagePath, globals.BlobPath, digest)
+	if err := os.Rename(f.Name(), blobPath); err != nil {
+		return err
+	}
+	// readers that already have the temp file open can keep reading it after the rename
+	bf.Lock()
+	bf.path = blobPath
+	bf.Unlock()
+	metrics.DeltaBlobBytesOnDisk(float64(size))
+	metrics.DeltaCachedBlobCount(1)
+	return nil
+}
+
+// getBlob gets the passed blob from the upstream, trying each mirror in order if the registry has
+// mirrors. The caller must close the body of the returned response. The client that got the blob
+// is also returned.
+func getBlob(digest string, pb pendingBlob) (*http.Response, *upstream.Client, error) {
+	var err error
+	for _, ep := range upstream.Endpoints(pb.remote) {
+		if err = upstream.CheckRateLimit(ep.Host()); err != nil {
+			continue
+		}
+		var client *upstream.Client
+		if client, err = upstream.NewStreamClient(ep, pb.repository, config.GetPullTimeout()); err != nil {
+			continue
+		}
+		var resp *http.Response
+		if resp, err = client.Get("blobs/sha256:"+digest, nil); err == nil && resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			err = upstream.ResponseError(resp, "get blob %q from %q returned status %d", digest, client.Repository(), resp.StatusCode)
+		}
+		if err != nil {
+			ep.Failed(err)
+			continue
+		}
+		ep.Succeeded()
+		if ep.Mirror != "" {
+			log.Infof("fetching blob %q from mirror %s", digest, ep.Mirror)
+		}
+		return resp, client, nil
+	}
+	return nil, nil, err
+}
+
+// Write implements io.Writer to record the progress of the download and wake up the readers.
+// It doesn't write anything.
+func (bf *blobFetch) Write(p []byte) (int, error) {
+	bf.Lock()
+	defer bf.Unlock()
+	bf.written += int64(len(p))
+	bf.cond.Broadcast()
+	return len(p), nil
+}
+
+// finish records the result of the download and wakes up the readers and the waiters.
+func (bf *blobFetch) finish(err error) {
+	bf.Lock()
+	bf.complete = true
+	bf.err = err
+	bf.cond.Broadcast()
+	bf.Unlock()
+	close(bf.done)
+}
+
+// Read implements io.Reader.
+func (br *blobReader) Read(p []byte) (int, error) {
+	bf := br.bf
+	bf.Lock()
+	for br.off == bf.written && !bf.complete && br.ctx.Err() == nil {
+		bf.cond.Wait()
+	}
+	if br.off == bf.written && !bf.complete {
+		bf.Unlock()
+		return 0, br.ctx.Err()
+	}
+	if err := bf.err; err != nil {
+		bf.Unlock()
+		return 0, err
+	}
+	if br.off == bf.written {
+		bf.Unlock()
+		return 0, io.EOF
+	}
+	if br.f == nil {
+		f, err := os.Open(bf.path)
+		if err != nil {
+			bf.Unlock()
+			return 0, err
+		}
+		br.f = f
+	}
+	avail := bf.written - br.off
+	bf.Unlock()
+	if int64(len(p)) > avail {
+		p = p[:avail]
+	}
+	n, err := br.f.Read(p)
+	br.off += int64(n)
+	return n, err
+}
+
+// Close implements io.Closer.
+func (br *blobReader) Close() error {
+	br.stop()
+	if br.f != nil {
+		return br.f.Close()
+	}
+	return nil
+}
+
+// addPending marks the blobs of the passed image manifest that are not on the file system as
+// pending, to be fetched from the upstream that the passed PullRequest identifies.
+func addPending(pr pullrequest.PullRequest, mh imgpull.ManifestHolder, imagePath string) {
+	lb.Lock()
+	defer lb.Unlock()
+	for _, layer := range mh.Layers() {
+		digest := helpers.GetDigestFrom(layer.Digest)
+		if _, exists := lb.pending[digest]; exists {
+			continue
+		}
+		if exists, _ := serialize.BlobExists(imagePath, digest); !exists {
+			lb.pending[digest] = pendingBlob{remote: pr.Remote, repository: pr.Repository, size: layer.Size}
+		}
+	}
+}
+
+// isPending returns true if the passed blob is pending.
+func isPending(digest string) bool {
+	lb.Lock()
+	defer lb.Unlock()
+	_, exists := lb.pending[digest]
+	return exists
+}
+
+// rmPending removes the passed blob from the pending blobs. It is called when the last
+// manifest that references the blob is removed from cache.
+func rmPending(digest string) {
+	lb.Lock()
+	defer lb.Unlock()
+	delete(lb.pending, digest)
+}
+
+// lazy returns true if the server is configured to pull blobs lazily and the passed PullRequest
+// is not for the hosted namespace - which has no upstream to fetch blobs from.
+func lazy(pr pullrequest.PullRequest) bool {
+	if !config.GetLazyBlobs() {
+		return false
+	}
+	hc := config.GetHostedConfig()
+	return !hc.Enabled || hc.Namespace != pr.Remote
+}
@@ -0,0 +1,296 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/globals"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/impl/upstream"
+	"github.com/aceeric/ociregistry/mock"
+)
+
+var lazyConfig = `
+---
+lazyBlobs: true
+registries:
+  - name: %s
+    scheme: http
+`
+
+// Pulls an image manifest with lazy blobs and checks that the blobs are counted but not on
+// the file system, that concurrent fetches of a blob only go to the upstream once, and that
+// deleting the manifest removes the fetched and the pending blobs.
+func TestLazyBlobs(t *testing.T) {
+	ResetCache()
+	params := mock.NewMockParams(mock.NONE, mock.HTTP)
+	params.DelayMs = 200
+	var blobPulls atomic.Int32
+	callback := func(url string) {
+		if strings.Contains(url, "/blobs/") {
+			blobPulls.Add(1)
+		}
+	}
+	server, url := mock.ServerWithCallback(params, &callback)
+	defer server.Close()
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(lazyConfig, url))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	pr, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world@sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57")
+	if err != nil {
+		t.FailNow()
+	}
+	mh, err := GetManifest(context.Background(), pr, td, 2000, false)
+	if err != nil || !mh.IsImageManifest() {
+		t.FailNow()
+	}
+	if blobPulls.Load() != 0 {
+		t.Errorf("expected no blob pulls, got %d", blobPulls.Load())
+	}
+	blobs := []string{
+		"d2c94e258dcb3c5ac2798d32e1249e42ef01cba4841c2234249495f87264ac5a",
+		"c1ec31eb59444d78df06a974d155e597c894ab4cda84f08294145e845394988e",
+	}
+	for _, digest := range blobs {
+		if exists, _ := serialize.BlobExists(td, digest); exists || GetBlob(digest) != 1 {
+			t.FailNow()
+		}
+		if _, pending := PendingBlob(digest); !pending {
+			t.FailNow()
+		}
+	}
+	var wg sync.WaitGroup
+	var errs atomic.Int32
+	for range 3 {
+		wg.Go(func() {
+			if err := FetchBlob(context.Background(), blobs[0], td); err != nil {
+				errs.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if errs.Load() != 0 || blobPulls.Load() != 1 {
+		t.Errorf("expected one blob pull and no errors, got %d pull(s) and %d error(s)", blobPulls.Load(), errs.Load())
+	}
+	if exists, _ := serialize.BlobExists(td, blobs[0]); !exists {
+		t.FailNow()
+	}
+	if _, pending := PendingBlob(blobs[0]); pending {
+		t.FailNow()
+	}
+	if err := FetchBlob(context.Background(), "0000", td); err != ErrBlobNotPending {
+		t.FailNow()
+	}
+	if !DeleteManifest(pr, td) {
+		t.FailNow()
+	}
+	for _, digest := range blobs {
+		if exists, _ := serialize.BlobExists(td, digest); exists || GetBlob(digest) != 0 {
+			t.FailNow()
+		}
+		if _, pending := PendingBlob(digest); pending {
+			t.FailNow()
+		}
+	}
+}
+
+// Streams a blob to concurrent readers while the upstream is still sending it, and checks
+// that a blob that doesn't match its digest fails the readers and is not cached.
+func TestStreamBlob(t *testing.T) {
+	ResetCache()
+	blob := bytes.Repeat([]byte("0123456789"), 10000)
+	sum := sha256.Sum256(blob)
+	digest := hex.EncodeToString(sum[:])
+	half := make(chan struct{})
+	corrupt := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write(blob[:len(blob)/2])
+		w.(http.Flusher).Flush()
+		<-half
+		if corrupt {
+			w.Write([]byte("corrupt"))
+		} else {
+			w.Write(blob[len(blob)/2:])
+		}
+	}))
+	defer server.Close()
+	remote := strings.TrimPrefix(server.URL, "http://")
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(lazyConfig, remote))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	for _, corrupt = range []bool{false, true} {
+		half = make(chan struct{})
+		bc.blobs[digest] = 1
+		lb.pending[digest] = pendingBlob{remote: remote, repository: "test", size: len(blob)}
+		readers := []io.ReadCloser{}
+		for range 2 {
+			rc, size, err := StreamBlob(context.Background(), digest, td)
+			if err != nil || size != len(blob) {
+				t.FailNow()
+			}
+			defer rc.Close()
+			readers = append(readers, rc)
+		}
+		// the first half can be read before the upstream sends the second half
+		for _, rc := range readers {
+			buf := make([]byte, len(blob)/2)
+			if _, err := io.ReadFull(rc, buf); err != nil || !bytes.Equal(buf, blob[:len(blob)/2]) {
+				t.FailNow()
+			}
+		}
+		close(half)
+		for _, rc := range readers {
+			rest, err := io.ReadAll(rc)
+			if corrupt != (err != nil) {
+				t.Errorf("corrupt %t: unexpected error %v", corrupt, err)
+			} else if !corrupt && !bytes.Equal(rest, blob[len(blob)/2:]) {
+				t.Errorf("unexpected blob content")
+			}
+		}
+		if exists, _ := serialize.BlobExists(td, digest); exists == corrupt {
+			t.Errorf("corrupt %t: blob exists %t", corrupt, exists)
+		}
+		os.Remove(filepath.Join(td, globals.BlobPath, digest))
+	}
+}
+
+// Checks that streaming a pending blob that the upstream answers with an error status returns the
+// upstream error - with the Retry-After of a 429 - rather than a reader, and that the blob stays
+// pending.
+func TestStreamBlobError(t *testing.T) {
+	ResetCache()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, strings.Repeat("4", 64)) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	remote := strings.TrimPrefix(server.URL, "http://")
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(lazyConfig, remote))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	for _, tc := range []struct {
+		digest     string
+		status     int
+		retryAfter time.Duration
+	}{
+		{strings.Repeat("4", 64), http.StatusNotFound, 0},
+		{strings.Repeat("9", 64), http.StatusTooManyRequests, 30 * time.Second},
+	} {
+		bc.blobs[tc.digest] = 1
+		lb.pending[tc.digest] = pendingBlob{remote: remote, repository: "test", size: 100}
+		rc, _, err := StreamBlob(context.Background(), tc.digest, td)
+		var ue *upstream.Error
+		if rc != nil || !errors.As(err, &ue) || ue.Status != tc.status || ue.RetryAfter != tc.retryAfter {
+			t.Errorf("expected status %d, got %v", tc.status, err)
+		}
+		if _, pending := PendingBlob(tc.digest); !pending {
+			t.Errorf("expected blob %q to stay pending", tc.digest)
+		}
+	}
+}
+
+// Checks that a download waits at most the pull timeout for the concurrent pull limits, that a
+// reader stops waiting on a download once its context is done, and that a download from an
+// upstream that stops sending data fails once the pull timeout passes and frees its slot.
+func TestStreamBlobStall(t *testing.T) {
+	ResetCache()
+	defer upstream.ResetLimits()
+	blob := bytes.Repeat([]byte("0123456789"), 10000)
+	sum := sha256.Sum256(blob)
+	digest := hex.EncodeToString(sum[:])
+	stall := make(chan struct{})
+	defer close(stall)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write(blob[:len(blob)/2])
+		w.(http.Flusher).Flush()
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	remote := strings.TrimPrefix(server.URL, "http://")
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(lazyConfig+"pullTimeout: 300\npullLimits:\n  perRegistry: 1\n", remote))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	bc.blobs[digest] = 1
+	lb.pending[digest] = pendingBlob{remote: remote, repository: "test", size: len(blob)}
+
+	release, err := upstream.Acquire(context.Background(), remote)
+	if err != nil {
+		t.FailNow()
+	}
+	if err := FetchBlob(context.Background(), digest, td); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the download to give up waiting for the pull limits, got %v", err)
+	}
+	release()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rc, _, err := StreamBlob(ctx, digest, td)
+	if err != nil {
+		t.FailNow()
+	}
+	defer rc.Close()
+	if _, err := io.ReadAll(rc); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the reader to give up with the context, got %v", err)
+	}
+	if err := FetchBlob(context.Background(), digest, td); !errors.Is(err, upstream.ErrIdleTimeout) {
+		t.Errorf("expected the download to fail with the idle timeout, got %v", err)
+	}
+	if _, pending := PendingBlob(digest); !pending {
+		t.FailNow()
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if release, err := upstream.Acquire(ctx, remote); err != nil {
+		t.Errorf("expected the stalled download to free its slot, got %v", err)
+	} else {
+		release()
+	}
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/upstream"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Type NegativeEntry is a manifest url that the upstream answered with an error status that
+// is remembered so that the upstream isn't asked again until the entry expires.
+type NegativeEntry struct {
+	Url     string
+	Status  int
+	Error   string
+	Expires time.Time
+}
+
+// Type negativeCache has the negative cache entries keyed by manifest url. Swept is when the
+// expired entries were last removed.
+type negativeCache struct {
+	sync.Mutex
+	entries map[string]NegativeEntry
+	swept   time.Time
+}
+
+const (
+	// defaultNegativeTtl is used if the negative cache ttl is not configured or can't be parsed.
+	defaultNegativeTtl = time.Minute
+	// defaultNegativeMaxEntries is used if the negative cache max entries is not configured.
+	defaultNegativeMaxEntries = 10000
+)
+
+// negativeStatuses are the upstream statuses that are negatively cached. Other errors - like
+// a timeout or a server error - may well succeed on the next attempt so they are not cached.
+var negativeStatuses = []int{http.StatusNotFound, http.StatusUnauthorized, http.StatusForbidden}
+
+var nc = negativeCache{
+	entries: map[string]NegativeEntry{},
+}
+
+// negativeLookup returns the error the upstream answered with for the passed PullRequest if the
+// url is in the negative cache and the entry has not expired, otherwise nil. Expired entries are
+// removed.
+func negativeLookup(pr pullrequest.PullRequest) error {
+	nc.Lock()
+	defer nc.Unlock()
+	url := pr.Url()
+	entry, exists := nc.entries[url]
+	if !exists {
+		return nil
+	}
+	if time.Now().After(entry.Expires) {
+		delete(nc.entries, url)
+		return nil
+	}
+	return upstream.NewError(entry.Status, "%s (negative cache until %s)", entry.Error, entry.Expires.Format(time.RFC3339))
+}
+
+// addNegative adds the passed PullRequest to the negative cache if negative caching is enabled
+// and the passed error is an upstream 404, 401, or 403. Since entries are only removed when they
+// are looked up, the expired entries are swept once per ttl. If the cache is full then the entry
+// that expires first is evicted.
+func addNegative(pr pullrequest.PullRequest, err error) {
+	cfg := config.GetNegativeCache()
+	var ue *upstream.Error
+	if !cfg.Enabled || !errors.As(err, &ue) || !slices.Contains(negativeStatuses, ue.Status) {
+		return
+	}
+	ttl, perr := time.ParseDuration(cfg.Ttl)
+	if perr != nil || ttl <= 0 {
+		ttl = defaultNegativeTtl
+	}
+	maxEntries := cfg.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultNegativeMaxEntries
+	}
+	nc.Lock()
+	defer nc.Unlock()
+	url := pr.Url()
+	if time.Since(nc.swept) > ttl {
+		sweepNegative()
+	}
+	if _, exists := nc.entries[url]; !exists && len(nc.entries) >= maxEntries {
+		evictNegative()
+	}
+	log.Infof("negative caching %q for %s, status %d", url, ttl, ue.Status)
+	nc.entries[url] = NegativeEntry{
+		Url:     url,
+		Status:  ue.Status,
+		Error:   ue.Error(),
+		Expires: time.Now().Add(ttl),
+	}
+}
+
+// sweepNegative removes the expired entries from the negative cache. Must be called with the
+// negative cache locked.
+func sweepNegative() {
+	now := time.Now()
+	for url, entry := range nc.entries {
+		if now.After(entry.Expires) {
+			delete(nc.entries, url)
+		}
+	}
+	nc.swept = now
+}
+
+// evictNegative removes the entry that expires first from the negative cache. Must be called
+// with the negative cache locked.
+func evictNegative() {
+	first := ""
+	for url, entry := range nc.entries {
+		if first == "" || entry.Expires.Before(nc.entries[first].Expires) {
+			first = url
+		}
+	}
+	log.Debugf("negative cache is full, evicting %q", first)
+	delete(nc.entries, first)
+}
+
+// GetNegativeEntries returns the unexpired negative cache entries whose url the passed matcher
+// matches, sorted by url. At most count entries are returned.
+func GetNegativeEntries(matcher func(string) bool, count int) []NegativeEntry {
+	nc.Lock()
+	defer nc.Unlock()
+	entries := []NegativeEntry{}
+	now := time.Now()
+	for url, entry := range nc.entries {
+		if now.After(entry.Expires) {
+			delete(nc.entries, url)
+		} else if matcher(url) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Url < entries[j].Url
+	})
+	if count >= 0 && len(entries) > count {
+		entries = entries[:count]
+	}
+	return entries
+}
+
+// ClearNegative removes the negative cache entries whose url the passed matcher matches so the
+// next request for each of them goes to the upstream, and returns the count of entries removed.
+func ClearNegative(matcher func(string) bool) int {
+	nc.Lock()
+	defer nc.Unlock()
+	cleared := 0
+	for url := range nc.entries {
+		if matcher(url) {
+			delete(nc.entries, url)
+			cleared++
+		}
+	}
+	return cleared
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/impl/upstream"
+	"github.com/aceeric/ociregistry/mock"
+)
+
+var negativeConfig = `
+---
+registries:
+  - name: %s
+    scheme: http
+negativeCache:
+  enabled: true
+  ttl: 1h
+`
+
+// Pulls a tag that the upstream doesn't have and checks that the 404 is remembered so the
+// next pull doesn't go to the upstream, and that clearing the entry sends the next pull to
+// the upstream again.
+func TestNegativeCache(t *testing.T) {
+	ResetCache()
+	var requests atomic.Int32
+	callback := func(path string) {
+		if strings.HasSuffix(path, "/manifests/nope") {
+			requests.Add(1)
+		}
+	}
+	server, url := mock.ServerWithCallback(mock.NewMockParams(mock.NONE, mock.HTTP), &callback)
+	defer server.Close()
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(negativeConfig, url))); err != nil {
+		t.FailNow()
+	}
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	pr, err := pullrequest.NewPullRequestFromUrl(url + "/hello-world:nope")
+	if err != nil {
+		t.FailNow()
+	}
+	notFound := func() bool {
+		_, err := GetManifest(context.Background(), pr, td, 2000, false)
+		var ue *upstream.Error
+		return errors.As(err, &ue) && ue.Status == http.StatusNotFound
+	}
+	if !notFound() {
+		t.FailNow()
+	}
+	pulled := requests.Load()
+	if pulled == 0 || !notFound() || requests.Load() != pulled {
+		t.FailNow()
+	}
+	all := func(string) bool { return true }
+	if entries := GetNegativeEntries(all, -1); len(entries) != 1 || entries[0].Url != pr.Url() || entries[0].Status != http.StatusNotFound {
+		t.FailNow()
+	}
+	if ClearNegative(all) != 1 || len(GetNegativeEntries(all, -1)) != 0 {
+		t.FailNow()
+	}
+	if !notFound() || requests.Load() == pulled {
+		t.FailNow()
+	}
+}
+
+// Checks that expired entries are swept when an entry is added and that the negative cache is
+// capped at the configured max entries by evicting the entry that expires soonest.
+func TestNegativeCacheSize(t *testing.T) {
+	ResetCache()
+	if err := config.SetConfigFromStr([]byte("negativeCache:\n  enabled: true\n  ttl: 1h\n  maxEntries: 2\n")); err != nil {
+		t.FailNow()
+	}
+	add := func(ref string) string {
+		pr, err := pullrequest.NewPullRequestFromUrl("docker.io/hello-world:" + ref)
+		if err != nil {
+			t.FailNow()
+		}
+		addNegative(pr, upstream.NewError(http.StatusNotFound, "Status: 404"))
+		return pr.Url()
+	}
+	expire := func(url string) {
+		nc.Lock()
+		defer nc.Unlock()
+		entry := nc.entries[url]
+		entry.Expires = time.Now().Add(-time.Second)
+		nc.entries[url] = entry
+		nc.swept = time.Now().Add(-2 * time.Hour)
+	}
+	has := func(urls ...string) bool {
+		nc.Lock()
+		defer nc.Unlock()
+		for _, url := range urls {
+			if _, exists := nc.entries[url]; !exists {
+				return false
+			}
+		}
+		return len(nc.entries) == len(urls)
+	}
+	a, b := add("a"), add("b")
+	if !has(a, b) {
+		t.FailNow()
+	}
+	// the cache is full so adding evicts the entry that expires soonest
+	c := add("c")
+	if !has(b, c) {
+		t.FailNow()
+	}
+	// an expired entry is swept so adding doesn't evict the other entry
+	expire(b)
+	d := add("d")
+	if !has(c, d) {
+		t.FailNow()
+	}
+}
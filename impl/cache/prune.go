@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"slices"
 	"strconv"
@@ -243,23 +244,80 @@ func doPrune(imagePath string, comparer ManifestComparer, count int, dryRun bool
 // prune removes the passed manifest (and blobs if the manifest is an image manifest)
 // from the in-mem cache and from the file system. A lock is held on the manifest cache while
 // blobs are being pruned so the cache reports existence or non-existence of an image manifest
-// and its blobs as a single unit.
+// and its blobs as a single unit. Any referrers of the manifest (signatures, SBOMs etc.) are
+// pruned along with it. Since a referrer may itself have been selected for pruning, a manifest
+// that is no longer cached is skipped.
 func prune(mh imgpull.ManifestHolder, imagePath string) {
 	mc.Lock()
 	defer mc.Unlock()
+	bc.Lock()
+	defer bc.Unlock()
+	if pr, err := pullrequest.NewPullRequestFromUrl(mh.ImageUrl); err == nil {
+		if _, exists := fromCache(pr.Url()); !exists {
+			return
+		}
+	}
 	rmManifest(mh, imagePath)
 	if mh.IsImageManifest() {
-		bc.Lock()
-		defer bc.Unlock()
 		rmBlobs(mh, imagePath)
 	}
+	rmReferrers(mh, imagePath)
+}
+
+// DeleteManifest prunes the manifest matching the passed PullRequest, which supports the DELETE
+// endpoint. Since a manifest pulled by tag is cached by tag and by digest, deleting by either
+// deletes both. If the manifest is cached more than once (e.g. pulled as 'latest' and as another
+// tag) then all of them are deleted. Returns false if the manifest is not cached.
+func DeleteManifest(pr pullrequest.PullRequest, imagePath string) bool {
+	deleted := false
+	lastUrl := ""
+	for {
+		mc.Lock()
+		mh, exists := fromCache(pr.Url())
+		mc.Unlock()
+		if !exists {
+			return deleted
+		} else if mh.ImageUrl == lastUrl {
+			// the prior prune didn't remove it - don't loop forever
+			log.Errorf("unable to delete manifest %q", mh.ImageUrl)
+			return deleted
+		}
+		log.Infof("deleting manifest %q", mh.ImageUrl)
+		prune(mh, imagePath)
+		deleted = true
+		lastUrl = mh.ImageUrl
+	}
+}
+
+// ErrBlobReferenced means a blob can't be deleted because it is referenced by a cached manifest.
+var ErrBlobReferenced = errors.New("blob is referenced by a cached manifest")
+
+// DeleteBlob removes a blob that is not referenced by any cached manifest from the file system,
+// e.g. a blob that was uploaded to the hosted namespace but never referenced by a pushed manifest.
+// Referenced blobs are only removed by deleting the manifests that reference them, so that the
+// blob ref counts remain consistent. Returns false if the blob does not exist.
+func DeleteBlob(digest string, imagePath string) (bool, error) {
+	bc.Lock()
+	defer bc.Unlock()
+	if bc.blobs[digest] > 0 {
+		return true, ErrBlobReferenced
+	}
+	delete(bc.uploaded, digest)
+	if exists, _ := serialize.BlobExists(imagePath, digest); !exists {
+		return false, nil
+	}
+	log.Infof("deleting unreferenced blob: %s", digest)
+	return true, os.Remove(filepath.Join(imagePath, globals.BlobPath, digest))
 }
 
 // rmManifest removes the passed manifest from the manifest cache and the file system. If the
 // manifest is by tag, then the pair by-digest manifest is also removed from in-mem cache if
 // one exists. Manifests only exist once on the file system, but may exist twice in the in-mem
 // cache: once by tag and once by digest for retrieval both ways. The blobs for the manifest
-// (if any) are *not* removed.
+// (if any) are *not* removed. The file of a manifest is named by digest so it is shared by every
+// cached manifest with the same digest - e.g. two tags of one image. If another cached manifest
+// has the digest then the file is kept - see 'keepManifest'. When the tag was last revalidated is
+// also forgotten.
 func rmManifest(mh imgpull.ManifestHolder, imagePath string) {
 	pr, err := pullrequest.NewPullRequestFromUrl(mh.ImageUrl)
 	if err != nil {
@@ -267,12 +325,46 @@ func rmManifest(mh imgpull.ManifestHolder, imagePath string) {
 		return
 	}
 	mc.delete(pr, mh.Digest)
+	rmChecked(pr.Url())
+	if keepManifest(pr, mh.Digest, imagePath) {
+		log.Infof("removed manifest: %s, the manifest file is still referenced", pr.Url())
+		return
+	}
 	if err := serialize.RmManifest(imagePath, mh); err != nil {
 		log.Errorf("error removing manifest %q from the file system. the error was: %s", pr.Url(), err)
 	}
 	log.Infof("removed manifest: %s", pr.Url())
 }
 
+// keepManifest looks for a cached manifest other than the passed removed one that has the passed
+// digest and is stored in the same directory - see 'serialize.MhToFilesystem'. If there is one
+// then the manifest file is re-written with it so that the file is re-loaded on startup under a
+// url that is still cached, the by-digest entry that the removal shared with it is restored, and
+// true is returned. Must be called with the manifest cache locked.
+func keepManifest(removed pullrequest.PullRequest, digest string, imagePath string) bool {
+	manifests := mc.manifests
+	if removed.IsLatest() {
+		manifests = mc.latest
+	}
+	for url, other := range manifests {
+		if other.Digest != digest {
+			continue
+		}
+		if err := serialize.MhToFilesystem(other, imagePath, true); err != nil {
+			log.Errorf("error re-writing manifest %q to the file system. the error was: %s", url, err)
+		}
+		if pr, err := pullrequest.NewPullRequestFromUrl(other.ImageUrl); err == nil && pr.PullType == pullrequest.ByTag {
+			byDigest := pr.UrlWithDigest("sha256:" + digest)
+			if _, exists := manifests[byDigest]; !exists {
+				metrics.DeltaCachedManifestCount(1)
+				manifests[byDigest] = other
+			}
+		}
+		return true
+	}
+	return false
+}
+
 // delete actually deletes a manifest from the in-mem cache taking into account whether
 // the manifest is tagged "latest" or not.
 func (mc *manifestCache) delete(pr pullrequest.PullRequest, digest string) {
@@ -304,11 +396,12 @@ func rmBlobs(mh imgpull.ManifestHolder, imagePath string) error {
 }
 
 // rmBlob decrements the ref count for the passed blob digest. If zero, the function removes the
-// blob from the blob map and the file system.
+// blob from the blob map and the file system - or from the pending blobs if it was never fetched.
 func rmBlob(digest string, imagePath string) error {
 	bc.blobs[digest]--
 	if bc.blobs[digest] == 0 {
 		delete(bc.blobs, digest)
+		rmPending(digest)
 		if err := serialize.RmBlob(imagePath, digest); err != nil {
 			return fmt.Errorf("error removing blob %q from the file system. the error was: %s", digest, err)
 		} else {
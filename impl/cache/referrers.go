@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/aceeric/ociregistry/impl/globals"
+	"github.com/aceeric/ociregistry/impl/helpers"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/impl/upstream"
+
+	"github.com/aceeric/imgpull/pkg/imgpull"
+	"github.com/aceeric/imgpull/pkg/imgpull/v1oci"
+	log "github.com/sirupsen/logrus"
+)
+
+// indexMediaType is the media type of a referrers index.
+const indexMediaType = "application/vnd.oci.image.index.v1+json"
+
+// GetReferrers returns the referrers index for the subject manifest in the passed PullRequest,
+// which must be by digest. If not air-gapped, the index is fetched from the upstream - first from
+// the referrers API and then, if the upstream doesn't support that API, from the tag schema
+// (e.g. 'sha256-<hex>'.) The index and every manifest it references (along with their blobs)
+// are cached next to the subject so that the referrers can be served when air-gapped. The
+// index is cached as a manifest under the tag schema URL of the subject. If the upstream
+// can't be reached then the cached index is returned. If there are no referrers an empty
+// index is returned, and a previously cached index is removed along with its referrers so
+// they aren't served once the upstream can't be reached.
+func GetReferrers(pr pullrequest.PullRequest, imagePath string, pullTimeout int, airGapped bool) (v1oci.Index, error) {
+	ipr := referrersPr(pr.Remote, pr.Repository, pr.Reference)
+	var err error
+	if !airGapped {
+		var mh imgpull.ManifestHolder
+		var found bool
+		mh, found, err = fetchReferrers(ipr, pr.Reference, pullTimeout)
+		if err == nil {
+			if !found {
+				mc.Lock()
+				defer mc.Unlock()
+				bc.Lock()
+				defer bc.Unlock()
+				rmReferrersIndex(ipr, imagePath)
+				return emptyIndex(), nil
+			}
+			if err := cacheReferrers(ipr, mh, imagePath, pullTimeout); err != nil {
+				log.Errorf("error caching referrers for %q: %s", pr.Url(), err)
+			}
+			return mh.V1ociIndex, nil
+		}
+		log.Warnf("unable to get referrers for %q from the upstream - using the cache. The error was: %s", pr.Url(), err)
+	}
+	mc.Lock()
+	mh, exists := fromCache(ipr.Url())
+	mc.Unlock()
+	if exists {
+		return mh.V1ociIndex, nil
+	}
+	if airGapped {
+		return emptyIndex(), nil
+	}
+	return v1oci.Index{}, fmt.Errorf("referrers for %q not available from the upstream or the cache: %w", pr.Url(), err)
+}
+
+// referrersPr returns a PullRequest for the referrers index of the passed subject
+// digest using the tag schema from the distribution spec. E.g. for subject digest
+// 'sha256:abc...' the tag is 'sha256-abc...'.
+func referrersPr(remote, repository, digest string) pullrequest.PullRequest {
+	return pullrequest.PullRequest{
+		PullType:   pullrequest.ByTag,
+		Remote:     remote,
+		Repository: repository,
+		Reference:  "sha256-" + helpers.GetDigestFrom(digest),
+	}
+}
+
+// isReferrersTag returns true if the passed reference is a tag schema tag like 'sha256-<hex>'
+// that a referrers index is cached under. These are not real tags of the repository so they are
+// left out of the tags list and the catalog.
+func isReferrersTag(reference string) bool {
+	return strings.HasPrefix(reference, "sha256-")
+}
+
+// isReferrersIndex returns true if the passed manifest is a referrers index that was cached
+// under the tag schema. The index is also cached by digest so the url it was cached under isn't
+// enough to tell.
+func isReferrersIndex(mh imgpull.ManifestHolder) bool {
+	return strings.Contains(mh.ImageUrl, ":sha256-")
+}
+
+// fetchReferrers gets the referrers index for the passed subject digest from the upstream. The
+// bool return value is false if the upstream has no referrers for the subject. If the registry has
+// mirrors then each is tried in order until one answers.
+func fetchReferrers(ipr pullrequest.PullRequest, digest string, pullTimeout int) (imgpull.ManifestHolder, bool, error) {
+	mh, found := emptyManifestHolder, false
+	err := upstream.WithEndpoints(ipr.Remote, ipr.Repository, pullTimeout, func(client *upstream.Client) error {
+		hdrs := map[string]string{"Accept": indexMediaType}
+		for _, path := range []string{"referrers/" + digest, "manifests/" + ipr.Reference} {
+			resp, err := client.Get(path, hdrs)
+			if err != nil {
+				return err
+			}
+			if resp.StatusCode == http.StatusNotFound {
+				// 404 from the referrers API means the upstream doesn't implement it so
+				// try the tag schema, and 404 from the tag schema means no referrers
+				resp.Body.Close()
+				continue
+			} else if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				return upstream.NewError(resp.StatusCode, "get %s for %q returned status %d", path, client.Repository(), resp.StatusCode)
+			}
+			b, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(b)
+			if mh, err = imgpull.NewManifestHolder(indexMediaType, b, hex.EncodeToString(sum[:]), ipr.Url()); err != nil {
+				return err
+			}
+			found = true
+			return nil
+		}
+		return nil
+	})
+	if err != nil {
+		return emptyManifestHolder, false, err
+	}
+	return mh, found, nil
+}
+
+// cacheReferrers pulls every manifest referenced by the passed referrers index into the cache
+// and then adds the index itself to the cache. Any manifests referenced by a previously cached
+// index for the same subject that are not referenced by the new index are removed.
+func cacheReferrers(ipr pullrequest.PullRequest, mh imgpull.ManifestHolder, imagePath string, pullTimeout int) error {
+	for _, digest := range mh.ImageManifestDigests() {
+		pr := pullrequest.PullRequest{
+			PullType:   pullrequest.ByDigest,
+			Remote:     ipr.Remote,
+			Repository: ipr.Repository,
+			Reference:  digest,
+		}
+		if _, err := GetManifest(context.Background(), pr, imagePath, pullTimeout, false); err != nil {
+			log.Errorf("error pulling referrer %q: %s", pr.Url(), err)
+		}
+	}
+	mc.Lock()
+	existing, exists := fromCache(ipr.Url())
+	mc.Unlock()
+	if exists && existing.Digest == mh.Digest {
+		return nil
+	}
+	mh.Created = globals.CurTime()
+	mh.Pulled = globals.CurTime()
+	if err := serialize.MhToFilesystem(mh, imagePath, true); err != nil {
+		return err
+	}
+	if err := replaceInCache(ipr, mh, imagePath); err != nil {
+		return err
+	}
+	if exists {
+		mc.Lock()
+		defer mc.Unlock()
+		bc.Lock()
+		defer bc.Unlock()
+		for _, digest := range existing.ImageManifestDigests() {
+			if !slices.Contains(mh.ImageManifestDigests(), digest) {
+				rmReferrer(ipr, digest, imagePath)
+			}
+		}
+	}
+	return nil
+}
+
+// rmReferrers removes the referrers index for the passed subject manifest from the cache along
+// with all the manifests referenced by the index. The caller must hold the manifest cache
+// and blob cache locks.
+func rmReferrers(mh imgpull.ManifestHolder, imagePath string) {
+	pr, err := pullrequest.NewPullRequestFromUrl(mh.ImageUrl)
+	if err != nil {
+		return
+	}
+	rmReferrersIndex(referrersPr(pr.Remote, pr.Repository, mh.Digest), imagePath)
+}
+
+// rmReferrersIndex removes the referrers index in the passed PullRequest from the cache along
+// with all the manifests referenced by the index. The caller must hold the manifest cache and
+// blob cache locks.
+func rmReferrersIndex(ipr pullrequest.PullRequest, imagePath string) {
+	idx, exists := fromCache(ipr.Url())
+	if !exists {
+		return
+	}
+	for _, digest := range idx.ImageManifestDigests() {
+		rmReferrer(ipr, digest, imagePath)
+	}
+	rmManifest(idx, imagePath)
+}
+
+// rmReferrer removes one manifest referenced by a referrers index (and its blobs) from the
+// cache. The caller must hold the manifest cache and blob cache locks.
+func rmReferrer(ipr pullrequest.PullRequest, digest string, imagePath string) {
+	url := ipr.UrlWithDigest(digest)
+	if mh, exists := fromCache(url); exists {
+		rmManifest(mh, imagePath)
+		if mh.IsImageManifest() {
+			rmBlobs(mh, imagePath)
+		}
+	}
+}
+
+// emptyIndex returns a referrers index with no manifests.
+func emptyIndex() v1oci.Index {
+	return v1oci.Index{
+		SchemaVersion: 2,
+		MediaType:     indexMediaType,
+		Manifests:     []v1oci.Descriptor{},
+	}
+}
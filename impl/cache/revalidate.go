@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/globals"
+	"github.com/aceeric/ociregistry/impl/helpers"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/upstream"
+
+	"github.com/aceeric/imgpull/pkg/imgpull"
+	log "github.com/sirupsen/logrus"
+)
+
+// Type revalidations tracks the background revalidation of cached tags. The key of each map is
+// a tag url like docker.io/library/hello-world:stable. The checked map has the time each tag was
+// last checked against the upstream, and the inProgress map has the tags being checked right
+// now so that concurrent requests for the same tag only start one revalidation.
+type revalidations struct {
+	sync.Mutex
+	checked    map[string]time.Time
+	inProgress map[string]bool
+}
+
+var rv = revalidations{
+	checked:    map[string]time.Time{},
+	inProgress: map[string]bool{},
+}
+
+// Revalidate implements stale-while-revalidate for the passed manifest, which the caller has
+// just served for the passed PullRequest by tag. If the tag was pulled - or last checked - more
+// than the passed ttl ago then a HEAD request is sent to the upstream in the background to get
+// the current digest of the tag. If the digest changed then the new manifest (and blobs) are
+// pulled and swapped in by a forced pull. The function doesn't block: the client is served
+// the cached manifest and the next request for the tag gets the new one.
+func Revalidate(pr pullrequest.PullRequest, mh imgpull.ManifestHolder, imagePath string, pullTimeout int, ttl time.Duration) {
+	url := pr.Url()
+	rv.Lock()
+	defer rv.Unlock()
+	if rv.inProgress[url] || time.Since(lastChecked(url, mh)) < ttl {
+		return
+	}
+	rv.inProgress[url] = true
+	background.Go(func() {
+		revalidate(pr, mh, imagePath, pullTimeout)
+		setChecked(url)
+		rv.Lock()
+		defer rv.Unlock()
+		delete(rv.inProgress, url)
+	})
+}
+
+// Stale returns true if the tag in the passed PullRequest is cached and was pulled - or last
+// checked against the upstream - more than the passed ttl ago. A ttl of zero means the tag is
+// always stale.
+func Stale(pr pullrequest.PullRequest, ttl time.Duration) bool {
+	mc.Lock()
+	mh, exists := fromCache(pr.Url())
+	if !exists && pr.AltDockerUrl() != "" {
+		mh, exists = fromCache(pr.AltDockerUrl())
+	}
+	mc.Unlock()
+	if !exists {
+		return false
+	}
+	rv.Lock()
+	defer rv.Unlock()
+	return time.Since(lastChecked(pr.Url(), mh)) >= ttl
+}
+
+// revalidate checks the digest of the tag in the passed PullRequest against the upstream and
+// re-pulls the tag if the digest is different from the digest of the passed manifest. The HEAD
+// request goes to the first healthy endpoint for the registry, and is skipped if the circuit
+// breaker for the registry is open or if pulls from the endpoint are held back. The HEAD request
+// and the pull are prefetch priority for the concurrent pull limits. Errors are logged and leave
+// the cached manifest in place.
+func revalidate(pr pullrequest.PullRequest, mh imgpull.ManifestHolder, imagePath string, pullTimeout int) {
+	url := pr.Url()
+	if err := upstream.CheckBreaker(pr.Remote); err != nil {
+		log.Debugf("not revalidating %q: %s", url, err)
+		return
+	}
+	ep := upstream.Endpoints(pr.Remote)[0]
+	if err := upstream.CheckRateLimit(ep.Host()); err != nil {
+		log.Debugf("not revalidating %q: %s", url, err)
+		return
+	}
+	puller, err := pullerFor(pr, ep)
+	if err != nil {
+		log.Errorf("unable to revalidate %q, the error was: %s", url, err)
+		return
+	}
+	defer puller.Close()
+	ctx := upstream.WithPriority(context.Background(), upstream.PriorityPrefetch)
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(pullTimeout)*time.Millisecond)
+	defer cancel()
+	release, err := upstream.Acquire(waitCtx, pr.Remote)
+	if err != nil {
+		log.Warnf("unable to revalidate %q, the error was: %s", url, err)
+		return
+	}
+	md, err := puller.HeadManifest()
+	release()
+	upstream.RecordPull(pr.Remote, upstream.FromPullError(err))
+	upstream.RecordRateLimitError(ep.Host(), upstream.FromPullError(err))
+	if err != nil {
+		log.Warnf("unable to revalidate %q, the error was: %s", url, err)
+		return
+	}
+	digest := helpers.GetDigestFrom(md.Digest)
+	if digest == mh.Digest {
+		log.Debugf("revalidated %q: digest unchanged", url)
+		return
+	}
+	log.Infof("revalidated %q: digest changed from %s to %s, pulling", url, mh.Digest, digest)
+	if _, err := GetManifest(ctx, pr, imagePath, pullTimeout, true); err != nil {
+		log.Errorf("error pulling %q to revalidate it, the error was: %s", url, err)
+	}
+}
+
+// lastChecked returns when the passed tag url was last checked against the upstream: the later
+// of when it was pulled and when it was last revalidated. Must be called with the revalidations
+// locked. The created time of the manifest is local time - see 'globals.CurTime'.
+func lastChecked(url string, mh imgpull.ManifestHolder) time.Time {
+	last := rv.checked[url]
+	if created, err := time.ParseInLocation(globals.DateFormat, mh.Created, time.Local); err == nil && created.After(last) {
+		last = created
+	}
+	return last
+}
+
+// setChecked records that the passed tag url was just checked against the upstream.
+func setChecked(url string) {
+	rv.Lock()
+	defer rv.Unlock()
+	rv.checked[url] = time.Now()
+}
+
+// rmChecked forgets when the passed tag url was last checked against the upstream. It is called
+// when the tag is removed from the cache so the checked times don't grow without bound.
+func rmChecked(url string) {
+	rv.Lock()
+	defer rv.Unlock()
+	delete(rv.checked, url)
+}
@@ -0,0 +1,214 @@
+package impl
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aceeric/ociregistry/impl/serverauth"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// claimsKey is the Echo context key for the claims of a verified bearer token.
+	claimsKey = "claims"
+	// userKey is the Echo context key for the user authenticated with basic auth.
+	userKey = "user"
+)
+
+// Authenticate is Echo middleware that authenticates clients according to the server auth
+// mode. In basic mode every /v2 and /cmd request requires basic auth credentials for one
+// of the configured users. In token mode, if a request has a bearer token then the token is
+// verified and the claims are saved in the context. The repository endpoints are authorized
+// by 'dispatch' since that is where the repository name is parsed from the path, so the 401
+// challenge can have the scope that the client needs. The other /v2 endpoints just require
+// a valid token. The token endpoint itself - /v2/auth - is not checked. The /cmd endpoints
+// don't require a token but if one is presented it identifies the client. If server auth is
+// not enabled then the middleware does nothing.
+func Authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		path := ctx.Request().URL.Path
+		v2 := path == "/v2" || strings.HasPrefix(path, "/v2/")
+		switch serverauth.Mode() {
+		case serverauth.ModeBasic:
+			if v2 || strings.HasPrefix(path, "/cmd/") {
+				if re := basicAuth(ctx); re != nil {
+					return re.Send(ctx)
+				}
+			}
+		case serverauth.ModeToken:
+			if v2 && path != "/v2/auth" {
+				if re := tokenAuth(ctx); re != nil {
+					return re.Send(ctx)
+				}
+			} else if strings.HasPrefix(path, "/cmd/") {
+				verifyToken(ctx)
+			}
+		}
+		return next(ctx)
+	}
+}
+
+// basicAuth checks the basic auth credentials of the request. If the credentials are missing or
+// invalid then the error has a basic challenge.
+func basicAuth(ctx echo.Context) *RegistryError {
+	user, password, ok := ctx.Request().BasicAuth()
+	if ok && serverauth.CheckUser(user, password) {
+		ctx.Set(userKey, user)
+		return nil
+	}
+	if ok {
+		log.Warnf("request with invalid credentials for user %q from %s", user, ctx.Request().RemoteAddr)
+	}
+	ctx.Response().Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q`, serverauth.BasicRealm))
+	return NewRegistryError(http.StatusUnauthorized, Unauthorized, "authentication required")
+}
+
+// tokenAuth verifies the bearer token of the request, if present, and saves the claims in the
+// context. Requests to the repository endpoints are passed through to be authorized by dispatch.
+func tokenAuth(ctx echo.Context) *RegistryError {
+	verifyToken(ctx)
+	path := ctx.Request().URL.Path
+	if ctx.Path() == repositoryRoute {
+		return nil
+	}
+	if path == "/v2/_catalog" {
+		return authorize(ctx, "registry", "catalog", "*")
+	} else if _, ok := ctx.Get(claimsKey).(serverauth.Claims); !ok {
+		return challenge(ctx, "", "")
+	}
+	return nil
+}
+
+// verifyToken verifies the bearer token of the request, if present, and if valid saves the
+// claims in the context.
+func verifyToken(ctx echo.Context) {
+	if token, found := strings.CutPrefix(ctx.Request().Header.Get("Authorization"), "Bearer "); found {
+		if claims, err := serverauth.Verify(token); err == nil {
+			ctx.Set(claimsKey, claims)
+		} else {
+			log.Debugf("rejected bearer token for %s: %s", ctx.Request().URL.Path, err)
+		}
+	}
+}
+
+// clientIdentity returns the identity of the client: the user authenticated with basic auth,
+// or the subject of a verified bearer token, or the common name of a verified client certificate.
+// If the client can't be identified then the empty string is returned.
+func clientIdentity(ctx echo.Context) string {
+	if user, ok := ctx.Get(userKey).(string); ok {
+		return user
+	}
+	if claims, ok := ctx.Get(claimsKey).(serverauth.Claims); ok {
+		return claims.Subject
+	}
+	return clientCN(ctx)
+}
+
+// authorizeRepository checks that the token for the request grants the passed action on the
+// passed repository. Nil is returned if the request is authorized or if token auth is not
+// enabled, otherwise an error to send to the client.
+func authorizeRepository(ctx echo.Context, name []string, action string) *RegistryError {
+	if serverauth.Mode() != serverauth.ModeToken {
+		return nil
+	}
+	return authorize(ctx, "repository", strings.Join(name, "/"), action)
+}
+
+// authorize checks that the token for the request grants the passed action on the passed
+// resource. If not, the error has a challenge for the scope the client needs.
+func authorize(ctx echo.Context, typ, name, action string) *RegistryError {
+	scope := fmt.Sprintf("%s:%s:%s", typ, name, action)
+	claims, ok := ctx.Get(claimsKey).(serverauth.Claims)
+	if !ok {
+		return challenge(ctx, scope, "")
+	}
+	if !claims.Allows(typ, name, action) {
+		log.Infof("token for %q does not grant %s", claims.Subject, scope)
+		return challenge(ctx, scope, "insufficient_scope")
+	}
+	return nil
+}
+
+// challenge sets a bearer WWW-Authenticate header on the response and returns a 401 error.
+// If the request had a token that couldn't be verified then the challenge says so.
+func challenge(ctx echo.Context, scope string, errParam string) *RegistryError {
+	realm := serverauth.Realm()
+	if realm == "" {
+		realm = fmt.Sprintf("%s://%s/v2/auth", ctx.Scheme(), ctx.Request().Host)
+	}
+	hdr := fmt.Sprintf(`Bearer realm=%q,service=%q`, realm, serverauth.Service())
+	if scope != "" {
+		hdr += fmt.Sprintf(`,scope=%q`, scope)
+	}
+	if errParam == "" && strings.HasPrefix(ctx.Request().Header.Get("Authorization"), "Bearer ") {
+		errParam = "invalid_token"
+	}
+	if errParam != "" {
+		hdr += fmt.Sprintf(`,error=%q`, errParam)
+	}
+	ctx.Response().Header().Set("WWW-Authenticate", hdr)
+	return NewRegistryError(http.StatusUnauthorized, Unauthorized, "authentication required").WithDetail(scope)
+}
+
+// actionFor returns the token action that the passed request method requires.
+func actionFor(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "pull"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return "push"
+}
+
+// issueToken handles /v2/auth in token mode. The client is identified by basic auth credentials
+// for a configured user, or by the common name of a verified client certificate. The token grants
+// the requested scopes - or if the access policy is enabled, only the actions of the requested
+// scopes that the policy allows the client - see 'grantable'.
+func (r *OciRegistry) issueToken(ctx echo.Context, scopes []string) error {
+	identity := ""
+	if user, password, ok := ctx.Request().BasicAuth(); ok {
+		if !serverauth.CheckUser(user, password) {
+			log.Warnf("token request with invalid credentials for user %q from %s", user, ctx.Request().RemoteAddr)
+		} else {
+			identity = user
+		}
+	} else if cn := clientCN(ctx); cn != "" {
+		identity = cn
+	}
+	if identity == "" {
+		ctx.Response().Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q`, serverauth.BasicRealm))
+		return NewRegistryError(http.StatusUnauthorized, Unauthorized, "authentication required").Send(ctx)
+	}
+	access := []serverauth.Access{}
+	for _, scope := range scopes {
+		for _, s := range strings.Fields(scope) {
+			a, err := serverauth.ParseScope(s)
+			if err != nil {
+				return NewRegistryError(http.StatusBadRequest, Unsupported, err.Error()).Send(ctx)
+			}
+			access = append(access, a)
+		}
+	}
+	access = r.grantable(ctx, identity, access)
+	token, expiresIn, err := serverauth.Issue(identity, access)
+	if err != nil {
+		return NewRegistryError(http.StatusInternalServerError, Unknown, err.Error()).Send(ctx)
+	}
+	log.Infof("issued token to %q for scope(s) %v, granted %v", identity, scopes, access)
+	body := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{
+		Token:       token,
+		AccessToken: token,
+		ExpiresIn:   expiresIn,
+	}
+	ctx.Response().Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
+	return ctx.JSON(http.StatusOK, body)
+}
@@ -0,0 +1,195 @@
+package impl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aceeric/ociregistry/api"
+	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/impl/serverauth"
+
+	"github.com/labstack/echo/v4"
+	middleware "github.com/oapi-codegen/echo-middleware"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Gets a token from the token endpoint with basic auth and uses it to pull from the hosted
+// namespace, checking the challenges for requests without a token and for a repository that
+// the token doesn't grant.
+func TestTokenAuth(t *testing.T) {
+	defer serverauth.Init(config.ServerAuthCfg{})
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(hostedCfg, td))); err != nil {
+		t.FailNow()
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.FailNow()
+	}
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.FailNow()
+	}
+	keyFile := filepath.Join(td, "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600); err != nil {
+		t.FailNow()
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("xyzzy"), bcrypt.MinCost)
+	if err != nil {
+		t.FailNow()
+	}
+	cfg := config.ServerAuthCfg{
+		Mode:  "token",
+		Users: []config.ServerUserCfg{{Name: "frobozz", Password: string(hash)}},
+		Token: config.ServerTokenCfg{SigningKey: keyFile},
+	}
+	if err := serverauth.Init(cfg); err != nil {
+		t.FailNow()
+	}
+	swagger, err := api.GetSwagger()
+	if err != nil {
+		t.FailNow()
+	}
+	swagger.Servers = nil
+	cache.ResetCache()
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(Authenticate)
+	e.Use(middleware.OapiRequestValidatorWithOptions(swagger, &middleware.Options{Skipper: SkipValidation}))
+	api.RegisterHandlers(e, r)
+	RegisterRepositoryHandlers(e, r)
+	do := func(path string, hdrs ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i < len(hdrs); i += 2 {
+			req.Header.Set(hdrs[i], hdrs[i+1])
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	manifest := "/v2/local.registry/hello-world/manifests/v1"
+	rec := do(manifest)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Header().Get("WWW-Authenticate"), `scope="repository:local.registry/hello-world:pull"`) {
+		t.FailNow()
+	}
+	if re := errorBody(t, rec); re.Code != Unauthorized {
+		t.FailNow()
+	}
+	scope := "/v2/auth?service=ociregistry&scope=repository:local.registry/hello-world:pull"
+	if rec := do(scope); rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Basic ") {
+		t.FailNow()
+	}
+	if rec := do(scope, "Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("frobozz:plugh"))); rec.Code != http.StatusUnauthorized {
+		t.FailNow()
+	}
+	rec = do(scope, "Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("frobozz:xyzzy")))
+	if rec.Code != http.StatusOK {
+		t.FailNow()
+	}
+	body := struct {
+		Token string `json:"token"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Token == "" {
+		t.FailNow()
+	}
+	bearer := "Bearer " + body.Token
+	for _, tst := range []struct {
+		path   string
+		hdrs   []string
+		status int
+		errHdr string
+	}{
+		{manifest, []string{"Authorization", bearer}, http.StatusNotFound, ""},
+		{"/v2/local.registry/other/manifests/v1", []string{"Authorization", bearer}, http.StatusUnauthorized, "insufficient_scope"},
+		{manifest, []string{"Authorization", "Bearer frobozz"}, http.StatusUnauthorized, "invalid_token"},
+		{"/v2/", []string{"Authorization", bearer}, http.StatusOK, ""},
+		{"/v2/", nil, http.StatusUnauthorized, ""},
+		{"/v2/_catalog", []string{"Authorization", bearer}, http.StatusUnauthorized, "insufficient_scope"},
+	} {
+		rec := do(tst.path, tst.hdrs...)
+		if rec.Code != tst.status {
+			t.Errorf("%s: expected %d, got %d", tst.path, tst.status, rec.Code)
+		} else if tst.errHdr != "" && !strings.Contains(rec.Header().Get("WWW-Authenticate"), tst.errHdr) {
+			t.Errorf("%s: unexpected challenge %q", tst.path, rec.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+// Checks that basic auth gates the /v2 and /cmd endpoints with users from an htpasswd file.
+func TestBasicAuth(t *testing.T) {
+	defer serverauth.Init(config.ServerAuthCfg{})
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(hostedCfg, td))); err != nil {
+		t.FailNow()
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("xyzzy"), bcrypt.MinCost)
+	if err != nil {
+		t.FailNow()
+	}
+	htpasswd := filepath.Join(td, "htpasswd")
+	if err := os.WriteFile(htpasswd, []byte("frobozz:"+string(hash)+"\n"), 0600); err != nil {
+		t.FailNow()
+	}
+	if err := serverauth.Init(config.ServerAuthCfg{Mode: "basic", Htpasswd: htpasswd}); err != nil {
+		t.FailNow()
+	}
+	cache.ResetCache()
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(Authenticate)
+	api.RegisterHandlers(e, r)
+	RegisterRepositoryHandlers(e, r)
+	valid := "Basic " + base64.StdEncoding.EncodeToString([]byte("frobozz:xyzzy"))
+	invalid := "Basic " + base64.StdEncoding.EncodeToString([]byte("frobozz:plugh"))
+	for _, tst := range []struct {
+		path   string
+		auth   string
+		status int
+	}{
+		{"/v2/", "", http.StatusUnauthorized},
+		{"/v2/", invalid, http.StatusUnauthorized},
+		{"/v2/", valid, http.StatusOK},
+		{"/v2/local.registry/hello-world/manifests/v1", "", http.StatusUnauthorized},
+		{"/v2/local.registry/hello-world/manifests/v1", valid, http.StatusNotFound},
+		{"/cmd/manifest/list", "", http.StatusUnauthorized},
+		{"/cmd/manifest/list", valid, http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tst.path, nil)
+		if tst.auth != "" {
+			req.Header.Set("Authorization", tst.auth)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tst.status {
+			t.Errorf("%s: expected %d, got %d", tst.path, tst.status, rec.Code)
+		} else if tst.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != `Basic realm="ociregistry"` {
+			t.Errorf("%s: unexpected challenge %q", tst.path, rec.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
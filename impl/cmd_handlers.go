@@ -6,9 +6,11 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/aceeric/ociregistry/api/models"
 	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/upstream"
 
 	"github.com/aceeric/imgpull/pkg/imgpull"
 	"github.com/labstack/echo/v4"
@@ -92,6 +94,79 @@ func (r *OciRegistry) CmdPrune(ctx echo.Context, params models.CmdPruneParams) e
 	return ctx.Stream(http.StatusOK, "text/plain", strings.NewReader(logs))
 }
 
+// GET /cmd/negative/list?pattern=...
+func (r *OciRegistry) CmdNegativelist(ctx echo.Context, params models.CmdNegativelistParams) error {
+	matcher, err := makeUrlMatcher(params.Pattern)
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, "invalid parameters\n")
+	}
+	entries := cache.GetNegativeEntries(matcher, count(params.Count))
+	if len(entries) == 0 {
+		return ctx.String(http.StatusOK, "no negative cache entries found\n")
+	}
+	var list strings.Builder
+	list.WriteString("URL STATUS EXPIRES ERROR\n")
+	for _, entry := range entries {
+		list.WriteString(fmt.Sprintf("%s %d %s %s\n", entry.Url, entry.Status, entry.Expires.Format(time.RFC3339), entry.Error))
+	}
+	return ctx.String(http.StatusOK, list.String())
+}
+
+// DELETE /cmd/negative/clear?pattern=... If no pattern then all entries are cleared.
+func (r *OciRegistry) CmdNegativeclear(ctx echo.Context, params models.CmdNegativeclearParams) error {
+	matcher, err := makeUrlMatcher(params.Pattern)
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, "invalid parameters\n")
+	}
+	cleared := cache.ClearNegative(matcher)
+	log.Infof("cleared %d negative cache entries", cleared)
+	return ctx.String(http.StatusOK, fmt.Sprintf("cleared %d negative cache entries\n", cleared))
+}
+
+// GET /cmd/breaker/list
+func (r *OciRegistry) CmdBreakerlist(ctx echo.Context) error {
+	statuses := upstream.GetBreakers()
+	if len(statuses) == 0 {
+		return ctx.String(http.StatusOK, "no circuit breakers found\n")
+	}
+	var list strings.Builder
+	list.WriteString("REGISTRY STATE FAILURES UNTIL\n")
+	for _, status := range statuses {
+		until := "-"
+		if status.State != upstream.BreakerClosed {
+			until = status.Until.Format(time.RFC3339)
+		}
+		list.WriteString(fmt.Sprintf("%s %s %d %s\n", status.Registry, status.State, status.Failures, until))
+	}
+	return ctx.String(http.StatusOK, list.String())
+}
+
+// makeUrlMatcher makes a function that matches urls against the passed comma-separated list of
+// regular expressions. If pattern is nil then the function matches every url.
+func makeUrlMatcher(pattern *string) (func(string) bool, error) {
+	if pattern == nil {
+		return func(string) bool {
+			return true
+		}, nil
+	}
+	srchs := []*regexp.Regexp{}
+	for ref := range strings.SplitSeq(*pattern, ",") {
+		exp, err := regexp.Compile(ref)
+		if err != nil {
+			return nil, fmt.Errorf("regex did not compile: %q", ref)
+		}
+		srchs = append(srchs, exp)
+	}
+	return func(url string) bool {
+		for _, srch := range srchs {
+			if srch.MatchString(url) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
 // makeComparer makes a comparer. If pattern is non-nil, it is used, else if digest is
 // non-nil, it is used, else a comparer that always returns true is returned.
 func makeComparer(pattern *string, digest *string) (cache.ManifestComparer, error) {
@@ -15,6 +15,7 @@ import (
 	"github.com/aceeric/ociregistry/impl/config"
 	"github.com/aceeric/ociregistry/impl/globals"
 	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/mock"
 	"github.com/labstack/echo/v4"
 )
 
@@ -160,3 +161,51 @@ func setupTests() (string, error) {
 	}
 	return td, nil
 }
+
+// Pulls two tags that the upstream doesn't have and checks the negative cache list and clear
+// endpoints.
+func TestCmdNegative(t *testing.T) {
+	cache.ResetCache()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	defer server.Close()
+	cfg := fmt.Sprintf(serverCfg, td, 1000, false, url) + "negativeCache:\n  enabled: true\n"
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.FailNow()
+	}
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	body := func(rec *httptest.ResponseRecorder) string {
+		b, _ := io.ReadAll(rec.Result().Body)
+		return string(b)
+	}
+	for _, tag := range []string{"nope", "nada"} {
+		rec := httptest.NewRecorder()
+		r.handleV2ManifestsReference(e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec), tag, &url, http.MethodGet, "hello-world")
+		if rec.Code != http.StatusNotFound {
+			t.FailNow()
+		}
+	}
+	rec := httptest.NewRecorder()
+	r.CmdNegativelist(e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec), models.CmdNegativelistParams{})
+	// header line and a line for each tag
+	if rec.Code != http.StatusOK || strings.Count(body(rec), "\n") != 3 {
+		t.FailNow()
+	}
+	pattern := ":nope$"
+	rec = httptest.NewRecorder()
+	r.CmdNegativeclear(e.NewContext(httptest.NewRequest(http.MethodDelete, "/", nil), rec), models.CmdNegativeclearParams{Pattern: &pattern})
+	if rec.Code != http.StatusOK || body(rec) != "cleared 1 negative cache entries\n" {
+		t.FailNow()
+	}
+	rec = httptest.NewRecorder()
+	r.CmdNegativelist(e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec), models.CmdNegativelistParams{})
+	if lines := body(rec); strings.Count(lines, "\n") != 2 || !strings.Contains(lines, ":nada ") {
+		t.FailNow()
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/aceeric/imgpull/pkg/imgpull"
 	"github.com/aceeric/ociregistry/impl/auth"
@@ -49,15 +50,52 @@ type ServerTlsCfg struct {
 	ClientAuth string `yaml:"clientAuth"`
 }
 
+// ServerAuthCfg configures authentication of (downstream) clients. Valid values for Mode:
+// "none" (or empty), "basic", and "token". In basic mode the /v2 and /cmd endpoints require
+// basic auth credentials for one of the users. In token mode the /v2/auth endpoint issues
+// signed bearer tokens to the users and the other /v2 endpoints require a token. The users
+// are the configured users plus the users in the Htpasswd file, if specified.
+type ServerAuthCfg struct {
+	Mode     string          `yaml:"mode"`
+	Users    []ServerUserCfg `yaml:"users"`
+	Htpasswd string          `yaml:"htpasswd"`
+	Token    ServerTokenCfg  `yaml:"token"`
+}
+
+// ServerUserCfg is a user that can authenticate to the server. The password is a bcrypt
+// hash, e.g. from 'htpasswd -nB'.
+type ServerUserCfg struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+}
+
+// ServerTokenCfg configures the bearer tokens issued by the server. SigningKey is a PEM
+// file with an RSA or ECDSA P-256 private key. Realm is the token endpoint URL advertised
+// to clients, which defaults to /v2/auth on the host that the client connected to. Expiry
+// is a duration like "5m".
+type ServerTokenCfg struct {
+	SigningKey string `yaml:"signingKey"`
+	Issuer     string `yaml:"issuer"`
+	Service    string `yaml:"service"`
+	Realm      string `yaml:"realm"`
+	Expiry     string `yaml:"expiry"`
+}
+
 // RegistryConfig combines authCfg and tlsCfg and configures the pull client
-// for access to one upstream registry
+// for access to one upstream registry. Platforms overrides the global platforms
+// for the registry. Mirrors are the endpoints to pull the registry from, in order,
+// and MirrorCooldown is how long a failed mirror is skipped for.
 type RegistryConfig struct {
-	Name        string             `yaml:"name"`
-	Description string             `yaml:"description"`
-	Auth        authCfg            `yaml:"auth"`
-	Tls         tlsCfg             `yaml:"tls"`
-	Scheme      string             `yaml:"scheme"`
-	Opts        imgpull.PullerOpts `yaml:"opts,omitempty"`
+	Name           string             `yaml:"name"`
+	Description    string             `yaml:"description"`
+	Auth           authCfg            `yaml:"auth"`
+	Tls            tlsCfg             `yaml:"tls"`
+	Scheme         string             `yaml:"scheme"`
+	Platforms      []string           `yaml:"platforms"`
+	Mirrors        []string           `yaml:"mirrors"`
+	MirrorCooldown string             `yaml:"mirrorCooldown"`
+	MaxPulls       int                `yaml:"maxPulls"`
+	Opts           imgpull.PullerOpts `yaml:"opts,omitempty"`
 }
 
 // PruneConfig configures the prune behavior
@@ -78,6 +116,99 @@ type ListConfig struct {
 	ShortDigest bool   `yaml:"shortDigest"`
 }
 
+// HostedConfig configures a local namespace that clients can push images to. Images in the
+// hosted namespace are stored alongside cached images but are never pulled from an upstream.
+// UploadTtl is how long an upload can be idle, and how long an uploaded blob can go without a
+// pushed manifest referencing it, before it is removed. It is a Go duration like "24h".
+type HostedConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Namespace string `yaml:"namespace"`
+	UploadTtl string `yaml:"uploadTtl"`
+}
+
+// DeleteConfig configures the DELETE endpoints of the distribution API. Deletes are only
+// allowed for the listed clients. A client is a client certificate common name (which requires
+// mTLS) or an IP address or CIDR that the client connects from.
+type DeleteConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Clients []string `yaml:"clients"`
+}
+
+// UpstreamConfig restricts the upstreams that the server pulls from on a cache miss. Allow and
+// Deny are glob patterns matched against the repository including the upstream, e.g.
+// "docker.io/library/hello-world". Deny takes precedence over Allow. If Allow is empty then
+// any repository that Deny doesn't match is allowed.
+type UpstreamConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// RevalidateConfig configures stale-while-revalidate for manifests pulled by tag. A cached tag
+// is served from cache and the upstream is checked for a new digest in the background once the
+// tag's ttl has passed - see 'TagTtlFor'. Interval is the ttl of tags that no tag rule matches,
+// and is a Go duration like "5m".
+type RevalidateConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Interval string `yaml:"interval"`
+}
+
+// NegativeConfig configures negative caching: remembering the manifest urls that an upstream
+// answered with a 404, 401, or 403 so they are not requested from the upstream again for Ttl,
+// which is a Go duration like "1m". At most MaxEntries urls are remembered.
+type NegativeConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Ttl        string `yaml:"ttl"`
+	MaxEntries int    `yaml:"maxEntries"`
+}
+
+// BreakerConfig configures the per-registry circuit breaker. After Failures consecutive failed
+// pulls from a registry the breaker opens and pulls from the registry fail fast for OpenFor, which
+// is a Go duration like "30s". Then one pull is let through to probe the registry.
+type BreakerConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Failures int    `yaml:"failures"`
+	OpenFor  string `yaml:"openFor"`
+}
+
+// PullLimits limits how many manifest pulls and blob fetches run against the upstreams at once.
+// Global is the limit across all registries and PerRegistry is the limit for each registry, which
+// the 'maxPulls' setting of a registry overrides. Zero means no limit.
+type PullLimits struct {
+	Global      int `yaml:"global"`
+	PerRegistry int `yaml:"perRegistry"`
+}
+
+// RateLimitConfig configures holding back pulls from an upstream that is running out of pull
+// quota, as reported by RateLimit-Limit and RateLimit-Remaining response headers, or that answered
+// with a 429. Pulls are held back when no more than MinRemaining pulls are left, for the duration
+// in the Retry-After header of a 429 if there is one, else for Backoff - a Go duration like "1m" -
+// which doubles each time the upstream is still short of quota when pulls resume.
+type RateLimitConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	MinRemaining int    `yaml:"minRemaining"`
+	Backoff      string `yaml:"backoff"`
+}
+
+// PolicyConfig configures which clients can pull which repositories, and which clients can call
+// the /cmd endpoints. Clients are identified by basic auth user, token subject, or client
+// certificate common name. Cmd lists the identities allowed to call the /cmd endpoints.
+type PolicyConfig struct {
+	Enabled bool         `yaml:"enabled"`
+	Rules   []PolicyRule `yaml:"rules"`
+	Cmd     []string     `yaml:"cmd"`
+}
+
+// PolicyRule allows or denies repositories to the matching identities. Identities, Allow, Push,
+// and Deny are glob patterns. Allow, Push, and Deny are matched against the repository including
+// the upstream, e.g. "docker.io/library/hello-world". Allow is for pulls and Push is for pushes
+// and deletes. Deny is for both.
+type PolicyRule struct {
+	Identities []string `yaml:"identities"`
+	Allow      []string `yaml:"allow"`
+	Push       []string `yaml:"push"`
+	Deny       []string `yaml:"deny"`
+}
+
 // Configuration represents the totality of configuration knobs and dials for the server.
 type Configuration struct {
 	LogLevel         string           `yaml:"logLevel"`
@@ -95,6 +226,9 @@ type Configuration struct {
 	Metrics          int              `yaml:"metrics"`
 	AlwaysPullLatest bool             `yaml:"alwaysPullLatest"`
 	AirGapped        bool             `yaml:"airGapped"`
+	LazyBlobs        bool             `yaml:"lazyBlobs"`
+	CancelPulls      bool             `yaml:"cancelPulls"`
+	Platforms        []string         `yaml:"platforms"`
 	HelloWorld       bool             `yaml:"helloWorld"`
 	DefaultNs        string           `yaml:"defaultNs"`
 	Host             string           `yaml:"host"`
@@ -102,6 +236,19 @@ type Configuration struct {
 	PruneConfig      PruneConfig      `yaml:"pruneConfig"`
 	ListConfig       ListConfig       `yaml:"listConfig"`
 	ServerTlsCfg     ServerTlsCfg     `yaml:"serverTlsConfig"`
+	ServerAuthCfg    ServerAuthCfg    `yaml:"serverAuth"`
+	HostedConfig     HostedConfig     `yaml:"hostedConfig"`
+	DeleteConfig     DeleteConfig     `yaml:"deleteConfig"`
+	PolicyConfig     PolicyConfig     `yaml:"policy"`
+	UpstreamConfig   UpstreamConfig   `yaml:"upstreamConfig"`
+	RevalidateConfig RevalidateConfig `yaml:"revalidateConfig"`
+	TagRules         []TagRule        `yaml:"tagRules"`
+	NegativeCache    NegativeConfig   `yaml:"negativeCache"`
+	BreakerConfig    BreakerConfig    `yaml:"breakerConfig"`
+	PullLimits       PullLimits       `yaml:"pullLimits"`
+	RateLimitConfig  RateLimitConfig  `yaml:"rateLimitConfig"`
+	// tagRules are the parsed TagRules
+	tagRules []tagRule
 }
 
 // FromCmdLine has a flag for every command-line option. The parsing code
@@ -220,6 +367,22 @@ func SetAirGapped(newVal bool) {
 	config.AirGapped = newVal
 }
 
+func GetLazyBlobs() bool {
+	return config.LazyBlobs
+}
+
+func SetLazyBlobs(newVal bool) {
+	config.LazyBlobs = newVal
+}
+
+func GetCancelPulls() bool {
+	return config.CancelPulls
+}
+
+func GetPlatforms() []string {
+	return config.Platforms
+}
+
 func GetHelloWorld() bool {
 	return config.HelloWorld
 }
@@ -244,10 +407,46 @@ func GetListConfig() ListConfig {
 	return config.ListConfig
 }
 
+func GetHostedConfig() HostedConfig {
+	return config.HostedConfig
+}
+
+func GetDeleteConfig() DeleteConfig {
+	return config.DeleteConfig
+}
+
+func GetPolicyConfig() PolicyConfig {
+	return config.PolicyConfig
+}
+
+func GetUpstreamConfig() UpstreamConfig {
+	return config.UpstreamConfig
+}
+
+func GetRevalidateConfig() RevalidateConfig {
+	return config.RevalidateConfig
+}
+
+func GetNegativeCache() NegativeConfig {
+	return config.NegativeCache
+}
+
+func GetBreakerConfig() BreakerConfig {
+	return config.BreakerConfig
+}
+
+func GetRateLimitConfig() RateLimitConfig {
+	return config.RateLimitConfig
+}
+
 func GetServerTlsCfg() ServerTlsCfg {
 	return config.ServerTlsCfg
 }
 
+func GetServerAuthCfg() ServerAuthCfg {
+	return config.ServerAuthCfg
+}
+
 // Load loads the passed configuration file into the global configuration struct
 func Load(configFile string) error {
 	if _, err := os.Stat(configFile); err != nil {
@@ -276,9 +475,13 @@ func SetConfigFromStr(configBytes []byte) error {
 	var cfg Configuration
 	if err := yaml.Unmarshal(configBytes, &cfg); err != nil {
 		return err
-	} else {
-		config = cfg
 	}
+	tagRules, err := parseTagRules(cfg.TagRules)
+	if err != nil {
+		return err
+	}
+	cfg.tagRules = tagRules
+	config = cfg
 	return nil
 }
 
@@ -306,7 +509,7 @@ func ConfigFor(registry string) (imgpull.PullerOpts, error) {
 		}
 	}
 
-	if found == (RegistryConfig{}) {
+	if found.Name == "" {
 		return opts, nil
 	}
 
@@ -376,6 +579,49 @@ func ConfigFor(registry string) (imgpull.PullerOpts, error) {
 	return opts, nil
 }
 
+// PlatformsFor returns the platforms to cache from manifest lists pulled from the passed
+// registry: the platforms from the registry configuration entry if it has any, otherwise
+// the global platforms. A platform is 'os/arch' or 'os/arch/variant', or 'all'.
+func PlatformsFor(registry string) []string {
+	for _, reg := range config.Registries {
+		if reg.Name == registry && len(reg.Platforms) != 0 {
+			return reg.Platforms
+		}
+	}
+	return config.Platforms
+}
+
+// defaultMirrorCooldown is how long a failed mirror is skipped for if the registry configuration
+// doesn't specify a valid cooldown.
+const defaultMirrorCooldown = time.Minute
+
+// MirrorsFor returns the mirrors configured for the passed registry, and how long a failed
+// mirror is skipped for. If the registry has no mirrors then the returned slice is empty.
+func MirrorsFor(registry string) ([]string, time.Duration) {
+	for _, reg := range config.Registries {
+		if reg.Name == registry {
+			cooldown, err := time.ParseDuration(reg.MirrorCooldown)
+			if err != nil || cooldown < 0 {
+				cooldown = defaultMirrorCooldown
+			}
+			return reg.Mirrors, cooldown
+		}
+	}
+	return nil, defaultMirrorCooldown
+}
+
+// PullLimitsFor returns the limit on concurrent pulls from the passed registry, and the global
+// limit on concurrent pulls. Zero means no limit.
+func PullLimitsFor(registry string) (int, int) {
+	limit := config.PullLimits.PerRegistry
+	for _, reg := range config.Registries {
+		if reg.Name == registry && reg.MaxPulls != 0 {
+			limit = reg.MaxPulls
+		}
+	}
+	return limit, config.PullLimits.Global
+}
+
 // UpstreamAuthProviders is an iterator over the registries configuration that returns
 // the TokenAuth struct for all the registries that have an auth token provider configured.
 func UpstreamAuthProviders(yield func(TokenAuth) bool) {
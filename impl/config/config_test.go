@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/aceeric/imgpull/pkg/imgpull"
 	"github.com/aceeric/ociregistry/mock"
@@ -206,6 +207,85 @@ func TestTokenAuth(t *testing.T) {
 	}
 }
 
+var testPlatforms = `
+---
+platforms:
+  - linux/amd64
+  - linux/arm64
+registries:
+  - name: docker.io
+    platforms:
+      - all
+  - name: quay.io
+    scheme: http
+`
+
+// Test the registry platforms overriding the global platforms
+func TestPlatformsFor(t *testing.T) {
+	if err := SetConfigFromStr([]byte(testPlatforms)); err != nil {
+		t.FailNow()
+	}
+	for registry, expect := range map[string][]string{
+		"docker.io": {"all"},
+		"quay.io":   {"linux/amd64", "linux/arm64"},
+		"ghcr.io":   {"linux/amd64", "linux/arm64"},
+	} {
+		if !reflect.DeepEqual(PlatformsFor(registry), expect) {
+			t.Errorf("%s: expected %v, got %v", registry, expect, PlatformsFor(registry))
+		}
+	}
+}
+
+var testTagRules = `
+alwaysPullLatest: true
+tagRules:
+- pattern: ".*:(stable|main)$"
+  ttl: 1h
+- registry: ghcr.io
+  ttl: 24h
+- pattern: "^quay.io/.*:nightly$"
+  registry: quay.io
+  ttl: 0s
+`
+
+func TestTagTtlFor(t *testing.T) {
+	if err := SetConfigFromStr([]byte(testTagRules)); err != nil {
+		t.FailNow()
+	}
+	for _, tst := range []struct {
+		registry string
+		url      string
+		ttl      time.Duration
+		mutable  bool
+	}{
+		{"docker.io", "docker.io/library/nginx:stable", time.Hour, true},
+		{"ghcr.io", "ghcr.io/foo/bar:main", time.Hour, true},
+		{"ghcr.io", "ghcr.io/foo/bar:v1.2.3", 24 * time.Hour, true},
+		{"quay.io", "quay.io/foo/bar:nightly", 0, true},
+		{"docker.io", "docker.io/library/nginx:latest", 0, true},
+		{"docker.io", "docker.io/library/nginx:1.27", 0, false},
+	} {
+		if ttl, mutable := TagTtlFor(tst.registry, tst.url); ttl != tst.ttl || mutable != tst.mutable {
+			t.Errorf("%s: expected %s %t, got %s %t", tst.url, tst.ttl, tst.mutable, ttl, mutable)
+		}
+	}
+	if err := SetConfigFromStr([]byte("revalidateConfig:\n  enabled: true\n")); err != nil {
+		t.FailNow()
+	}
+	if ttl, mutable := TagTtlFor("docker.io", "docker.io/library/nginx:1.27"); ttl != defaultRevalidateInterval || !mutable {
+		t.Fail()
+	}
+	for _, cfg := range []string{
+		"tagRules:\n- pattern: \"(\"\n  ttl: 1h\n",
+		"tagRules:\n- registry: ghcr.io\n",
+		"tagRules:\n- registry: ghcr.io\n  ttl: -1h\n",
+	} {
+		if err := SetConfigFromStr([]byte(cfg)); err == nil {
+			t.Errorf("expected error for %q", cfg)
+		}
+	}
+}
+
 // test getters
 func TestGetters(t *testing.T) {
 	ac := authCfg{
@@ -340,7 +420,7 @@ func TestGetters(t *testing.T) {
 	if GetHost() != tHost {
 		t.FailNow()
 	}
-	if GetRegistries()[0] != rc[0] {
+	if !reflect.DeepEqual(GetRegistries()[0], rc[0]) {
 		t.FailNow()
 	}
 	if GetPruneConfig() != pc {
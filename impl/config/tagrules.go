@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TagRule sets how long a cached tag is trusted before the upstream is checked for a new digest.
+// Pattern is a regular expression matched against the tag url, e.g. "docker.io/library/nginx:stable",
+// and Registry is matched exactly against the upstream, e.g. "ghcr.io". If both are set then both
+// must match, and if neither is set then the rule matches every tag. Ttl is a Go duration like
+// "1h" and zero means the upstream is checked on every pull.
+type TagRule struct {
+	Pattern  string `yaml:"pattern"`
+	Registry string `yaml:"registry"`
+	Ttl      string `yaml:"ttl"`
+}
+
+// tagRule is a parsed TagRule.
+type tagRule struct {
+	re       *regexp.Regexp
+	registry string
+	ttl      time.Duration
+}
+
+// defaultRevalidateInterval is the ttl of tags that no tag rule matches when revalidation is
+// enabled, if the revalidate interval is not configured or can't be parsed.
+const defaultRevalidateInterval = 5 * time.Minute
+
+// TagTtlFor returns how long the passed tag url from the passed registry is trusted before the
+// upstream is checked for a new digest, and true. The ttl is from the first tag rule that matches.
+// If no rule matches, then a 'latest' tag has a ttl of zero if 'alwaysPullLatest' is configured,
+// and any other tag has the revalidate interval if revalidation is enabled. Otherwise false is
+// returned, meaning the tag is trusted for as long as it is cached.
+func TagTtlFor(registry string, url string) (time.Duration, bool) {
+	for _, rule := range config.tagRules {
+		if (rule.registry == "" || rule.registry == registry) && (rule.re == nil || rule.re.MatchString(url)) {
+			return rule.ttl, true
+		}
+	}
+	if config.AlwaysPullLatest && strings.HasSuffix(url, ":latest") {
+		return 0, true
+	}
+	if config.RevalidateConfig.Enabled {
+		interval, err := time.ParseDuration(config.RevalidateConfig.Interval)
+		if err != nil || interval <= 0 {
+			interval = defaultRevalidateInterval
+		}
+		return interval, true
+	}
+	return 0, false
+}
+
+// parseTagRules parses the passed tag rules, returning an error that identifies the rule if a
+// pattern is not a valid regular expression or a ttl is missing or not a valid duration.
+func parseTagRules(rules []TagRule) ([]tagRule, error) {
+	var parsed []tagRule
+	for i, rule := range rules {
+		tr := tagRule{registry: rule.Registry}
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("tag rule %d: invalid pattern %q: %s", i+1, rule.Pattern, err)
+			}
+			tr.re = re
+		}
+		ttl, err := time.ParseDuration(rule.Ttl)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("tag rule %d: invalid ttl %q", i+1, rule.Ttl)
+		}
+		tr.ttl = ttl
+		parsed = append(parsed, tr)
+	}
+	return parsed, nil
+}
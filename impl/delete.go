@@ -0,0 +1,117 @@
+package impl
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/helpers"
+	"github.com/aceeric/ociregistry/impl/metrics"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DELETE /v2/.../manifests/reference. Deletes a manifest using the same logic as the pruner so
+// the blob ref counts remain consistent: the blobs of an image manifest are removed when no other
+// manifest references them, and any referrers of the manifest are removed with it. The reference
+// can be a tag or a digest. The namespace is the optional ns query param, as for a pull.
+func (r *OciRegistry) handleV2DeleteManifestsReference(ctx echo.Context, reference string, namespace *string, repoSegments ...string) error {
+	metrics.IncV2ApiEndpointHits()
+	if re := r.canDelete(ctx); re != nil {
+		return re.Send(ctx)
+	}
+	pr, err := pullrequest.NewPullRequest(r.x_registry_hdr(ctx), namespace, r.defaultNs, reference, repoSegments...)
+	if err != nil {
+		return NewRegistryError(http.StatusBadRequest, NameInvalid, err.Error()).Send(ctx)
+	}
+	if re := r.canPush(ctx, pr.Remote+"/"+pr.Repository); re != nil {
+		return re.Send(ctx)
+	}
+	if !cache.DeleteManifest(pr, r.imagePath) {
+		return NewRegistryError(http.StatusNotFound, ManifestUnknown, "manifest unknown").WithDetail(pr.Url()).Send(ctx)
+	}
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// DELETE /v2/.../blobs/digest. Only a blob that no cached manifest references can be deleted.
+// Referenced blobs are deleted by deleting the manifests that reference them.
+func (r *OciRegistry) handleV2DeleteBlobsDigest(ctx echo.Context, digest string, repoSegments ...string) error {
+	metrics.IncV2ApiEndpointHits()
+	if re := r.canDelete(ctx); re != nil {
+		return re.Send(ctx)
+	}
+	if re := r.canPush(ctx, r.repositoryFor(ctx, repoSegments)); re != nil {
+		return re.Send(ctx)
+	}
+	d := helpers.GetDigestFrom(digest)
+	if d == "" {
+		return NewRegistryError(http.StatusBadRequest, DigestInvalid, "invalid digest").WithDetail(digest).Send(ctx)
+	}
+	exists, err := cache.DeleteBlob(d, r.imagePath)
+	if errors.Is(err, cache.ErrBlobReferenced) {
+		return NewRegistryError(http.StatusMethodNotAllowed, Unsupported, err.Error()).WithDetail(digest).Send(ctx)
+	} else if err != nil {
+		return NewRegistryError(http.StatusInternalServerError, Unknown, err.Error()).Send(ctx)
+	} else if !exists {
+		return NewRegistryError(http.StatusNotFound, BlobUnknown, "blob unknown to registry").WithDetail(digest).Send(ctx)
+	}
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// canDelete returns nil if deletes are enabled and the client is allowed to delete, else
+// an error to send to the client.
+func (r *OciRegistry) canDelete(ctx echo.Context) *RegistryError {
+	if !r.deletes.Enabled {
+		return NewRegistryError(http.StatusMethodNotAllowed, Unsupported, "deletes are not enabled")
+	}
+	if !authorizedClient(ctx, r.deletes.Clients) {
+		log.Warnf("delete %s from unauthorized client %s", ctx.Request().URL.Path, ctx.Request().RemoteAddr)
+		return NewRegistryError(http.StatusForbidden, Denied, "the client is not allowed to delete")
+	}
+	return nil
+}
+
+// authorizedClient returns true if the client matches one of the passed clients. A client is
+// matched by the common name of a verified client certificate, or by the IP address that the
+// client connected from, which can be matched by an IP address or a CIDR. The X-Forwarded-For
+// header is not considered because the client can set it to anything.
+func authorizedClient(ctx echo.Context, clients []string) bool {
+	if cn := clientCN(ctx); cn != "" && slices.Contains(clients, cn) {
+		return true
+	}
+	req := ctx.Request()
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, client := range clients {
+		if strings.Contains(client, "/") {
+			if _, cidr, err := net.ParseCIDR(client); err == nil && cidr.Contains(ip) {
+				return true
+			}
+		} else if cip := net.ParseIP(client); cip != nil && cip.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientCN returns the common name of the verified client certificate for the request, or the
+// empty string if the client didn't present a certificate or it was not verified.
+func clientCN(ctx echo.Context) string {
+	req := ctx.Request()
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(req.TLS.VerifiedChains[0]) > 0 {
+		return req.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package impl
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/globals"
+	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/mock"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Pulls an image manifest and then deletes it, checking that deletes are refused when disabled
+// or for an unauthorized client, and that the blobs are removed along with the manifest.
+func TestDeleteManifest(t *testing.T) {
+	cache.ResetCache()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	defer server.Close()
+	cfg := fmt.Sprintf(serverCfg, td, 1000, false, url)
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.FailNow()
+	}
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	digest := "sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57"
+	blobDigest := "d2c94e258dcb3c5ac2798d32e1249e42ef01cba4841c2234249495f87264ac5a"
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	r.handleV2ManifestsReference(ctx, digest, &url, http.MethodGet, "hello-world")
+	if ctx.Response().Status != http.StatusOK {
+		t.FailNow()
+	}
+	del := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		// httptest requests come from 192.0.2.1
+		ctx := e.NewContext(httptest.NewRequest(http.MethodDelete, "/", nil), rec)
+		r.handleV2DeleteManifestsReference(ctx, digest, &url, "hello-world")
+		return rec
+	}
+	if rec := del(); rec.Code != http.StatusMethodNotAllowed {
+		t.FailNow()
+	}
+	r.deletes = config.DeleteConfig{Enabled: true, Clients: []string{"10.0.0.0/8"}}
+	if rec := del(); rec.Code != http.StatusForbidden || errorBody(t, rec).Code != Denied {
+		t.FailNow()
+	}
+	r.deletes.Clients = append(r.deletes.Clients, "192.0.2.0/24")
+	if rec := del(); rec.Code != http.StatusAccepted {
+		t.FailNow()
+	}
+	if cache.GetBlob(blobDigest) != 0 {
+		t.FailNow()
+	}
+	if _, err := os.Stat(filepath.Join(td, globals.BlobPath, blobDigest)); !os.IsNotExist(err) {
+		t.FailNow()
+	}
+	if rec := del(); rec.Code != http.StatusNotFound || errorBody(t, rec).Code != ManifestUnknown {
+		t.FailNow()
+	}
+}
+
+// Only blobs that are not referenced by a cached manifest can be deleted.
+func TestDeleteBlob(t *testing.T) {
+	cache.ResetCache()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	defer server.Close()
+	cfg := fmt.Sprintf(serverCfg, td, 1000, false, url)
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.FailNow()
+	}
+	r := NewOciRegistry(nil)
+	r.deletes = config.DeleteConfig{Enabled: true, Clients: []string{"192.0.2.1"}}
+	e := echo.New()
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	r.handleV2ManifestsReference(ctx, "sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57", &url, http.MethodGet, "hello-world")
+	if ctx.Response().Status != http.StatusOK {
+		t.FailNow()
+	}
+	orphan := "0000000000000000000000000000000000000000000000000000000000000000"
+	os.WriteFile(filepath.Join(td, globals.BlobPath, orphan), []byte("orphan"), 0644)
+	for _, tst := range []struct {
+		digest string
+		status int
+	}{
+		{"sha256:d2c94e258dcb3c5ac2798d32e1249e42ef01cba4841c2234249495f87264ac5a", http.StatusMethodNotAllowed},
+		{"sha256:" + orphan, http.StatusAccepted},
+		{"sha256:" + orphan, http.StatusNotFound},
+		{"sha256:foo", http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodDelete, "/", nil), rec)
+		r.handleV2DeleteBlobsDigest(ctx, tst.digest, "hello-world")
+		if rec.Code != tst.status {
+			t.Errorf("%s: expected %d, got %d", tst.digest, tst.status, rec.Code)
+		}
+	}
+}
+
+func TestAuthorizedClient(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	req.RemoteAddr = "10.1.2.3:5000"
+	ctx := e.NewContext(req, httptest.NewRecorder())
+	for _, tst := range []struct {
+		clients []string
+		ok      bool
+	}{
+		{[]string{}, false},
+		{[]string{"10.1.2.3"}, true},
+		{[]string{"10.1.2.4"}, false},
+		{[]string{"10.1.0.0/16"}, true},
+		{[]string{"10.2.0.0/16"}, false},
+		{[]string{"ci-runner"}, false},
+	} {
+		if authorizedClient(ctx, tst.clients) != tst.ok {
+			t.Errorf("clients %v: expected %t", tst.clients, tst.ok)
+		}
+	}
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "ci-runner"}}}},
+	}
+	if !authorizedClient(ctx, []string{"ci-runner"}) {
+		t.Fail()
+	}
+}
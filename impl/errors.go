@@ -0,0 +1,163 @@
+package impl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/upstream"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrorCode is an error code from the OCI Distribution Spec.
+type ErrorCode string
+
+// Error codes from the spec, plus UNKNOWN for server errors that the spec doesn't cover.
+const (
+	BlobUnknown         ErrorCode = "BLOB_UNKNOWN"
+	BlobUploadInvalid   ErrorCode = "BLOB_UPLOAD_INVALID"
+	BlobUploadUnknown   ErrorCode = "BLOB_UPLOAD_UNKNOWN"
+	DigestInvalid       ErrorCode = "DIGEST_INVALID"
+	ManifestBlobUnknown ErrorCode = "MANIFEST_BLOB_UNKNOWN"
+	ManifestInvalid     ErrorCode = "MANIFEST_INVALID"
+	ManifestUnknown     ErrorCode = "MANIFEST_UNKNOWN"
+	NameInvalid         ErrorCode = "NAME_INVALID"
+	NameUnknown         ErrorCode = "NAME_UNKNOWN"
+	PaginationInvalid   ErrorCode = "PAGINATION_NUMBER_INVALID"
+	SizeInvalid         ErrorCode = "SIZE_INVALID"
+	Unauthorized        ErrorCode = "UNAUTHORIZED"
+	Denied              ErrorCode = "DENIED"
+	Unsupported         ErrorCode = "UNSUPPORTED"
+	TooManyRequests     ErrorCode = "TOOMANYREQUESTS"
+	Unknown             ErrorCode = "UNKNOWN"
+)
+
+// RegistryError is an error that is rendered to the client as an OCI Distribution Spec
+// error body like:
+//
+//	{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown","detail":...}]}
+//
+// The Status field is the HTTP status and is not part of the body. If RetryAfter is non-zero
+// then it is sent to the client in a Retry-After header.
+type RegistryError struct {
+	Status     int           `json:"-"`
+	Code       ErrorCode     `json:"code"`
+	Message    string        `json:"message"`
+	Detail     any           `json:"detail"`
+	RetryAfter time.Duration `json:"-"`
+}
+
+// registryErrors is the body of an error response.
+type registryErrors struct {
+	Errors []RegistryError `json:"errors"`
+}
+
+// NewRegistryError returns a RegistryError with the passed HTTP status, code, and message.
+func NewRegistryError(status int, code ErrorCode, message string) *RegistryError {
+	return &RegistryError{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+// Error implements the error interface.
+func (e *RegistryError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// WithDetail sets the detail field of the receiver and returns the receiver.
+func (e *RegistryError) WithDetail(detail any) *RegistryError {
+	e.Detail = detail
+	return e
+}
+
+// Send writes the receiver to the client. A HEAD request gets the status with no body.
+func (e *RegistryError) Send(ctx echo.Context) error {
+	if e.RetryAfter > 0 {
+		// round up so the client doesn't come back a moment too soon
+		ctx.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+	}
+	if ctx.Request().Method == http.MethodHead {
+		return ctx.NoContent(e.Status)
+	}
+	return ctx.JSON(e.Status, registryErrors{Errors: []RegistryError{*e}})
+}
+
+// fromUpstreamError returns a RegistryError for an error that occurred getting something from
+// an upstream registry. If the upstream answered with 404, 401, 403 or 429 then that status
+// is passed through to the client - with the Retry-After of a 429 - and the passed code is used
+// for the 404 case since it depends on what was requested. Pulls that are held back because the
+// upstream is out of pull quota are also a 429. If the circuit breaker for the upstream is open
+// then the status is 503. Anything else is a 500.
+func fromUpstreamError(err error, notFound ErrorCode) *RegistryError {
+	if errors.Is(err, upstream.ErrCircuitOpen) {
+		return NewRegistryError(http.StatusServiceUnavailable, Unknown, err.Error())
+	}
+	var ue *upstream.Error
+	if errors.As(err, &ue) {
+		switch ue.Status {
+		case http.StatusNotFound:
+			return NewRegistryError(http.StatusNotFound, notFound, err.Error())
+		case http.StatusUnauthorized:
+			return NewRegistryError(http.StatusUnauthorized, Unauthorized, err.Error())
+		case http.StatusForbidden:
+			return NewRegistryError(http.StatusForbidden, Denied, err.Error())
+		case http.StatusTooManyRequests:
+			re := NewRegistryError(http.StatusTooManyRequests, TooManyRequests, err.Error())
+			re.RetryAfter = ue.RetryAfter
+			return re
+		}
+	}
+	return NewRegistryError(http.StatusInternalServerError, Unknown, err.Error())
+}
+
+// unsupported is the response for endpoints that the server does not implement.
+func unsupported(ctx echo.Context) error {
+	return NewRegistryError(http.StatusMethodNotAllowed, Unsupported, "the operation is unsupported").Send(ctx)
+}
+
+// HTTPErrorHandler is an Echo error handler that renders errors raised by Echo itself - or by
+// middleware - for the /v2 endpoints as OCI Distribution Spec error bodies. E.g. a request for
+// an unknown route or a request that fails OpenAPI validation. Other endpoints get the Echo
+// default error handling.
+func HTTPErrorHandler(err error, ctx echo.Context) {
+	if ctx.Response().Committed {
+		return
+	}
+	if !strings.HasPrefix(ctx.Request().URL.Path, "/v2") {
+		ctx.Echo().DefaultHTTPErrorHandler(err, ctx)
+		return
+	}
+	var re *RegistryError
+	if errors.As(err, &re) {
+		re.Send(ctx)
+		return
+	}
+	status := http.StatusInternalServerError
+	message := err.Error()
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		status = he.Code
+		message = fmt.Sprint(he.Message)
+	}
+	code := Unknown
+	switch {
+	case status == http.StatusUnauthorized:
+		code = Unauthorized
+	case status == http.StatusForbidden:
+		code = Denied
+	case status == http.StatusNotFound:
+		code = NameUnknown
+	case status == http.StatusTooManyRequests:
+		code = TooManyRequests
+	case status < http.StatusInternalServerError:
+		code = Unsupported
+	}
+	NewRegistryError(status, code, message).Send(ctx)
+}
@@ -0,0 +1,160 @@
+package impl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/impl/upstream"
+	"github.com/aceeric/ociregistry/mock"
+
+	"github.com/labstack/echo/v4"
+)
+
+// errorBody parses an error body from the passed recorder and returns the first error
+// in the body.
+func errorBody(t *testing.T, rec *httptest.ResponseRecorder) RegistryError {
+	body := registryErrors{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || len(body.Errors) != 1 {
+		t.FailNow()
+	}
+	return body.Errors[0]
+}
+
+func TestFromUpstreamError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   ErrorCode
+	}{
+		{upstream.NewError(404, "not found"), 404, ManifestUnknown},
+		{upstream.NewError(401, "unauthorized"), 401, Unauthorized},
+		{fmt.Errorf("wrapped: %w", upstream.NewError(403, "denied")), 403, Denied},
+		{upstream.NewError(429, "slow down"), 429, TooManyRequests},
+		{upstream.NewError(502, "bad gateway"), 500, Unknown},
+		{errors.New("connection refused"), 500, Unknown},
+	}
+	for _, test := range tests {
+		re := fromUpstreamError(test.err, ManifestUnknown)
+		if re.Status != test.status || re.Code != test.code {
+			t.Fail()
+		}
+	}
+}
+
+// Checks that the v2 handlers return error bodies, and that a 404 from the upstream
+// is passed through to the client.
+func TestErrorBodies(t *testing.T) {
+	cache.ResetCache()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fail()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	cfg := fmt.Sprintf(serverCfg, td, 1000, false, url)
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.Fail()
+	}
+	defer server.Close()
+
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	r.handleV2ManifestsReference(ctx, "no-such-tag", &url, http.MethodGet, "hello-world")
+	if rec.Code != http.StatusNotFound || errorBody(t, rec).Code != ManifestUnknown {
+		t.Fail()
+	}
+	rec = httptest.NewRecorder()
+	ctx = e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	r.handleV2BlobsDigest(ctx, "sha256:d2c94e258dcb3c5ac2798d32e1249e42ef01cba4841c2234249495f87264ac5a", "hello-world")
+	if rec.Code != http.StatusNotFound || errorBody(t, rec).Code != BlobUnknown {
+		t.Fail()
+	}
+	rec = httptest.NewRecorder()
+	ctx = e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	r.handleV2ManifestsReference(ctx, "latest", nil, http.MethodGet, "hello-world")
+	if rec.Code != http.StatusBadRequest || errorBody(t, rec).Code != NameInvalid {
+		t.Fail()
+	}
+	rec = httptest.NewRecorder()
+	ctx = e.NewContext(httptest.NewRequest(http.MethodHead, "/", nil), rec)
+	r.handleV2HeadBlobsDigest(ctx, "sha256:d2c94e258dcb3c5ac2798d32e1249e42ef01cba4841c2234249495f87264ac5a", "hello-world")
+	if rec.Code != http.StatusNotFound || rec.Body.Len() != 0 {
+		t.Fail()
+	}
+}
+
+func TestHTTPErrorHandler(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/no/such/route/anywhere/at/all", nil))
+	if rec.Code != http.StatusNotFound || errorBody(t, rec).Code != NameUnknown {
+		t.Fail()
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cmd/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fail()
+	}
+	body := registryErrors{}
+	if json.Unmarshal(rec.Body.Bytes(), &body) == nil && len(body.Errors) != 0 {
+		t.Fail()
+	}
+}
+
+// Checks that an upstream that answers with a 429 gets the client a TOOMANYREQUESTS with the
+// Retry-After of the upstream, and that pulls are then held back without going to the upstream.
+func TestRateLimited(t *testing.T) {
+	cache.ResetCache()
+	defer upstream.ResetRateLimits()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("RateLimit-Limit", "100;w=21600")
+		w.Header().Set("RateLimit-Remaining", "0;w=21600")
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	url := strings.TrimPrefix(server.URL, "http://")
+	cfg := fmt.Sprintf(serverCfg, td, 1000, false, url) + "rateLimitConfig:\n  enabled: true\n"
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.FailNow()
+	}
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	for range 2 {
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		r.handleV2ManifestsReference(ctx, "latest", &url, http.MethodGet, "hello-world")
+		if rec.Code != http.StatusTooManyRequests || errorBody(t, rec).Code != TooManyRequests {
+			t.FailNow()
+		}
+		if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "120" && retryAfter != "119" {
+			t.FailNow()
+		}
+	}
+	// only the quota probe of the first pull got to the upstream
+	if hits.Load() != 1 {
+		t.FailNow()
+	}
+}
@@ -11,6 +11,9 @@ const (
 	ImgPath = "img"
 	// BlobPath is the subdirectory under the image cache root where blobs are stored
 	BlobPath = "blobs"
+	// UploadPath is the subdirectory under the image cache root where in-progress blob
+	// uploads to the hosted namespace are stored
+	UploadPath = "uploads"
 	// DateFormat is the datetimestamp format used in ManifestHolder. It has magic numbers
 	// from 'format.go' in package 'time' that support date parsing
 	DateFormat = "2006-01-02T15:04:05"
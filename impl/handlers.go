@@ -1,41 +1,82 @@
 package impl
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aceeric/ociregistry/api/models"
 	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/config"
 	"github.com/aceeric/ociregistry/impl/helpers"
 	"github.com/aceeric/ociregistry/impl/metrics"
 	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/serverauth"
+	"github.com/aceeric/ociregistry/impl/upstream"
 
+	"github.com/aceeric/imgpull/pkg/imgpull/v1oci"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/labstack/echo/v4"
 )
 
-// HEAD or GET /v2/.../manifests/ref
+// HEAD or GET /v2/.../manifests/ref. If the client's Accept header excludes the media type of the
+// cached manifest then the handler negotiates - see 'negotiate'. A cached tag that has a ttl - see
+// 'config.TagTtlFor' - is checked against the upstream once the ttl has passed: in the background
+// if revalidation is enabled (see 'cache.Revalidate') and otherwise by re-pulling it before it is
+// served. A ttl of zero always re-pulls.
 func (r *OciRegistry) handleV2ManifestsReference(ctx echo.Context, reference string, namespace *string, verb string, repoSegments ...string) error {
 	metrics.IncV2ApiEndpointHits()
 	metrics.IncManifestPulls()
 	pr, err := pullrequest.NewPullRequest(r.x_registry_hdr(ctx), namespace, r.defaultNs, reference, repoSegments...)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, err)
+		return NewRegistryError(http.StatusBadRequest, NameInvalid, err.Error()).Send(ctx)
 	}
-	if r.airGapped && !cache.IsCached(pr) {
-		log.Debugf("request for un-cached manifest %q in air-gapped mode - returning 404", pr.Url())
+	if re := r.canPull(ctx, pr.Remote+"/"+pr.Repository); re != nil {
 		metrics.IncApiErrorResults()
-		return ctx.JSON(http.StatusNotFound, "")
+		return re.Send(ctx)
 	}
-	forcePull := r.alwaysPullLatest && pr.Reference == "latest"
-	mh, err := cache.GetManifest(pr, r.imagePath, r.pullTimeout, forcePull)
+	if r.offline(pr) && !cache.IsCached(pr) {
+		log.Debugf("request for un-cached manifest %q in air-gapped mode or hosted namespace - returning 404", pr.Url())
+		metrics.IncApiErrorResults()
+		return NewRegistryError(http.StatusNotFound, ManifestUnknown, "manifest unknown").WithDetail(pr.Url()).Send(ctx)
+	}
+	var ttl time.Duration
+	mutable := false
+	if pr.PullType == pullrequest.ByTag && !r.offline(pr) {
+		ttl, mutable = config.TagTtlFor(pr.Remote, pr.Url())
+	}
+	if !r.offline(pr) && !r.upstreamAllowed(pr) {
+		if !cache.IsCached(pr) {
+			return upstreamDenied(pr).Send(ctx)
+		}
+		mutable = false
+	}
+	revalidate := mutable && ttl != 0 && r.revalidate.Enabled
+	forcePull := mutable && !revalidate && cache.Stale(pr, ttl)
+	mh, err := cache.GetManifest(ctx.Request().Context(), pr, r.imagePath, r.pullTimeout, forcePull)
 	if err != nil {
 		log.Errorf("error getting manifest for %q: %s", pr.Url(), err)
 		metrics.IncApiErrorResults()
-		return ctx.NoContent(http.StatusInternalServerError)
+		return fromUpstreamError(err, ManifestUnknown).Send(ctx)
+	}
+	if revalidate {
+		cache.Revalidate(pr, mh, r.imagePath, r.pullTimeout, ttl)
+	}
+	if accepts := parseAccept(ctx.Request().Header.Values("Accept")); !acceptable(accepts, mh.MediaType()) {
+		var re *RegistryError
+		if mh, re = r.negotiate(ctx.Request().Context(), pr, mh, accepts); re != nil {
+			log.Debugf("manifest negotiation failed for %q: %s", pr.Url(), re.Message)
+			metrics.IncApiErrorResults()
+			return re.Send(ctx)
+		}
 	}
 	ctx.Response().Header().Add("Content-Length", strconv.Itoa(len(mh.Bytes)))
 	ctx.Response().Header().Add("Docker-Content-Digest", "sha256:"+mh.Digest)
@@ -49,34 +90,206 @@ func (r *OciRegistry) handleV2ManifestsReference(ctx echo.Context, reference str
 	}
 }
 
-// GET /v2/.../blobs/digest
+// GET /v2/.../blobs/digest. Range requests are handled by http.ServeContent per RFC 7233: a single
+// range gets a 206 with Content-Range, multiple ranges get a 206 multipart/byteranges response, and
+// a range that can't be satisfied gets a 416. The ETag is the blob digest so that If-Range works
+// when a client resumes an interrupted download. If blobs are pulled lazily and the blob has not
+// been fetched yet then it is streamed to the client as it is fetched from the upstream - see
+// 'streamBlob'. A range request for such a blob waits for the fetch to complete.
 func (r *OciRegistry) handleV2BlobsDigest(ctx echo.Context, digest string, repoSegments ...string) error {
 	metrics.IncV2ApiEndpointHits()
 	metrics.IncBlobPulls()
 	digest = helpers.GetDigestFrom(digest)
-	if refCnt := cache.GetBlob(digest); refCnt <= 0 {
+	if re := r.canPullBlob(ctx, r.repositoryFor(ctx, repoSegments), digest); re != nil {
+		metrics.IncApiErrorResults()
+		return re.Send(ctx)
+	}
+	if !cache.HasBlob(digest) {
 		log.Errorf("blob not in cache for %q, digest %q", strings.Join(repoSegments, "/"), digest)
 		metrics.IncApiErrorResults()
-		return ctx.JSON(http.StatusNotFound, "")
+		return NewRegistryError(http.StatusNotFound, BlobUnknown, "blob unknown to registry").WithDetail(digest).Send(ctx)
 	}
 	fi, err, blob_file := helpers.GetBlob(r.imagePath, digest)
+	if err != nil && !r.airGapped {
+		if ctx.Request().Header.Get("Range") == "" {
+			rc, size, serr := cache.StreamBlob(ctx.Request().Context(), digest, r.imagePath)
+			if serr == nil {
+				defer rc.Close()
+				return streamBlob(ctx, digest, rc, size)
+			} else if !errors.Is(serr, cache.ErrBlobNotPending) {
+				log.Errorf("error streaming blob for %q, digest %q: %s", strings.Join(repoSegments, "/"), digest, serr)
+				metrics.IncApiErrorResults()
+				return fromUpstreamError(serr, BlobUnknown).Send(ctx)
+			}
+		}
+		if ferr := cache.FetchBlob(ctx.Request().Context(), digest, r.imagePath); ferr == nil {
+			fi, err, blob_file = helpers.GetBlob(r.imagePath, digest)
+		} else if !errors.Is(ferr, cache.ErrBlobNotPending) {
+			log.Errorf("error fetching blob for %q, digest %q: %s", strings.Join(repoSegments, "/"), digest, ferr)
+			metrics.IncApiErrorResults()
+			return fromUpstreamError(ferr, BlobUnknown).Send(ctx)
+		}
+	}
 	if err != nil {
 		log.Errorf("blob not on the file system for %q, digest %q", strings.Join(repoSegments, "/"), digest)
 		metrics.IncApiErrorResults()
-		return ctx.JSON(http.StatusInternalServerError, "")
-	}
-	// if the Range header is set in the request then by omitting the Content-Length header
-	// in the response, the underlying http library automatically supports chunked transfer
-	if ctx.Request().Header.Get("Range") == "" {
-		ctx.Response().Header().Add("Content-Length", strconv.Itoa(int(fi.Size())))
+		return NewRegistryError(http.StatusInternalServerError, Unknown, "blob missing from the file system").WithDetail(digest).Send(ctx)
 	}
-	ctx.Response().Header().Add("Docker-Distribution-Api-Version", "registry/2.0")
 	f, err := os.Open(blob_file)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	return ctx.Stream(http.StatusOK, "binary/octet-stream", f)
+	ctx.Response().Header().Set("Docker-Content-Digest", "sha256:"+digest)
+	ctx.Response().Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+	ctx.Response().Header().Set("Content-Type", "binary/octet-stream")
+	ctx.Response().Header().Set("Etag", `"sha256:`+digest+`"`)
+	http.ServeContent(ctx.Response(), ctx.Request(), "", fi.ModTime(), f)
+	return nil
+}
+
+// streamBlob sends a blob to the client from the passed reader, which follows the blob while
+// it is downloaded from the upstream. The reader is only passed once the first bytes of the blob
+// have arrived - see 'cache.StreamBlob' - so an upstream error is never sent as a 200. The size
+// is from the manifest. If the download fails after the status is sent then the response is cut
+// short, which the client detects from the Content-Length.
+func streamBlob(ctx echo.Context, digest string, rc io.Reader, size int) error {
+	ctx.Response().Header().Set("Docker-Content-Digest", "sha256:"+digest)
+	ctx.Response().Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+	ctx.Response().Header().Set("Content-Type", "binary/octet-stream")
+	ctx.Response().Header().Set("Etag", `"sha256:`+digest+`"`)
+	if size > 0 {
+		ctx.Response().Header().Set("Content-Length", strconv.Itoa(size))
+	}
+	ctx.Response().WriteHeader(http.StatusOK)
+	if _, err := io.Copy(ctx.Response(), rc); err != nil {
+		log.Errorf("error streaming blob %q: %s", digest, err)
+		metrics.IncApiErrorResults()
+		return err
+	}
+	return nil
+}
+
+// HEAD /v2/.../blobs/digest. Answers the same way as the GET handler but without a body,
+// so clients can check for the existence of a blob before pulling it. A blob that is pending
+// because blobs are pulled lazily is answered from the size in the manifest without fetching it.
+func (r *OciRegistry) handleV2HeadBlobsDigest(ctx echo.Context, digest string, repoSegments ...string) error {
+	metrics.IncV2ApiEndpointHits()
+	digest = helpers.GetDigestFrom(digest)
+	if re := r.canPullBlob(ctx, r.repositoryFor(ctx, repoSegments), digest); re != nil {
+		return re.Send(ctx)
+	}
+	if !cache.HasBlob(digest) {
+		log.Debugf("HEAD for blob not in cache for %q, digest %q", strings.Join(repoSegments, "/"), digest)
+		return NewRegistryError(http.StatusNotFound, BlobUnknown, "blob unknown to registry").Send(ctx)
+	}
+	size := 0
+	if fi, err, _ := helpers.GetBlob(r.imagePath, digest); err == nil {
+		size = int(fi.Size())
+	} else if pendingSize, pending := cache.PendingBlob(digest); pending && !r.airGapped {
+		size = pendingSize
+	} else {
+		log.Errorf("blob not on the file system for %q, digest %q", strings.Join(repoSegments, "/"), digest)
+		metrics.IncApiErrorResults()
+		return NewRegistryError(http.StatusNotFound, BlobUnknown, "blob unknown to registry").Send(ctx)
+	}
+	ctx.Response().Header().Add("Content-Length", strconv.Itoa(size))
+	ctx.Response().Header().Add("Docker-Content-Digest", "sha256:"+digest)
+	ctx.Response().Header().Add("Docker-Distribution-Api-Version", "registry/2.0")
+	ctx.Response().Header().Add("Content-Type", "binary/octet-stream")
+	return ctx.NoContent(http.StatusOK)
+}
+
+// GET /v2/.../tags/list. If not air-gapped, the request is passed through to the upstream
+// along with the 'n' and 'last' pagination params. If air-gapped or the hosted namespace - or
+// if the upstream is not allowed or can't produce the list - then the tags are taken from the
+// in-mem manifest cache.
+func (r *OciRegistry) handleV2TagsList(ctx echo.Context, n *string, last *string, namespace *string, repoSegments ...string) error {
+	metrics.IncV2ApiEndpointHits()
+	pr, err := pullrequest.NewPullRequest(r.x_registry_hdr(ctx), namespace, r.defaultNs, "", repoSegments...)
+	if err != nil {
+		return NewRegistryError(http.StatusBadRequest, NameInvalid, err.Error()).Send(ctx)
+	}
+	if re := r.canPull(ctx, pr.Remote+"/"+pr.Repository); re != nil {
+		return re.Send(ctx)
+	}
+	if _, err := pageSize(n); err != nil {
+		return NewRegistryError(http.StatusBadRequest, PaginationInvalid, err.Error()).Send(ctx)
+	}
+	var upstreamErr error
+	if !r.offline(pr) && r.upstreamAllowed(pr) {
+		tags, more, err := upstreamTags(pr, n, last, r.pullTimeout)
+		if err == nil {
+			return tagsListResponse(ctx, tags, more, repoSegments...)
+		}
+		log.Warnf("unable to get tags list for %s/%s from the upstream - using the cache. The error was: %s", pr.Remote, pr.Repository, err)
+		upstreamErr = err
+	}
+	tags := cache.GetTags(pr.Remote, pr.Repository)
+	if len(tags) == 0 {
+		log.Debugf("no cached tags for %s/%s", pr.Remote, pr.Repository)
+		metrics.IncApiErrorResults()
+		var ue *upstream.Error
+		if errors.As(upstreamErr, &ue) {
+			return fromUpstreamError(upstreamErr, NameUnknown).Send(ctx)
+		}
+		return NewRegistryError(http.StatusNotFound, NameUnknown, "repository name not known to registry").WithDetail(strings.Join(repoSegments, "/")).Send(ctx)
+	}
+	page, more, _ := paginate(tags, n, last)
+	return tagsListResponse(ctx, page, more, repoSegments...)
+}
+
+// GET /v2/.../referrers/digest. Returns the referrers index for the subject digest. If the
+// 'artifactType' query param is provided then the index is filtered to only that artifact
+// type and the OCI-Filters-Applied header tells the client that the filter was applied.
+func (r *OciRegistry) handleV2Referrers(ctx echo.Context, digest string, artifactType *string, namespace *string, repoSegments ...string) error {
+	metrics.IncV2ApiEndpointHits()
+	pr, err := pullrequest.NewPullRequest(r.x_registry_hdr(ctx), namespace, r.defaultNs, digest, repoSegments...)
+	if err != nil {
+		return NewRegistryError(http.StatusBadRequest, NameInvalid, err.Error()).Send(ctx)
+	}
+	if pr.PullType != pullrequest.ByDigest {
+		return NewRegistryError(http.StatusBadRequest, DigestInvalid, fmt.Sprintf("invalid digest: %q", digest)).Send(ctx)
+	}
+	if re := r.canPull(ctx, pr.Remote+"/"+pr.Repository); re != nil {
+		return re.Send(ctx)
+	}
+	idx, err := cache.GetReferrers(pr, r.imagePath, r.pullTimeout, r.offline(pr) || !r.upstreamAllowed(pr))
+	if err != nil {
+		log.Errorf("error getting referrers for %q: %s", pr.Url(), err)
+		metrics.IncApiErrorResults()
+		return fromUpstreamError(err, ManifestUnknown).Send(ctx)
+	}
+	if artifactType != nil {
+		idx.Manifests = slices.DeleteFunc(slices.Clone(idx.Manifests), func(d v1oci.Descriptor) bool {
+			return d.ArtifactType != *artifactType
+		})
+		ctx.Response().Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	if idx.Manifests == nil {
+		idx.Manifests = []v1oci.Descriptor{}
+	}
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return ctx.Blob(http.StatusOK, "application/vnd.oci.image.index.v1+json", b)
+}
+
+// GET /v2/_catalog. Lists the repositories in the cache. The repositories are always taken from
+// the in-mem manifest cache - never from an upstream - and the upstream registry is the first
+// path component of each repository so that every listed repository can be pulled as-is. If the
+// access policy is enabled then only the repositories that the client can pull from are listed.
+func (r *OciRegistry) handleV2Catalog(ctx echo.Context, n *string, last *string) error {
+	metrics.IncV2ApiEndpointHits()
+	page, more, err := paginate(r.pullable(ctx, cache.GetRepositories()), n, last)
+	if err != nil {
+		return NewRegistryError(http.StatusBadRequest, PaginationInvalid, err.Error()).Send(ctx)
+	}
+	if more && len(page) != 0 {
+		ctx.Response().Header().Set("Link", nextLink(ctx, len(page), page[len(page)-1]))
+	}
+	return ctx.JSON(http.StatusOK, catalog{Repositories: page})
 }
 
 // GET /v2/
@@ -91,10 +304,18 @@ func (r *OciRegistry) handleV2HeadDefault(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, "true")
 }
 
-// GET /v2/auth. The server doesn't do anything with tokens but if the client wants a token
-// it gets one.
+// GET /v2/auth. If token auth is enabled then a signed token is issued to an authenticated
+// client - see 'issueToken'. Otherwise the server doesn't do anything with tokens but if the
+// client wants a token it gets one.
 func (r *OciRegistry) handleV2Auth(ctx echo.Context, params models.V2AuthParams) error {
 	metrics.IncV2ApiEndpointHits()
+	if serverauth.Mode() == serverauth.ModeToken {
+		scopes := ctx.QueryParams()["scope"]
+		if len(scopes) == 0 && params.Scope != nil {
+			scopes = []string{*params.Scope}
+		}
+		return r.issueToken(ctx, scopes)
+	}
 	body := struct {
 		Token string `json:"token"`
 	}{
@@ -1,19 +1,28 @@
 package impl
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/aceeric/ociregistry/api/models"
+	"github.com/aceeric/ociregistry/impl/cache"
 	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/globals"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
 	"github.com/aceeric/ociregistry/impl/serialize"
 	"github.com/aceeric/ociregistry/mock"
 
+	"github.com/aceeric/imgpull/pkg/imgpull/v1oci"
 	"github.com/labstack/echo/v4"
 	log "github.com/sirupsen/logrus"
 )
@@ -120,6 +129,54 @@ func TestNeverCacheLatest(t *testing.T) {
 	}
 }
 
+// Checks that a tag rule takes precedence over 'alwaysPullLatest' so 'latest' is served from
+// cache within the rule's ttl, and that a ttl of zero re-pulls the tag every time.
+func TestTagRules(t *testing.T) {
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	cache.ResetCache()
+	var cnt atomic.Int32
+	callback := func(url string) {
+		if url == "/v2/hello-world/manifests/latest" {
+			cnt.Add(1)
+		}
+	}
+	server, url := mock.ServerWithCallback(mock.NewMockParams(mock.NONE, mock.HTTP), &callback)
+	defer server.Close()
+	for _, tst := range []struct {
+		ttl       string
+		expectCnt int32
+	}{
+		// one pull is a HEAD to connect and a GET
+		{"1h", 2},
+		{"0s", 10},
+	} {
+		cfg := fmt.Sprintf(serverCfg, td, 1000, true, url) + "tagRules:\n- pattern: \":latest$\"\n  ttl: " + tst.ttl + "\n"
+		if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+			t.FailNow()
+		}
+		cache.ResetCache()
+		cnt.Store(0)
+		r := NewOciRegistry(nil)
+		e := echo.New()
+		for range 5 {
+			rec := httptest.NewRecorder()
+			ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+			r.handleV2ManifestsReference(ctx, "latest", &url, http.MethodGet, "hello-world")
+			if rec.Code != http.StatusOK {
+				t.FailNow()
+			}
+		}
+		if cnt.Load() != tst.expectCnt {
+			t.Errorf("ttl %s: expected %d upstream requests, got %d", tst.ttl, tst.expectCnt, cnt.Load())
+		}
+	}
+}
+
 // Tests getting a blob. Since no manifests have been pulled thru a 404
 // should be returned.
 func TestBlobGetFails(t *testing.T) {
@@ -176,6 +233,349 @@ func TestPullImageAndBlob(t *testing.T) {
 	}
 }
 
+// HEADs a blob before and after the image that references it is pulled. The
+// first HEAD should 404 and the second should return the blob headers with no body.
+func TestHeadBlob(t *testing.T) {
+	cache.ResetCache()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fail()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	cfg := fmt.Sprintf(serverCfg, td, 1000, false, url)
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.Fail()
+	}
+	defer server.Close()
+
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	blobDigest := "d2c94e258dcb3c5ac2798d32e1249e42ef01cba4841c2234249495f87264ac5a"
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(httptest.NewRequest(http.MethodHead, "/", nil), rec)
+	r.handleV2HeadBlobsDigest(ctx, "sha256:"+blobDigest, "hello-world")
+	if ctx.Response().Status != 404 {
+		t.Fail()
+	}
+	ctx = e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	r.handleV2ManifestsReference(ctx, "sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57", &url, http.MethodGet, "hello-world")
+	if ctx.Response().Status != 200 {
+		t.FailNow()
+	}
+	rec = httptest.NewRecorder()
+	ctx = e.NewContext(httptest.NewRequest(http.MethodHead, "/", nil), rec)
+	r.handleV2HeadBlobsDigest(ctx, "sha256:"+blobDigest, "hello-world")
+	if ctx.Response().Status != 200 {
+		t.Fail()
+	}
+	fi, err := os.Stat(filepath.Join(td, globals.BlobPath, blobDigest))
+	if err != nil {
+		t.FailNow()
+	}
+	if rec.Header().Get("Content-Length") != strconv.Itoa(int(fi.Size())) {
+		t.Fail()
+	}
+	if rec.Header().Get("Docker-Content-Digest") != "sha256:"+blobDigest {
+		t.Fail()
+	}
+	if rec.Header().Get("Content-Type") == "" || rec.Body.Len() != 0 {
+		t.Fail()
+	}
+}
+
+// Tests range requests against a blob: a partial download resumed with an open-ended
+// range, a multi-range request, and a range past the end of the blob.
+func TestBlobRange(t *testing.T) {
+	cache.ResetCache()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fail()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	cfg := fmt.Sprintf(serverCfg, td, 1000, false, url)
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.Fail()
+	}
+	defer server.Close()
+
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	r.handleV2ManifestsReference(ctx, "sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57", &url, http.MethodGet, "hello-world")
+	if ctx.Response().Status != 200 {
+		t.FailNow()
+	}
+	blobDigest := "c1ec31eb59444d78df06a974d155e597c894ab4cda84f08294145e845394988e"
+	blob, err := os.ReadFile(filepath.Join(td, globals.BlobPath, blobDigest))
+	if err != nil || len(blob) < 100 {
+		t.FailNow()
+	}
+	get := func(rangeHdr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Range", rangeHdr)
+		rec := httptest.NewRecorder()
+		r.handleV2BlobsDigest(e.NewContext(req, rec), "sha256:"+blobDigest, "hello-world")
+		return rec
+	}
+	// simulate an interrupted download followed by a resume
+	rec := get("bytes=0-99")
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), blob[:100]) {
+		t.Fail()
+	}
+	rec = get("bytes=100-")
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), blob[100:]) {
+		t.Fail()
+	}
+	if rec.Header().Get("Content-Range") != fmt.Sprintf("bytes 100-%d/%d", len(blob)-1, len(blob)) {
+		t.Fail()
+	}
+	if rec.Header().Get("Content-Length") != strconv.Itoa(len(blob)-100) {
+		t.Fail()
+	}
+	rec = get("bytes=0-9,20-29")
+	if rec.Code != http.StatusPartialContent || !strings.HasPrefix(rec.Header().Get("Content-Type"), "multipart/byteranges") {
+		t.Fail()
+	}
+	rec = get(fmt.Sprintf("bytes=%d-", len(blob)))
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fail()
+	}
+	if rec.Header().Get("Content-Range") != fmt.Sprintf("bytes */%d", len(blob)) {
+		t.Fail()
+	}
+}
+
+// Gets the tags list from the upstream one page at a time, following the Link header.
+func TestTagsListUpstream(t *testing.T) {
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fail()
+	}
+	defer os.RemoveAll(td)
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	cfg := fmt.Sprintf(serverCfg, td, 1000, false, url)
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.Fail()
+	}
+	defer server.Close()
+
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	n := "2"
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/v2/hello-world/tags/list?n=2", nil), rec)
+	r.handleV2TagsList(ctx, &n, nil, &url, "hello-world")
+	tl := tagsList{}
+	if ctx.Response().Status != 200 || json.Unmarshal(rec.Body.Bytes(), &tl) != nil {
+		t.FailNow()
+	}
+	if tl.Name != "hello-world" || strings.Join(tl.Tags, ",") != "latest,linux" {
+		t.Fail()
+	}
+	if rec.Header().Get("Link") != `</v2/hello-world/tags/list?last=linux&n=2>; rel="next"` {
+		t.Fail()
+	}
+	last := "linux"
+	rec = httptest.NewRecorder()
+	ctx = e.NewContext(httptest.NewRequest(http.MethodGet, "/v2/hello-world/tags/list?n=2&last=linux", nil), rec)
+	r.handleV2TagsList(ctx, &n, &last, &url, "hello-world")
+	tl = tagsList{}
+	if ctx.Response().Status != 200 || json.Unmarshal(rec.Body.Bytes(), &tl) != nil {
+		t.FailNow()
+	}
+	if strings.Join(tl.Tags, ",") != "nanoserver" || rec.Header().Get("Link") != "" {
+		t.Fail()
+	}
+}
+
+// Gets the tags list from the cache, first in air-gapped mode and then with the
+// upstream down.
+func TestTagsListFromCache(t *testing.T) {
+	cache.ResetCache()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fail()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	cfg := fmt.Sprintf(serverCfg, td, 1000, false, url)
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.Fail()
+	}
+
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	r.handleV2ManifestsReference(ctx, "latest", &url, http.MethodGet, "hello-world")
+	if ctx.Response().Status != 200 {
+		t.FailNow()
+	}
+	for _, airGapped := range []bool{true, false} {
+		r.airGapped = airGapped
+		if !airGapped {
+			server.Close()
+		}
+		rec := httptest.NewRecorder()
+		ctx = e.NewContext(httptest.NewRequest(http.MethodGet, "/v2/hello-world/tags/list", nil), rec)
+		r.handleV2TagsList(ctx, nil, nil, &url, "hello-world")
+		tl := tagsList{}
+		if ctx.Response().Status != 200 || json.Unmarshal(rec.Body.Bytes(), &tl) != nil {
+			t.FailNow()
+		}
+		if strings.Join(tl.Tags, ",") != "latest" {
+			t.Fail()
+		}
+	}
+	ctx = e.NewContext(httptest.NewRequest(http.MethodGet, "/v2/not-cached/tags/list", nil), httptest.NewRecorder())
+	r.handleV2TagsList(ctx, nil, nil, &url, "not-cached")
+	if ctx.Response().Status != 404 {
+		t.Fail()
+	}
+	bad := "-1"
+	rec := httptest.NewRecorder()
+	ctx = e.NewContext(httptest.NewRequest(http.MethodGet, "/v2/hello-world/tags/list?n=-1", nil), rec)
+	r.handleV2TagsList(ctx, &bad, nil, &url, "hello-world")
+	if rec.Code != http.StatusBadRequest || errorBody(t, rec).Code != PaginationInvalid {
+		t.Fail()
+	}
+}
+
+// Pulls an image and then lists the catalog, which has the upstream registry as the
+// first path component of the repository.
+func TestCatalog(t *testing.T) {
+	cache.ResetCache()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	defer server.Close()
+	cfg := fmt.Sprintf(serverCfg, td, 1000, false, url)
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.FailNow()
+	}
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	r.handleV2ManifestsReference(ctx, "latest", &url, http.MethodGet, "hello-world")
+	if ctx.Response().Status != 200 {
+		t.FailNow()
+	}
+	repo := url + "/hello-world"
+	one, bad := "1", "x"
+	for _, tst := range []struct {
+		n      *string
+		last   *string
+		status int
+		repos  string
+	}{
+		{nil, nil, http.StatusOK, repo},
+		{&one, nil, http.StatusOK, repo},
+		{nil, &repo, http.StatusOK, ""},
+		{&bad, nil, http.StatusBadRequest, ""},
+	} {
+		rec := httptest.NewRecorder()
+		ctx = e.NewContext(httptest.NewRequest(http.MethodGet, "/v2/_catalog", nil), rec)
+		r.handleV2Catalog(ctx, tst.n, tst.last)
+		if rec.Code != tst.status {
+			t.FailNow()
+		}
+		if rec.Code != http.StatusOK {
+			if errorBody(t, rec).Code != PaginationInvalid {
+				t.Fail()
+			}
+			continue
+		}
+		c := catalog{}
+		if json.Unmarshal(rec.Body.Bytes(), &c) != nil || strings.Join(c.Repositories, ",") != tst.repos || c.Repositories == nil {
+			t.Fail()
+		}
+		if rec.Header().Get("Link") != "" {
+			t.Fail()
+		}
+	}
+}
+
+// Gets referrers from the upstream referrers API and from the tag schema, filters by
+// artifact type, serves them air-gapped, and prunes them with the subject.
+func TestReferrers(t *testing.T) {
+	cache.ResetCache()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fail()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	cfg := fmt.Sprintf(serverCfg, td, 1000, false, url)
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.Fail()
+	}
+	defer server.Close()
+
+	listDigest := "sha256:e4ccfd825622441dcee5123f9d4a48b2eb8787d858de346106a83f0c745cc255"
+	imageDigest := "sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57"
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	getReferrers := func(digest string, artifactType *string) (*httptest.ResponseRecorder, v1oci.Index) {
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		r.handleV2Referrers(ctx, digest, artifactType, &url, "hello-world")
+		idx := v1oci.Index{}
+		if rec.Code != 200 || json.Unmarshal(rec.Body.Bytes(), &idx) != nil {
+			t.FailNow()
+		}
+		return rec, idx
+	}
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	r.handleV2ManifestsReference(ctx, "latest", &url, http.MethodGet, "hello-world")
+	if ctx.Response().Status != 200 {
+		t.FailNow()
+	}
+	for _, digest := range []string{listDigest, imageDigest} {
+		_, idx := getReferrers(digest, nil)
+		if len(idx.Manifests) != 1 || idx.Manifests[0].Digest != imageDigest {
+			t.Fail()
+		}
+	}
+	pr, _ := pullrequest.NewPullRequest("", &url, "", imageDigest, "hello-world")
+	if !cache.IsCached(pr) {
+		t.Fail()
+	}
+	artifactType := "application/vnd.example.signature"
+	rec, idx := getReferrers(listDigest, &artifactType)
+	if len(idx.Manifests) != 0 || rec.Header().Get("OCI-Filters-Applied") != "artifactType" {
+		t.Fail()
+	}
+	r.airGapped = true
+	if _, idx = getReferrers(listDigest, nil); len(idx.Manifests) != 1 {
+		t.Fail()
+	}
+	comparer, _ := cache.ParseCriteria(config.PruneConfig{Type: "pattern", Expr: "hello-world:latest"})
+	if len(cache.GetManifestsCompare(comparer, -1)) != 1 {
+		t.FailNow()
+	}
+	expr, dryRun := "hello-world:latest", "false"
+	_, err = cache.Prune("pattern", nil, &expr, &dryRun, nil)
+	log.SetOutput(io.Discard)
+	if err != nil {
+		t.FailNow()
+	}
+	if cache.IsCached(pr) {
+		t.Fail()
+	}
+	if _, idx = getReferrers(listDigest, nil); len(idx.Manifests) != 0 {
+		t.Fail()
+	}
+}
+
 type TestAuthToken struct {
 	Token string `json:"token"`
 }
@@ -188,10 +588,11 @@ func TestHandleV2Auth(t *testing.T) {
 	ctx := e.NewContext(req, rec)
 	scope := "SCOPE"
 	service := "SERVICE"
+	authorization := "AUTHORIZATION"
 	params := models.V2AuthParams{
 		Scope:         &scope,
 		Service:       &service,
-		Authorization: "AUTHORIZATION",
+		Authorization: &authorization,
 	}
 	r.handleV2Auth(ctx, params)
 	if ctx.Response().Status != 200 {
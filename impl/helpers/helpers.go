@@ -4,8 +4,11 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/aceeric/ociregistry/impl/globals"
+
+	"github.com/aceeric/imgpull/pkg/imgpull"
 )
 
 var srch = `.*([a-f0-9]{64}).*`
@@ -29,3 +32,53 @@ func GetBlob(base string, shaPat string) (os.FileInfo, error, string) {
 	fi, err := os.Stat(blobFile)
 	return fi, err, blobFile
 }
+
+// ValueOr returns the passed value if not empty, otherwise the passed default.
+func ValueOr(value string, dflt string) string {
+	if value != "" {
+		return value
+	}
+	return dflt
+}
+
+// PlatformDigests returns the digests of the image manifests in the passed manifest list that
+// match any of the passed platforms. A platform is 'os/arch' or 'os/arch/variant' e.g.
+// 'linux/arm64/v8'. If no variant is specified then any variant matches. The platform 'all'
+// matches every image manifest except the ones with an unknown platform, which are attestations
+// rather than images. The digests are returned in the order of the manifest list and include
+// the sha256: prefix.
+func PlatformDigests(mh imgpull.ManifestHolder, platforms []string) []string {
+	digests := []string{}
+	add := func(digest, os, arch, variant string) {
+		for _, platform := range platforms {
+			if platformMatches(platform, os, arch, variant) {
+				digests = append(digests, digest)
+				return
+			}
+		}
+	}
+	switch mh.Type {
+	case imgpull.V2dockerManifestList:
+		for _, m := range mh.V2dockerManifestList.Manifests {
+			add(m.Digest, m.Platform.OS, m.Platform.Architecture, m.Platform.Variant)
+		}
+	case imgpull.V1ociIndex:
+		for _, m := range mh.V1ociIndex.Manifests {
+			add(m.Digest, m.Platform.Os, m.Platform.Architecture, m.Platform.Variant)
+		}
+	}
+	return digests
+}
+
+// platformMatches returns true if the passed platform matches the passed os, arch, and variant
+// from a manifest list entry.
+func platformMatches(platform, os, arch, variant string) bool {
+	if platform == "all" {
+		return os != "unknown"
+	}
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return false
+	}
+	return parts[0] == os && parts[1] == arch && (len(parts) == 2 || parts[2] == variant)
+}
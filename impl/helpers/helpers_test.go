@@ -6,6 +6,8 @@ import (
 	"testing"
 
 	"github.com/aceeric/ociregistry/impl/globals"
+
+	"github.com/aceeric/imgpull/pkg/imgpull"
 )
 
 func TestGetBlobPath(t *testing.T) {
@@ -19,3 +21,34 @@ func TestGetBlobPath(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestPlatformDigests(t *testing.T) {
+	b, err := os.ReadFile("../../mock/testfiles/manifestList.json")
+	if err != nil {
+		t.FailNow()
+	}
+	mh, err := imgpull.NewManifestHolder("application/vnd.oci.image.index.v1+json", b, "", "")
+	if err != nil {
+		t.FailNow()
+	}
+	for _, tst := range []struct {
+		platforms []string
+		cnt       int
+	}{
+		{[]string{}, 0},
+		{[]string{"linux/amd64"}, 1},
+		{[]string{"linux/arm"}, 2},
+		{[]string{"linux/arm/v7"}, 1},
+		{[]string{"linux/amd64", "linux/arm64"}, 2},
+		{[]string{"windows/amd64"}, 2},
+		{[]string{"linux"}, 0},
+		{[]string{"all"}, 11},
+	} {
+		if digests := PlatformDigests(mh, tst.platforms); len(digests) != tst.cnt {
+			t.Errorf("platforms %v: expected %d digests, got %d", tst.platforms, tst.cnt, len(digests))
+		}
+	}
+	if digests := PlatformDigests(mh, []string{"linux/amd64"}); digests[0] != "sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,274 @@
+package impl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/helpers"
+	"github.com/aceeric/ociregistry/impl/metrics"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/impl/upload"
+
+	"github.com/aceeric/imgpull/pkg/imgpull"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxManifestSize is the largest manifest that can be pushed. This is the limit
+// that the distribution spec recommends registries accept.
+const maxManifestSize = 4 * 1024 * 1024
+
+// isHosted returns true if the passed PullRequest is for the hosted namespace. Images in
+// the hosted namespace are only ever served from the cache.
+func (r *OciRegistry) isHosted(pr pullrequest.PullRequest) bool {
+	return r.hostedNs != "" && pr.Remote == r.hostedNs
+}
+
+// offline returns true if the passed PullRequest can't be satisfied by an upstream, either
+// because the server is air-gapped or because the request is for the hosted namespace.
+func (r *OciRegistry) offline(pr pullrequest.PullRequest) bool {
+	return r.airGapped || r.isHosted(pr)
+}
+
+// hostedPr parses a PullRequest from the passed reference and repository segments for a push
+// request. Pushing is only supported to the hosted namespace so anything else is an error, as is
+// a push that the policy doesn't allow the client - see 'canPush'.
+func (r *OciRegistry) hostedPr(ctx echo.Context, reference string, repoSegments ...string) (pullrequest.PullRequest, *RegistryError) {
+	pr, err := pullrequest.NewPullRequest(r.x_registry_hdr(ctx), nil, r.defaultNs, reference, repoSegments...)
+	if err != nil {
+		return pr, NewRegistryError(http.StatusBadRequest, NameInvalid, err.Error())
+	}
+	if !r.isHosted(pr) {
+		return pr, NewRegistryError(http.StatusMethodNotAllowed, Unsupported, "push is only supported to the hosted namespace").WithDetail(pr.Remote)
+	}
+	if re := r.canPush(ctx, pr.Remote+"/"+pr.Repository); re != nil {
+		return pr, re
+	}
+	return pr, nil
+}
+
+// POST /v2/.../blobs/uploads/. Starts a blob upload. If the 'mount' query param is provided
+// and the blob is already on the file system then the blob is "mounted" - since all blobs
+// share one directory there is nothing to do. If the 'digest' query param is provided then
+// the request body is the entire blob (a monolithic upload.) Otherwise the client is given
+// the location to upload the blob to.
+func (r *OciRegistry) handleV2PostBlobsUploads(ctx echo.Context, digest *string, mount *string, repoSegments ...string) error {
+	metrics.IncV2ApiEndpointHits()
+	pr, re := r.hostedPr(ctx, "", repoSegments...)
+	if re != nil {
+		return re.Send(ctx)
+	}
+	if mount != nil {
+		if d := helpers.GetDigestFrom(*mount); d != "" {
+			// a blob is only mounted if the client could pull it, so the policy can't be bypassed
+			// by mounting the blob of a denied repository
+			exists, _ := serialize.BlobExists(r.imagePath, d)
+			if (exists || cache.HasBlob(d)) && r.canPullBlob(ctx, pr.Remote+"/"+pr.Repository, d) == nil {
+				return blobCreated(ctx, "sha256:"+d, repoSegments...)
+			}
+		}
+		// per the spec, if the blob can't be mounted then fall back to a regular upload
+	}
+	repository := pr.Remote + "/" + pr.Repository
+	id, err := upload.Start(r.imagePath, repository)
+	if err != nil {
+		log.Errorf("error starting upload for %q: %s", repository, err)
+		return NewRegistryError(http.StatusInternalServerError, Unknown, err.Error()).Send(ctx)
+	}
+	if digest != nil {
+		if _, err := upload.Append(id, repository, -1, ctx.Request().Body); err != nil {
+			upload.Cancel(id, repository)
+			return uploadError(err).Send(ctx)
+		}
+		if err := upload.Complete(r.imagePath, id, repository, *digest); err != nil {
+			upload.Cancel(id, repository)
+			return uploadError(err).Send(ctx)
+		}
+		return blobCreated(ctx, *digest, repoSegments...)
+	}
+	ctx.Response().Header().Set("Location", uploadLocation(id, repoSegments...))
+	ctx.Response().Header().Set("Docker-Upload-UUID", id)
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// PATCH /v2/.../blobs/uploads/reference. Appends a chunk to an upload. If the Content-Range
+// header is provided then the chunk must start where the prior chunk ended, else 416.
+func (r *OciRegistry) handleV2PatchBlobsUploads(ctx echo.Context, reference string, repoSegments ...string) error {
+	metrics.IncV2ApiEndpointHits()
+	pr, re := r.hostedPr(ctx, "", repoSegments...)
+	if re != nil {
+		return re.Send(ctx)
+	}
+	repository := pr.Remote + "/" + pr.Repository
+	start, err := rangeStart(ctx.Request().Header.Get("Content-Range"))
+	if err != nil {
+		return NewRegistryError(http.StatusRequestedRangeNotSatisfiable, BlobUploadInvalid, err.Error()).Send(ctx)
+	}
+	size, err := upload.Append(reference, repository, start, ctx.Request().Body)
+	if err != nil {
+		if errors.Is(err, upload.ErrRange) {
+			setUploadHeaders(ctx, reference, size, repoSegments...)
+		}
+		return uploadError(err).Send(ctx)
+	}
+	setUploadHeaders(ctx, reference, size, repoSegments...)
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// PUT /v2/.../blobs/uploads/reference?digest=... Completes an upload. The request body, if
+// any, is the final chunk. The digest of the uploaded blob must match the 'digest' query param.
+func (r *OciRegistry) handleV2PutBlobsUploads(ctx echo.Context, reference string, digest *string, repoSegments ...string) error {
+	metrics.IncV2ApiEndpointHits()
+	pr, re := r.hostedPr(ctx, "", repoSegments...)
+	if re != nil {
+		return re.Send(ctx)
+	}
+	if digest == nil {
+		return NewRegistryError(http.StatusBadRequest, DigestInvalid, "the digest query param is required").Send(ctx)
+	}
+	repository := pr.Remote + "/" + pr.Repository
+	start, err := rangeStart(ctx.Request().Header.Get("Content-Range"))
+	if err != nil {
+		return NewRegistryError(http.StatusRequestedRangeNotSatisfiable, BlobUploadInvalid, err.Error()).Send(ctx)
+	}
+	if _, err := upload.Append(reference, repository, start, ctx.Request().Body); err != nil {
+		return uploadError(err).Send(ctx)
+	}
+	if err := upload.Complete(r.imagePath, reference, repository, *digest); err != nil {
+		return uploadError(err).Send(ctx)
+	}
+	return blobCreated(ctx, *digest, repoSegments...)
+}
+
+// GET /v2/.../blobs/uploads/reference. Returns the progress of an upload so a client
+// can resume an interrupted upload.
+func (r *OciRegistry) handleV2GetBlobsUploads(ctx echo.Context, reference string, repoSegments ...string) error {
+	metrics.IncV2ApiEndpointHits()
+	pr, re := r.hostedPr(ctx, "", repoSegments...)
+	if re != nil {
+		return re.Send(ctx)
+	}
+	size, err := upload.Size(reference, pr.Remote+"/"+pr.Repository)
+	if err != nil {
+		return uploadError(err).Send(ctx)
+	}
+	setUploadHeaders(ctx, reference, size, repoSegments...)
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// DELETE /v2/.../blobs/uploads/reference. Cancels an upload.
+func (r *OciRegistry) handleV2DeleteBlobsUploads(ctx echo.Context, reference string, repoSegments ...string) error {
+	metrics.IncV2ApiEndpointHits()
+	pr, re := r.hostedPr(ctx, "", repoSegments...)
+	if re != nil {
+		return re.Send(ctx)
+	}
+	if err := upload.Cancel(reference, pr.Remote+"/"+pr.Repository); err != nil {
+		return uploadError(err).Send(ctx)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// PUT /v2/.../manifests/reference. Pushes a manifest to the hosted namespace. The Content-Type
+// header must be a manifest media type, and everything the manifest references must already
+// have been pushed.
+func (r *OciRegistry) handleV2PutManifestsReference(ctx echo.Context, reference string, repoSegments ...string) error {
+	metrics.IncV2ApiEndpointHits()
+	pr, re := r.hostedPr(ctx, reference, repoSegments...)
+	if re != nil {
+		return re.Send(ctx)
+	}
+	b, err := io.ReadAll(io.LimitReader(ctx.Request().Body, maxManifestSize+1))
+	if err != nil {
+		return NewRegistryError(http.StatusBadRequest, ManifestInvalid, err.Error()).Send(ctx)
+	} else if len(b) > maxManifestSize {
+		return NewRegistryError(http.StatusRequestEntityTooLarge, SizeInvalid, "manifest is too large").WithDetail(maxManifestSize).Send(ctx)
+	}
+	sum := sha256.Sum256(b)
+	digest := hex.EncodeToString(sum[:])
+	if pr.PullType == pullrequest.ByDigest && helpers.GetDigestFrom(pr.Reference) != digest {
+		return NewRegistryError(http.StatusBadRequest, DigestInvalid, fmt.Sprintf("manifest has digest sha256:%s", digest)).WithDetail(pr.Reference).Send(ctx)
+	}
+	mediaType, _, _ := strings.Cut(ctx.Request().Header.Get("Content-Type"), ";")
+	mh, err := imgpull.NewManifestHolder(strings.TrimSpace(mediaType), b, digest, pr.Url())
+	if err != nil {
+		return NewRegistryError(http.StatusBadRequest, ManifestInvalid, err.Error()).Send(ctx)
+	}
+	if err := cache.PutManifest(pr, mh, r.imagePath); err != nil {
+		log.Errorf("error pushing manifest %q: %s", pr.Url(), err)
+		if errors.Is(err, cache.ErrManifestBlobUnknown) {
+			return NewRegistryError(http.StatusBadRequest, ManifestBlobUnknown, err.Error()).Send(ctx)
+		}
+		return NewRegistryError(http.StatusInternalServerError, Unknown, err.Error()).Send(ctx)
+	}
+	ctx.Response().Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/sha256:%s", strings.Join(repoSegments, "/"), digest))
+	ctx.Response().Header().Set("Docker-Content-Digest", "sha256:"+digest)
+	return ctx.NoContent(http.StatusCreated)
+}
+
+// blobCreated is the response for a completed upload or a mount. The blob is recorded in the
+// cache so it can be pulled - and HEAD'd - before a manifest that references it is pushed.
+func blobCreated(ctx echo.Context, digest string, repoSegments ...string) error {
+	cache.AddUploadedBlob(helpers.GetDigestFrom(digest))
+	ctx.Response().Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", strings.Join(repoSegments, "/"), digest))
+	ctx.Response().Header().Set("Docker-Content-Digest", digest)
+	return ctx.NoContent(http.StatusCreated)
+}
+
+// uploadLocation returns the URL path that a client uses to continue the passed upload.
+func uploadLocation(id string, repoSegments ...string) string {
+	return fmt.Sprintf("/v2/%s/blobs/uploads/%s", strings.Join(repoSegments, "/"), id)
+}
+
+// setUploadHeaders sets the headers that tell the client where to continue the passed upload
+// and how much has been uploaded so far. The Range header is inclusive, so an upload with 100
+// bytes has range '0-99'.
+func setUploadHeaders(ctx echo.Context, id string, size int64, repoSegments ...string) {
+	ctx.Response().Header().Set("Location", uploadLocation(id, repoSegments...))
+	ctx.Response().Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	ctx.Response().Header().Set("Docker-Upload-UUID", id)
+}
+
+// rangeStart returns the start offset from the passed Content-Range header value, which is like
+// '100-199'. The 'bytes 100-199/*' form is also accepted. If the header is empty then -1 is
+// returned meaning the chunk is appended wherever the upload currently ends.
+func rangeStart(contentRange string) (int64, error) {
+	if contentRange == "" {
+		return -1, nil
+	}
+	rng, _, _ := strings.Cut(strings.TrimPrefix(contentRange, "bytes "), "/")
+	start, end, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, fmt.Errorf("invalid Content-Range: %q", contentRange)
+	}
+	s, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || s < 0 {
+		return 0, fmt.Errorf("invalid Content-Range: %q", contentRange)
+	}
+	if e, err := strconv.ParseInt(end, 10, 64); err != nil || e < s {
+		return 0, fmt.Errorf("invalid Content-Range: %q", contentRange)
+	}
+	return s, nil
+}
+
+// uploadError returns a RegistryError for an error from the upload package.
+func uploadError(err error) *RegistryError {
+	switch {
+	case errors.Is(err, upload.ErrUnknown):
+		return NewRegistryError(http.StatusNotFound, BlobUploadUnknown, err.Error())
+	case errors.Is(err, upload.ErrRange):
+		return NewRegistryError(http.StatusRequestedRangeNotSatisfiable, BlobUploadInvalid, err.Error())
+	case errors.Is(err, upload.ErrDigest):
+		return NewRegistryError(http.StatusBadRequest, DigestInvalid, err.Error())
+	}
+	return NewRegistryError(http.StatusInternalServerError, Unknown, err.Error())
+}
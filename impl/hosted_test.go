@@ -0,0 +1,192 @@
+package impl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/aceeric/ociregistry/api"
+	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/serialize"
+
+	"github.com/labstack/echo/v4"
+)
+
+var hostedCfg = `
+---
+imagePath: %s
+hostedConfig:
+  enabled: true
+  namespace: local.registry
+`
+
+// sha256Digest returns the digest of the passed bytes like 'sha256:...'.
+func sha256Digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Pushes an image to the hosted namespace through the Echo router - the config blob with a
+// monolithic upload and the layer with a chunked upload - then pulls it back.
+func TestPush(t *testing.T) {
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(hostedCfg, td))); err != nil {
+		t.FailNow()
+	}
+	cache.ResetCache()
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	api.RegisterHandlers(e, r)
+	RegisterRepositoryHandlers(e, r)
+	do := func(method, path string, body []byte, hdrs ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		for i := 0; i < len(hdrs); i += 2 {
+			req.Header.Set(hdrs[i], hdrs[i+1])
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	cfgBlob := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layer := []byte("0123456789abcdefghij")
+	cfgDigest, layerDigest := sha256Digest(cfgBlob), sha256Digest(layer)
+
+	// monolithic
+	rec := do(http.MethodPost, "/v2/local.registry/test/blobs/uploads/?digest="+cfgDigest, cfgBlob)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Docker-Content-Digest") != cfgDigest {
+		t.FailNow()
+	}
+	// chunked
+	rec = do(http.MethodPost, "/v2/local.registry/test/blobs/uploads/", nil)
+	if rec.Code != http.StatusAccepted {
+		t.FailNow()
+	}
+	location := rec.Header().Get("Location")
+	rec = do(http.MethodPatch, location, layer[:5], "Content-Range", "0-4")
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Range") != "0-4" {
+		t.FailNow()
+	}
+	rec = do(http.MethodPatch, location, layer[:5], "Content-Range", "0-4")
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable || errorBody(t, rec).Code != BlobUploadInvalid {
+		t.FailNow()
+	}
+	rec = do(http.MethodGet, location, nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Range") != "0-4" {
+		t.FailNow()
+	}
+	rec = do(http.MethodPut, location+"?digest="+cfgDigest, layer[5:])
+	if rec.Code != http.StatusBadRequest || errorBody(t, rec).Code != DigestInvalid {
+		t.FailNow()
+	}
+	rec = do(http.MethodPut, location+"?digest="+layerDigest, nil)
+	if rec.Code != http.StatusCreated {
+		t.FailNow()
+	}
+	// uploaded blobs can be pulled before a manifest references them
+	rec = do(http.MethodHead, "/v2/local.registry/test/blobs/"+layerDigest, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Length") != strconv.Itoa(len(layer)) {
+		t.FailNow()
+	}
+	rec = do(http.MethodGet, "/v2/local.registry/test/blobs/"+layerDigest, nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), layer) {
+		t.FailNow()
+	}
+	rec = do(http.MethodHead, "/v2/local.registry/test/blobs/"+cfgDigest, nil)
+	if rec.Code != http.StatusOK {
+		t.FailNow()
+	}
+	rec = do(http.MethodGet, location, nil)
+	if rec.Code != http.StatusNotFound || errorBody(t, rec).Code != BlobUploadUnknown {
+		t.FailNow()
+	}
+	// mount
+	rec = do(http.MethodPost, "/v2/local.registry/other/blobs/uploads/?mount="+layerDigest+"&from=test", nil)
+	if rec.Code != http.StatusCreated {
+		t.FailNow()
+	}
+
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+		`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"%s","size":%d},`+
+		`"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"%s","size":%d}]}`,
+		cfgDigest, len(cfgBlob), layerDigest, len(layer))
+	mt := "application/vnd.oci.image.manifest.v1+json"
+
+	missing := bytes.ReplaceAll([]byte(manifest), []byte(layerDigest), []byte(sha256Digest([]byte("missing"))))
+	rec = do(http.MethodPut, "/v2/local.registry/test/manifests/v1", missing, "Content-Type", mt)
+	if rec.Code != http.StatusBadRequest || errorBody(t, rec).Code != ManifestBlobUnknown {
+		t.FailNow()
+	}
+	rec = do(http.MethodPut, "/v2/local.registry/test/manifests/v1", []byte(manifest), "Content-Type", mt)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Docker-Content-Digest") != sha256Digest([]byte(manifest)) {
+		t.FailNow()
+	}
+	rec = do(http.MethodPut, "/v2/docker.io/test/manifests/v1", []byte(manifest), "Content-Type", mt)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.FailNow()
+	}
+
+	rec = do(http.MethodGet, "/v2/local.registry/test/manifests/v1", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != manifest {
+		t.FailNow()
+	}
+	rec = do(http.MethodGet, "/v2/local.registry/test/blobs/"+layerDigest, nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), layer) {
+		t.FailNow()
+	}
+	// never pulled from an upstream
+	rec = do(http.MethodGet, "/v2/local.registry/test/manifests/v2", nil)
+	if rec.Code != http.StatusNotFound || errorBody(t, rec).Code != ManifestUnknown {
+		t.FailNow()
+	}
+
+	// an uploaded blob that no manifest references expires, the pushed blobs don't
+	orphan := []byte("orphan")
+	rec = do(http.MethodPost, "/v2/local.registry/test/blobs/uploads/?digest="+sha256Digest(orphan), orphan)
+	if rec.Code != http.StatusCreated {
+		t.FailNow()
+	}
+	if cache.ExpireUploadedBlobs(td, 0) != 1 {
+		t.FailNow()
+	}
+	rec = do(http.MethodHead, "/v2/local.registry/test/blobs/"+sha256Digest(orphan), nil)
+	if rec.Code != http.StatusNotFound {
+		t.FailNow()
+	}
+	rec = do(http.MethodHead, "/v2/local.registry/test/blobs/"+layerDigest, nil)
+	if rec.Code != http.StatusOK {
+		t.FailNow()
+	}
+}
+
+func TestRangeStart(t *testing.T) {
+	for _, tst := range []struct {
+		hdr   string
+		start int64
+		ok    bool
+	}{
+		{"", -1, true},
+		{"0-99", 0, true},
+		{"100-199", 100, true},
+		{"bytes 100-199/*", 100, true},
+		{"100", 0, false},
+		{"199-100", 0, false},
+		{"a-b", 0, false},
+	} {
+		start, err := rangeStart(tst.hdr)
+		if (err == nil) != tst.ok || start != tst.start {
+			t.Errorf("%q: got %d, %v", tst.hdr, start, err)
+		}
+	}
+}
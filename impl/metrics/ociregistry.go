@@ -20,10 +20,22 @@ var DeltaCachedManifestCount delta = func(float64) {}
 var DeltaCachedBlobCount delta = func(float64) {}
 var IncV2ApiEndpointHits noLabel = func() {}
 var IncApiErrorResults noLabel = func() {}
+var IncUpstreamDeniedByNs withLabel = func(string) {}
+var IncUpstreamPullsByMirror withTwoLabels = func(string, string) {}
+var IncNegativeCacheHitsByNs withLabel = func(string) {}
+var SetBreakerStateByNs gaugeWithLabel = func(string, float64) {}
+var IncBreakerRejectionsByNs withLabel = func(string) {}
+var SetPullQueueDepthByNs gaugeWithLabel = func(string, float64) {}
+var ObservePullQueueWaitByNs observeWithLabel = func(string, float64) {}
+var SetRateLimitLimitByNs gaugeWithLabel = func(string, float64) {}
+var SetRateLimitRemainingByNs gaugeWithLabel = func(string, float64) {}
 
 type withLabel func(string)
+type withTwoLabels func(string, string)
 type noLabel func()
 type delta func(float64)
+type gaugeWithLabel func(string, float64)
+type observeWithLabel func(string, float64)
 
 // "ns" below refers to the upstream namespace, like "docker.io" or "ghcr.io"
 const (
@@ -37,7 +49,17 @@ const (
 	cached_blob_count            = "cached_blob_count"
 	v2_api_endpoint_hits_total   = "v2_api_endpoint_hits_total"
 	api_errors_total             = "api_errors_total"
+	upstream_denied_by_ns_total  = "upstream_denied_by_ns_total"
+	upstream_pulls_by_mirror     = "upstream_pulls_by_mirror"
+	negative_cache_hits_by_ns    = "negative_cache_hits_by_ns_total"
+	breaker_state_by_ns          = "breaker_state_by_ns"
+	breaker_rejections_by_ns     = "breaker_rejections_by_ns_total"
+	pull_queue_depth_by_ns       = "pull_queue_depth_by_ns"
+	pull_queue_wait_by_ns        = "pull_queue_wait_seconds_by_ns"
+	rate_limit_limit_by_ns       = "rate_limit_limit_by_ns"
+	rate_limit_remaining_by_ns   = "rate_limit_remaining_by_ns"
 	ns_label                     = "ns"
+	mirror_label                 = "mirror"
 )
 
 // Prometheus metrics objects
@@ -52,6 +74,15 @@ var cachedManifestCount prometheus.Gauge
 var cachedBlobCount prometheus.Gauge
 var v2ApiEndpointHitsTotal prometheus.Counter
 var apiErrorsTotal prometheus.Counter
+var upstreamDeniedByNsTotal *prometheus.CounterVec
+var upstreamPullsByMirrorTotal *prometheus.CounterVec
+var negativeCacheHitsByNsTotal *prometheus.CounterVec
+var breakerStateByNs *prometheus.GaugeVec
+var breakerRejectionsByNsTotal *prometheus.CounterVec
+var pullQueueDepthByNs *prometheus.GaugeVec
+var pullQueueWaitByNs *prometheus.HistogramVec
+var rateLimitLimitByNs *prometheus.GaugeVec
+var rateLimitRemainingByNs *prometheus.GaugeVec
 
 // addOciregistryMetrics creates all the ociregistry metrics and registers them with the
 // prometheus library. It also assigns a function to actually implement the metric.
@@ -178,4 +209,122 @@ func addOciregistryMetrics() {
 	IncApiErrorResults = func() {
 		apiErrorsTotal.Add(1)
 	}
+
+	///
+	upstreamDeniedByNsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      upstream_denied_by_ns_total,
+			Namespace: "ociregistry",
+			Help:      "Total pulls of un-cached images refused because the upstream configuration doesn't allow the upstream, by namespace",
+		},
+		[]string{ns_label},
+	)
+	IncUpstreamDeniedByNs = func(ns string) {
+		upstreamDeniedByNsTotal.With(prometheus.Labels{ns_label: ns}).Add(1)
+	}
+
+	///
+	upstreamPullsByMirrorTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      upstream_pulls_by_mirror,
+			Namespace: "ociregistry",
+			Help:      "Total pulls of un-cached images by namespace and the mirror - or registry - that served the pull",
+		},
+		[]string{ns_label, mirror_label},
+	)
+	IncUpstreamPullsByMirror = func(ns string, mirror string) {
+		upstreamPullsByMirrorTotal.With(prometheus.Labels{ns_label: ns, mirror_label: mirror}).Add(1)
+	}
+
+	///
+	negativeCacheHitsByNsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      negative_cache_hits_by_ns,
+			Namespace: "ociregistry",
+			Help:      "Total pulls of un-cached images answered from the negative cache without contacting the upstream, by namespace",
+		},
+		[]string{ns_label},
+	)
+	IncNegativeCacheHitsByNs = func(ns string) {
+		negativeCacheHitsByNsTotal.With(prometheus.Labels{ns_label: ns}).Add(1)
+	}
+
+	///
+	breakerStateByNs = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:      breaker_state_by_ns,
+			Namespace: "ociregistry",
+			Help:      "Circuit breaker state by namespace: 0 = closed, 1 = half-open, 2 = open",
+		},
+		[]string{ns_label},
+	)
+	SetBreakerStateByNs = func(ns string, state float64) {
+		breakerStateByNs.With(prometheus.Labels{ns_label: ns}).Set(state)
+	}
+
+	///
+	breakerRejectionsByNsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      breaker_rejections_by_ns,
+			Namespace: "ociregistry",
+			Help:      "Total pulls of un-cached images that failed fast because the circuit breaker for the namespace was open",
+		},
+		[]string{ns_label},
+	)
+	IncBreakerRejectionsByNs = func(ns string) {
+		breakerRejectionsByNsTotal.With(prometheus.Labels{ns_label: ns}).Add(1)
+	}
+
+	///
+	pullQueueDepthByNs = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:      pull_queue_depth_by_ns,
+			Namespace: "ociregistry",
+			Help:      "Number of pulls waiting for the concurrent pull limits by namespace",
+		},
+		[]string{ns_label},
+	)
+	SetPullQueueDepthByNs = func(ns string, depth float64) {
+		pullQueueDepthByNs.With(prometheus.Labels{ns_label: ns}).Set(depth)
+	}
+
+	///
+	pullQueueWaitByNs = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:      pull_queue_wait_by_ns,
+			Namespace: "ociregistry",
+			Help:      "Seconds that pulls waited for the concurrent pull limits by namespace",
+			Buckets:   []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60},
+		},
+		[]string{ns_label},
+	)
+	ObservePullQueueWaitByNs = func(ns string, seconds float64) {
+		pullQueueWaitByNs.With(prometheus.Labels{ns_label: ns}).Observe(seconds)
+	}
+
+	///
+	rateLimitLimitByNs = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:      rate_limit_limit_by_ns,
+			Namespace: "ociregistry",
+			Help:      "Pull quota of the upstream from the last RateLimit-Limit header by namespace",
+		},
+		[]string{ns_label},
+	)
+	SetRateLimitLimitByNs = func(ns string, limit float64) {
+		rateLimitLimitByNs.With(prometheus.Labels{ns_label: ns}).Set(limit)
+	}
+
+	///
+	rateLimitRemainingByNs = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:      rate_limit_remaining_by_ns,
+			Namespace: "ociregistry",
+			Help:      "Remaining pull quota of the upstream from the last RateLimit-Remaining header by namespace",
+		},
+		[]string{ns_label},
+	)
+	SetRateLimitRemainingByNs = func(ns string, remaining float64) {
+		rateLimitRemainingByNs.With(prometheus.Labels{ns_label: ns}).Set(remaining)
+	}
 }
@@ -0,0 +1,113 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+
+	"github.com/aceeric/imgpull/pkg/imgpull"
+	log "github.com/sirupsen/logrus"
+)
+
+// equivalentMediaTypes groups the Docker v2 and OCI manifest media types that are
+// interchangeable from the perspective of a client: a manifest list and an image index
+// have the same structure, as do a Docker v2 image manifest and an OCI image manifest.
+var equivalentMediaTypes = [][]string{
+	{"application/vnd.docker.distribution.manifest.list.v2+json", "application/vnd.oci.image.index.v1+json"},
+	{"application/vnd.docker.distribution.manifest.v2+json", "application/vnd.oci.image.manifest.v1+json"},
+}
+
+// parseAccept parses the passed Accept header values into a list of media types. Media type
+// parameters are removed, and media types with a quality of zero are excluded since that
+// means "not acceptable". An empty list means the client accepts anything.
+func parseAccept(hdrs []string) []string {
+	accepts := []string{}
+	for _, hdr := range hdrs {
+		for mt := range strings.SplitSeq(hdr, ",") {
+			mt, params, _ := strings.Cut(mt, ";")
+			if mt = strings.ToLower(strings.TrimSpace(mt)); mt == "" {
+				continue
+			}
+			if notAcceptable(params) {
+				continue
+			}
+			accepts = append(accepts, mt)
+		}
+	}
+	return accepts
+}
+
+// notAcceptable returns true if the passed media type parameters - like 'charset=x; q=0' - have
+// a quality of zero. The quality can be any of the parameters.
+func notAcceptable(params string) bool {
+	for param := range strings.SplitSeq(params, ";") {
+		key, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(strings.TrimSpace(key), "q") {
+			return strings.Trim(strings.TrimSpace(value), "0.") == ""
+		}
+	}
+	return false
+}
+
+// acceptable returns true if the passed media type is in the list of accepted media types,
+// either directly or by equivalence. An empty list, or a wildcard, accepts everything.
+func acceptable(accepts []string, mediaType string) bool {
+	if len(accepts) == 0 || slices.Contains(accepts, "*/*") || slices.Contains(accepts, mediaType) {
+		return true
+	}
+	for _, group := range equivalentMediaTypes {
+		if slices.Contains(group, mediaType) && slices.ContainsFunc(accepts, func(mt string) bool { return slices.Contains(group, mt) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate is called when the client does not accept the media type of the passed manifest.
+// If the manifest is a manifest list that was requested by tag then the image manifest for
+// the configured platform is returned from the cache - pulling it if needed. Otherwise the
+// request can't be satisfied and an error is returned: 404 if the request was by tag, and 406
+// if the request was by digest since a digest can only ever match one media type.
+func (r *OciRegistry) negotiate(ctx context.Context, pr pullrequest.PullRequest, mh imgpull.ManifestHolder, accepts []string) (imgpull.ManifestHolder, *RegistryError) {
+	notAcceptable := func() *RegistryError {
+		msg := fmt.Sprintf("manifest %q has media type %q which is not accepted by the client", pr.Url(), mh.MediaType())
+		if pr.PullType == pullrequest.ByDigest {
+			return NewRegistryError(http.StatusNotAcceptable, Unsupported, msg).WithDetail(accepts)
+		}
+		return NewRegistryError(http.StatusNotFound, ManifestUnknown, msg).WithDetail(accepts)
+	}
+	if pr.PullType != pullrequest.ByTag || !mh.IsManifestList() {
+		return mh, notAcceptable()
+	}
+	digest, err := mh.GetImageDigestFor(r.osType, r.archType)
+	if err != nil {
+		log.Debugf("no image manifest for %s/%s in %q: %s", r.osType, r.archType, pr.Url(), err)
+		return mh, notAcceptable()
+	}
+	ipr := pullrequest.PullRequest{
+		PullType:   pullrequest.ByDigest,
+		Remote:     pr.Remote,
+		Repository: pr.Repository,
+		Reference:  digest,
+	}
+	if r.offline(ipr) && !cache.IsCached(ipr) {
+		return mh, NewRegistryError(http.StatusNotFound, ManifestUnknown, "manifest unknown").WithDetail(ipr.Url())
+	}
+	if !r.offline(ipr) && !r.upstreamAllowed(ipr) && !cache.IsCached(ipr) {
+		return mh, upstreamDenied(ipr)
+	}
+	imh, err := cache.GetManifest(ctx, ipr, r.imagePath, r.pullTimeout, false)
+	if err != nil {
+		return mh, fromUpstreamError(err, ManifestUnknown)
+	}
+	if !acceptable(accepts, imh.MediaType()) {
+		return mh, notAcceptable()
+	}
+	log.Debugf("client does not accept manifest list %q, serving image manifest %q", pr.Url(), ipr.Url())
+	return imh, nil
+}
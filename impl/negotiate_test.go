@@ -0,0 +1,108 @@
+package impl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/mock"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestParseAccept(t *testing.T) {
+	hdrs := []string{
+		"application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json;q=0.9",
+		"application/vnd.oci.image.index.v1+json; q=0",
+		"application/vnd.docker.distribution.manifest.list.v2+json;charset=utf-8;q=0.0",
+	}
+	accepts := parseAccept(hdrs)
+	if strings.Join(accepts, ",") != "application/vnd.oci.image.manifest.v1+json,application/vnd.docker.distribution.manifest.v2+json" {
+		t.Fail()
+	}
+	if len(parseAccept(nil)) != 0 {
+		t.Fail()
+	}
+}
+
+func TestAcceptable(t *testing.T) {
+	tests := []struct {
+		accepts   []string
+		mediaType string
+		expect    bool
+	}{
+		{[]string{}, "application/vnd.oci.image.index.v1+json", true},
+		{[]string{"*/*"}, "application/vnd.oci.image.index.v1+json", true},
+		{[]string{"application/vnd.oci.image.index.v1+json"}, "application/vnd.oci.image.index.v1+json", true},
+		{[]string{"application/vnd.oci.image.index.v1+json"}, "application/vnd.docker.distribution.manifest.list.v2+json", true},
+		{[]string{"application/vnd.docker.distribution.manifest.v2+json"}, "application/vnd.oci.image.manifest.v1+json", true},
+		{[]string{"application/vnd.oci.image.manifest.v1+json"}, "application/vnd.oci.image.index.v1+json", false},
+		{[]string{"application/vnd.docker.distribution.manifest.list.v2+json"}, "application/vnd.oci.image.manifest.v1+json", false},
+	}
+	for _, test := range tests {
+		if acceptable(test.accepts, test.mediaType) != test.expect {
+			t.Fail()
+		}
+	}
+}
+
+// Requests the mock server's image index by tag and by digest with different Accept
+// headers.
+func TestNegotiate(t *testing.T) {
+	cache.ResetCache()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fail()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	cfg := fmt.Sprintf(serverCfg+"os: linux\narch: amd64\n", td, 1000, false, url)
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.Fail()
+	}
+	defer server.Close()
+
+	imageDigest := "sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57"
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	get := func(ref string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		r.handleV2ManifestsReference(e.NewContext(req, rec), ref, &url, http.MethodGet, "hello-world")
+		return rec
+	}
+	tests := []struct {
+		ref       string
+		accept    string
+		status    int
+		mediaType string
+	}{
+		{"latest", "application/vnd.oci.image.index.v1+json", 200, "application/vnd.oci.image.index.v1+json"},
+		{"latest", "application/vnd.docker.distribution.manifest.list.v2+json", 200, "application/vnd.oci.image.index.v1+json"},
+		{"latest", "application/vnd.oci.image.manifest.v1+json", 200, "application/vnd.oci.image.manifest.v1+json"},
+		{"latest", "application/vnd.docker.distribution.manifest.v2+json", 200, "application/vnd.oci.image.manifest.v1+json"},
+		{"latest", "application/json", 404, ""},
+		{imageDigest, "application/vnd.oci.image.index.v1+json", 406, ""},
+		{imageDigest, "", 200, "application/vnd.oci.image.manifest.v1+json"},
+	}
+	for _, test := range tests {
+		rec := get(test.ref, test.accept)
+		if rec.Code != test.status {
+			t.Errorf("ref %s accept %s expected %d got %d", test.ref, test.accept, test.status, rec.Code)
+		}
+		if test.status == 200 && rec.Header().Get("Content-Type") != test.mediaType {
+			t.Fail()
+		}
+		if test.mediaType == "application/vnd.oci.image.manifest.v1+json" && rec.Header().Get("Docker-Content-Digest") != imageDigest {
+			t.Fail()
+		}
+	}
+}
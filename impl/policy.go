@@ -0,0 +1,266 @@
+package impl
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/helpers"
+	"github.com/aceeric/ociregistry/impl/metrics"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/serverauth"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/labstack/echo/v4"
+)
+
+// anonymous is the identity that policy rules match for a client that isn't identified.
+const anonymous = "anonymous"
+
+// canPull returns nil if the policy allows the client to pull from the passed repository, else
+// an error to send to the client. The repository includes the upstream, e.g.
+// 'docker.io/library/hello-world'. Every decision is logged.
+func (r *OciRegistry) canPull(ctx echo.Context, repository string) *RegistryError {
+	if !r.policy.Enabled {
+		return nil
+	}
+	identity := helpers.ValueOr(clientIdentity(ctx), anonymous)
+	allowed, reason := policyAllows(r.policy.Rules, identity, repository)
+	if !allowed {
+		log.Warnf("policy denied pull of %q by %q from %s: %s", repository, identity, ctx.Request().RemoteAddr, reason)
+		return NewRegistryError(http.StatusForbidden, Denied, "requested access to the resource is denied").WithDetail(repository)
+	}
+	log.Infof("policy allowed pull of %q by %q: %s", repository, identity, reason)
+	return nil
+}
+
+// canPush returns nil if the policy allows the client to push to - or delete from - the passed
+// repository, else an error to send to the client. Every decision is logged.
+func (r *OciRegistry) canPush(ctx echo.Context, repository string) *RegistryError {
+	if !r.policy.Enabled {
+		return nil
+	}
+	identity := helpers.ValueOr(clientIdentity(ctx), anonymous)
+	allowed, reason := policyAllowsPush(r.policy.Rules, identity, repository)
+	if !allowed {
+		log.Warnf("policy denied push of %q by %q from %s: %s", repository, identity, ctx.Request().RemoteAddr, reason)
+		return NewRegistryError(http.StatusForbidden, Denied, "requested access to the resource is denied").WithDetail(repository)
+	}
+	log.Infof("policy allowed push of %q by %q: %s", repository, identity, reason)
+	return nil
+}
+
+// grantable returns the passed token access with only the actions that the policy allows the
+// passed identity. A pull is decided by the allow patterns, and a push or a delete by the push
+// patterns. The '*' action needs both. Access to the registry - e.g. the catalog - is returned
+// as is since the catalog is filtered by the policy. Every denied action is logged.
+func (r *OciRegistry) grantable(ctx echo.Context, identity string, access []serverauth.Access) []serverauth.Access {
+	if !r.policy.Enabled {
+		return access
+	}
+	granted := []serverauth.Access{}
+	for _, a := range access {
+		if a.Type != "repository" {
+			granted = append(granted, a)
+			continue
+		}
+		repository := r.repositoryFor(ctx, strings.Split(a.Name, "/"))
+		actions := slices.DeleteFunc(slices.Clone(a.Actions), func(action string) bool {
+			allowed, reason := true, ""
+			if action == "pull" || action == "*" {
+				allowed, reason = policyAllows(r.policy.Rules, identity, repository)
+			}
+			if allowed && action != "pull" {
+				allowed, reason = policyAllowsPush(r.policy.Rules, identity, repository)
+			}
+			if !allowed {
+				log.Warnf("policy denied %s of %q in a token for %q from %s: %s", action, repository, identity, ctx.Request().RemoteAddr, reason)
+			}
+			return !allowed
+		})
+		if len(actions) != 0 {
+			granted = append(granted, serverauth.Access{Type: a.Type, Name: a.Name, Actions: actions})
+		}
+	}
+	return granted
+}
+
+// canPullBlob returns nil if the policy allows the client to pull the passed blob from the passed
+// repository, else the error to send to the client. Since the client chooses the repository in the
+// request path, the client must also be allowed to pull from one of the repositories whose cached
+// manifests reference the blob - so the blobs of a denied repository can't be pulled through an
+// allowed one. A blob that no cached manifest references - like a blob uploaded to the hosted
+// namespace before its manifest is pushed - is only checked against the passed repository.
+func (r *OciRegistry) canPullBlob(ctx echo.Context, repository string, digest string) *RegistryError {
+	if re := r.canPull(ctx, repository); re != nil || !r.policy.Enabled {
+		return re
+	}
+	repos := cache.BlobRepositories(digest)
+	if len(repos) == 0 {
+		return nil
+	}
+	identity := helpers.ValueOr(clientIdentity(ctx), anonymous)
+	for _, repo := range repos {
+		if allowed, _ := policyAllows(r.policy.Rules, identity, repo); allowed {
+			return nil
+		}
+	}
+	log.Warnf("policy denied pull of blob %q by %q from %s: no allowed repository references the blob", digest, identity, ctx.Request().RemoteAddr)
+	return NewRegistryError(http.StatusForbidden, Denied, "requested access to the resource is denied").WithDetail(repository)
+}
+
+// pullable returns the passed repositories that the policy allows the client to pull from. The
+// passed slice is modified.
+func (r *OciRegistry) pullable(ctx echo.Context, repositories []string) []string {
+	if !r.policy.Enabled {
+		return repositories
+	}
+	identity := helpers.ValueOr(clientIdentity(ctx), anonymous)
+	return slices.DeleteFunc(repositories, func(repository string) bool {
+		allowed, _ := policyAllows(r.policy.Rules, identity, repository)
+		return !allowed
+	})
+}
+
+// repositoryFor returns the repository including the upstream for the passed repository name
+// segments, resolving the upstream the same way as for a manifest pull. If the upstream can't
+// be resolved then the name is returned as is.
+func (r *OciRegistry) repositoryFor(ctx echo.Context, repoSegments []string) string {
+	pr, err := pullrequest.NewPullRequest(r.x_registry_hdr(ctx), queryParam(ctx, "ns"), r.defaultNs, "", repoSegments...)
+	if err != nil {
+		return strings.Join(repoSegments, "/")
+	}
+	return pr.Remote + "/" + pr.Repository
+}
+
+// upstreamAllowed returns true if the upstream configuration allows pulling the passed
+// PullRequest from the upstream.
+func (r *OciRegistry) upstreamAllowed(pr pullrequest.PullRequest) bool {
+	repository := pr.Remote + "/" + pr.Repository
+	if _, denied := matching(r.upstreams.Deny, repository); denied {
+		return false
+	}
+	if len(r.upstreams.Allow) == 0 {
+		return true
+	}
+	_, allowed := matching(r.upstreams.Allow, repository)
+	return allowed
+}
+
+// upstreamDenied logs and counts a cache miss for a PullRequest that the upstream configuration
+// doesn't allow to be pulled, and returns the error to send to the client.
+func upstreamDenied(pr pullrequest.PullRequest) *RegistryError {
+	log.Warnf("un-cached manifest %q not pulled: the upstream is not allowed", pr.Url())
+	metrics.IncUpstreamDeniedByNs(pr.Remote)
+	metrics.IncApiErrorResults()
+	return NewRegistryError(http.StatusForbidden, Denied, "pulling from the upstream is not allowed").WithDetail(pr.Url())
+}
+
+// AuthorizeCmd is Echo middleware that checks the policy for the /cmd endpoints. Only the
+// identities that match the policy 'cmd' patterns can call them. Every decision is logged.
+// If the policy is not enabled then the middleware does nothing.
+func (r *OciRegistry) AuthorizeCmd(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		path := ctx.Request().URL.Path
+		if !r.policy.Enabled || !strings.HasPrefix(path, "/cmd/") {
+			return next(ctx)
+		}
+		identity := helpers.ValueOr(clientIdentity(ctx), anonymous)
+		pattern, allowed := matching(r.policy.Cmd, identity)
+		if !allowed {
+			log.Warnf("policy denied %s to %q from %s: no matching cmd pattern", path, identity, ctx.Request().RemoteAddr)
+			return NewRegistryError(http.StatusForbidden, Denied, "requested access to the resource is denied").WithDetail(path).Send(ctx)
+		}
+		log.Infof("policy allowed %s to %q: cmd pattern %q", path, identity, pattern)
+		return next(ctx)
+	}
+}
+
+// policyAllows evaluates the passed rules for a pull by the passed identity from the passed
+// repository - see 'evaluatePolicy'.
+func policyAllows(rules []config.PolicyRule, identity string, repository string) (bool, string) {
+	return evaluatePolicy(rules, identity, repository, "allow", func(rule config.PolicyRule) []string {
+		return rule.Allow
+	})
+}
+
+// policyAllowsPush evaluates the passed rules for a push or a delete by the passed identity to
+// the passed repository - see 'evaluatePolicy'.
+func policyAllowsPush(rules []config.PolicyRule, identity string, repository string) (bool, string) {
+	return evaluatePolicy(rules, identity, repository, "push", func(rule config.PolicyRule) []string {
+		return rule.Push
+	})
+}
+
+// evaluatePolicy evaluates the passed rules for the passed identity and repository. Only the rules
+// whose identity patterns match the identity are considered. A matching deny pattern in any of
+// those rules takes precedence over the patterns that the passed function returns from a rule -
+// which are named by the passed kind - and a repository that none of those patterns matches is
+// denied. The reason for the decision is returned for logging.
+func evaluatePolicy(rules []config.PolicyRule, identity string, repository string, kind string, patterns func(config.PolicyRule) []string) (bool, string) {
+	allowed, reason := false, fmt.Sprintf("no matching %s pattern", kind)
+	for i, rule := range rules {
+		if _, ok := matching(rule.Identities, identity); !ok {
+			continue
+		}
+		if pattern, ok := matching(rule.Deny, repository); ok {
+			return false, fmt.Sprintf("rule %d deny pattern %q", i+1, pattern)
+		}
+		if pattern, ok := matching(patterns(rule), repository); ok && !allowed {
+			allowed, reason = true, fmt.Sprintf("rule %d %s pattern %q", i+1, kind, pattern)
+		}
+	}
+	return allowed, reason
+}
+
+// matching returns the first of the passed glob patterns that matches the passed string, and
+// true, or false if none match.
+func matching(patterns []string, s string) (string, bool) {
+	for _, pattern := range patterns {
+		if globMatch(pattern, s) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// globMatch returns true if the passed string matches the passed glob pattern. A '*' matches
+// any sequence of characters - including '/' so 'docker.io/*' matches every repository from
+// docker.io - and a '?' matches any one character.
+func globMatch(pattern string, s string) bool {
+	px, sx := 0, 0
+	nextPx, nextSx := 0, 0
+	for px < len(pattern) || sx < len(s) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				// try to match at sx, and if that fails then at sx+1 and so on
+				nextPx, nextSx = px, sx+1
+				px++
+				continue
+			case '?':
+				if sx < len(s) {
+					px++
+					sx++
+					continue
+				}
+			default:
+				if sx < len(s) && s[sx] == c {
+					px++
+					sx++
+					continue
+				}
+			}
+		}
+		if 0 < nextSx && nextSx <= len(s) {
+			px, sx = nextPx, nextSx
+			continue
+		}
+		return false
+	}
+	return true
+}
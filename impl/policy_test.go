@@ -0,0 +1,265 @@
+package impl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aceeric/ociregistry/api"
+	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/impl/serverauth"
+	"github.com/aceeric/ociregistry/mock"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var policyCfg = `
+policy:
+  enabled: true
+  cmd:
+  - admin
+  rules:
+  - identities: ["*"]
+    allow: [local.registry/public/*]
+  - identities: [dev-*]
+    allow: [local.registry/*]
+    push: [local.registry/team/*]
+    deny: [local.registry/*/secret]
+  - identities: [ops]
+    allow: [local.registry/*]
+  - identities: [admin]
+    push: [local.registry/*]
+`
+
+func TestGlobMatch(t *testing.T) {
+	for _, tst := range []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "docker.io/library/hello-world", true},
+		{"docker.io/*", "docker.io/library/hello-world", true},
+		{"docker.io/*", "quay.io/library/hello-world", false},
+		{"*/hello-world", "docker.io/library/hello-world", true},
+		{"docker.io/*/hello-*", "docker.io/library/hello-world", true},
+		{"docker.io/library/hello-world", "docker.io/library/hello-world", true},
+		{"docker.io/library/hello-world", "docker.io/library/hello-worlds", false},
+		{"dev-?", "dev-1", true},
+		{"dev-?", "dev-12", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+	} {
+		if globMatch(tst.pattern, tst.s) != tst.match {
+			t.Errorf("%q %q: expected %t", tst.pattern, tst.s, tst.match)
+		}
+	}
+}
+
+// Pushes to and pulls from the hosted namespace as different basic auth users and checks the
+// policy decisions for the manifest, blob, upload, and /cmd endpoints, and for the actions that
+// a token grants.
+func TestPolicy(t *testing.T) {
+	defer serverauth.Init(config.ServerAuthCfg{})
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(hostedCfg, td) + policyCfg)); err != nil {
+		t.FailNow()
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("xyzzy"), bcrypt.MinCost)
+	if err != nil {
+		t.FailNow()
+	}
+	users := []config.ServerUserCfg{}
+	for _, user := range []string{"admin", "dev-1", "ops", "guest"} {
+		users = append(users, config.ServerUserCfg{Name: user, Password: string(hash)})
+	}
+	if err := serverauth.Init(config.ServerAuthCfg{Mode: "basic", Users: users}); err != nil {
+		t.FailNow()
+	}
+	cache.ResetCache()
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(Authenticate)
+	e.Use(r.AuthorizeCmd)
+	api.RegisterHandlers(e, r)
+	RegisterRepositoryHandlers(e, r)
+	do := func(user, method, path string, body []byte, hdrs ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":xyzzy")))
+		for i := 0; i < len(hdrs); i += 2 {
+			req.Header.Set(hdrs[i], hdrs[i+1])
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	// push an image to a repository that dev-1 is denied
+	layer := []byte("secret")
+	layerDigest := sha256Digest(layer)
+	if rec := do("admin", http.MethodPost, "/v2/local.registry/team/secret/blobs/uploads/?digest="+layerDigest, layer); rec.Code != http.StatusCreated {
+		t.FailNow()
+	}
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+		`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"%s","size":%d},"layers":[]}`,
+		layerDigest, len(layer))
+	if rec := do("admin", http.MethodPut, "/v2/local.registry/team/secret/manifests/v1", []byte(manifest),
+		"Content-Type", "application/vnd.oci.image.manifest.v1+json"); rec.Code != http.StatusCreated {
+		t.FailNow()
+	}
+	manifestDigest := sha256Digest([]byte(manifest))
+	digest := "sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57"
+	for _, tst := range []struct {
+		user   string
+		path   string
+		status int
+	}{
+		{"guest", "/v2/local.registry/public/hello-world/manifests/v1", http.StatusNotFound},
+		{"guest", "/v2/local.registry/team/hello-world/manifests/v1", http.StatusForbidden},
+		{"guest", "/v2/local.registry/team/hello-world/blobs/" + digest, http.StatusForbidden},
+		{"dev-1", "/v2/local.registry/team/hello-world/manifests/v1", http.StatusNotFound},
+		{"dev-1", "/v2/local.registry/team/hello-world/blobs/" + digest, http.StatusNotFound},
+		{"dev-1", "/v2/local.registry/team/secret/manifests/v1", http.StatusForbidden},
+		{"dev-1", "/v2/local.registry/team/hello-world/blobs/" + layerDigest, http.StatusForbidden},
+		{"dev-1", "/v2/local.registry/team/secret/tags/list", http.StatusForbidden},
+		{"dev-1", "/v2/local.registry/team/secret/referrers/" + manifestDigest, http.StatusForbidden},
+		{"ops", "/v2/local.registry/team/app/blobs/" + layerDigest, http.StatusOK},
+		{"dev-1", "/v2/team/hello-world/manifests/v1?ns=docker.io", http.StatusForbidden},
+		{"admin", "/v2/local.registry/team/hello-world/manifests/v1", http.StatusForbidden},
+		{"admin", "/cmd/manifest/list", http.StatusOK},
+		{"dev-1", "/cmd/manifest/list", http.StatusForbidden},
+	} {
+		rec := do(tst.user, http.MethodGet, tst.path, nil)
+		if rec.Code != tst.status {
+			t.Errorf("%s %s: expected %d, got %d", tst.user, tst.path, tst.status, rec.Code)
+		} else if tst.status == http.StatusForbidden && errorBody(t, rec).Code != Denied {
+			t.Errorf("%s %s: expected %s", tst.user, tst.path, Denied)
+		}
+	}
+	// the catalog only lists the repositories the client can pull from
+	for user, expected := range map[string]string{"dev-1": `{"repositories":[]}`, "ops": `{"repositories":["local.registry/team/secret"]}`} {
+		if rec := do(user, http.MethodGet, "/v2/_catalog", nil); strings.TrimSpace(rec.Body.String()) != expected {
+			t.Errorf("%s: unexpected catalog %s", user, rec.Body.String())
+		}
+	}
+	// pushes need a push pattern, the deny patterns apply to pushes, and a blob is only mounted
+	// if the client could pull it
+	for _, tst := range []struct {
+		user   string
+		path   string
+		status int
+	}{
+		{"guest", "/v2/local.registry/public/app/blobs/uploads/?digest=" + layerDigest, http.StatusForbidden},
+		{"ops", "/v2/local.registry/team/app/blobs/uploads/?digest=" + layerDigest, http.StatusForbidden},
+		{"dev-1", "/v2/local.registry/team/secret/blobs/uploads/?digest=" + layerDigest, http.StatusForbidden},
+		{"dev-1", "/v2/local.registry/team/app/blobs/uploads/?mount=" + layerDigest, http.StatusAccepted},
+		{"dev-1", "/v2/local.registry/team/app/blobs/uploads/?digest=" + layerDigest, http.StatusCreated},
+	} {
+		if rec := do(tst.user, http.MethodPost, tst.path, layer); rec.Code != tst.status {
+			t.Errorf("%s %s: expected %d, got %d", tst.user, tst.path, tst.status, rec.Code)
+		}
+	}
+	if rec := do("dev-1", http.MethodPut, "/v2/local.registry/team/secret/manifests/v2", []byte(manifest),
+		"Content-Type", "application/vnd.oci.image.manifest.v1+json"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected the push of a denied manifest to be forbidden, got %d", rec.Code)
+	}
+	// a delete is a push, and the repository honours the ns query param
+	r.deletes = config.DeleteConfig{Enabled: true, Clients: []string{"192.0.2.1"}}
+	for _, tst := range []struct {
+		user   string
+		status int
+	}{
+		{"dev-1", http.StatusForbidden},
+		{"admin", http.StatusAccepted},
+	} {
+		if rec := do(tst.user, http.MethodDelete, "/v2/team/secret/manifests/v1?ns=local.registry", nil); rec.Code != tst.status {
+			t.Errorf("%s: expected %d, got %d", tst.user, tst.status, rec.Code)
+		}
+	}
+	// a token only grants the actions the policy allows
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/v2/auth", nil), httptest.NewRecorder())
+	granted := r.grantable(ctx, "dev-1", []serverauth.Access{
+		{Type: "repository", Name: "local.registry/team/secret", Actions: []string{"pull", "push"}},
+		{Type: "repository", Name: "local.registry/team/app", Actions: []string{"pull", "push", "delete"}},
+		{Type: "repository", Name: "local.registry/public/app", Actions: []string{"pull", "*"}},
+		{Type: "registry", Name: "catalog", Actions: []string{"*"}},
+	})
+	if fmt.Sprint(granted) != "[{repository local.registry/team/app [pull push delete]} {repository local.registry/public/app [pull]} {registry catalog [*]}]" {
+		t.Errorf("unexpected token access %v", granted)
+	}
+}
+
+// Checks that a cache miss for an upstream that is not allowed is denied without contacting
+// the upstream, while cached manifests are still served.
+func TestUpstreamConfig(t *testing.T) {
+	cache.ResetCache()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	var pulls atomic.Int32
+	callback := func(string) {
+		pulls.Add(1)
+	}
+	server, url := mock.ServerWithCallback(mock.NewMockParams(mock.NONE, mock.HTTP), &callback)
+	defer server.Close()
+	cfg := fmt.Sprintf(serverCfg, td, 1000, false, url) + "upstreamConfig:\n  allow: [\"" + url + "/hello-*\"]\n"
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.FailNow()
+	}
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	get := func(digest string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		r.handleV2ManifestsReference(ctx, digest, &url, http.MethodGet, "hello-world")
+		return rec
+	}
+	imageDigest := "sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57"
+	listDigest := "sha256:e4ccfd825622441dcee5123f9d4a48b2eb8787d858de346106a83f0c745cc255"
+	if rec := get(imageDigest); rec.Code != http.StatusOK {
+		t.FailNow()
+	}
+	r.upstreams = config.UpstreamConfig{Deny: []string{url + "/*"}}
+	before := pulls.Load()
+	if rec := get(imageDigest); rec.Code != http.StatusOK {
+		t.FailNow()
+	}
+	if rec := get(listDigest); rec.Code != http.StatusForbidden || errorBody(t, rec).Code != Denied {
+		t.FailNow()
+	}
+	if pulls.Load() != before {
+		t.Errorf("expected no upstream calls, got %d", pulls.Load()-before)
+	}
+	for _, tst := range []struct {
+		upstreams config.UpstreamConfig
+		allowed   bool
+	}{
+		{config.UpstreamConfig{}, true},
+		{config.UpstreamConfig{Allow: []string{url + "/*"}}, true},
+		{config.UpstreamConfig{Allow: []string{"docker.io/*"}}, false},
+		{config.UpstreamConfig{Allow: []string{url + "/*"}, Deny: []string{"*/hello-world"}}, false},
+	} {
+		r.upstreams = tst.upstreams
+		if r.upstreamAllowed(pullrequest.PullRequest{Remote: url, Repository: "hello-world"}) != tst.allowed {
+			t.Errorf("%+v: expected %t", tst.upstreams, tst.allowed)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package preload
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,8 +12,10 @@ import (
 
 	"github.com/aceeric/ociregistry/impl/config"
 	"github.com/aceeric/ociregistry/impl/globals"
+	"github.com/aceeric/ociregistry/impl/helpers"
 	"github.com/aceeric/ociregistry/impl/pullrequest"
 	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/impl/upstream"
 
 	"github.com/aceeric/imgpull/pkg/imgpull"
 	log "github.com/sirupsen/logrus"
@@ -26,10 +30,11 @@ import (
 //
 //	registry.k8s.io/metrics-server/metrics-server:v0.6.2
 //
-// If the manifest for a url in the file is an image list, then the architecture and
-// OS from configuration (args or config file) are used to select an image from the image
-// list manifest, which is also downloaded. So each url in the file can pull either an image,
-// or a manifest list and  an image. IMPORTANT: each item in the list MUST begin with a remote
+// If the manifest for a url in the file is an image list, then the platforms configured for
+// the registry - or, if no platforms are configured, the architecture and OS from configuration
+// (args or config file) - are used to select images from the image list manifest, which are also
+// downloaded. So each url in the file can pull either an image, or a manifest list and one or
+// more images. IMPORTANT: each item in the list MUST begin with a remote
 // registry ref - i.e. to the left of the first forward slash (docker.io is not inferred.)
 func LoadFromListFile(imageListFile string) error {
 	imagePath := config.GetImagePath()
@@ -65,20 +70,67 @@ func LoadFromListFile(imageListFile string) error {
 }
 
 // doPull pulls the passed url from the upstream registry ref'd by the url. If a manifest list
-// comes back from the upstream then an image is also pulled. A return value of 1 means the passed
-// url got an image. A return value of 2 means the passed url got an image list, and so an image
-// for the passed OS and architecture was also pulled.
+// comes back from the upstream then an image is also pulled for each platform configured for
+// the registry, or for the passed OS and architecture if no platforms are configured. The return
+// value is the count of manifests pulled: 1 means the passed url got an image, more than 1 means
+// the passed url got an image list, and so the images for the platforms were also pulled. The
+// pulls are preload priority for the concurrent pull limits. If the registry has mirrors then
+// each mirror is tried in order until one succeeds, the same as for a client pull, and an
+// endpoint whose pulls are held back because it is out of pull quota is skipped.
 func doPull(imageUrl string, imagePath string, platformArch string, platformOs string) (int, error) {
 	itemcnt := 0
 	pr, err := pullrequest.NewPullRequestFromUrl(imageUrl)
 	if err != nil {
 		return itemcnt, fmt.Errorf("unable to parse image ref %q", imageUrl)
 	}
-	opts, err := config.ConfigFor(pr.Remote)
+	release, err := upstream.Acquire(upstream.WithPriority(context.Background(), upstream.PriorityPreload), pr.Remote)
 	if err != nil {
 		return itemcnt, err
 	}
+	defer release()
+	for _, ep := range upstream.Endpoints(pr.Remote) {
+		var cnt int
+		cnt, err = pullFrom(pr, ep, imagePath, platformArch, platformOs)
+		itemcnt += cnt
+		if err != nil {
+			if !errors.Is(err, upstream.ErrRateLimited) {
+				ep.Failed(upstream.FromPullError(err))
+			}
+			continue
+		}
+		ep.Succeeded()
+		if ep.Mirror != "" {
+			log.Infof("pulled %q from mirror %s", imageUrl, ep.Mirror)
+		}
+		return itemcnt, nil
+	}
+	return itemcnt, err
+}
+
+// pullFrom implements doPull for one endpoint. Manifests pulled from a mirror are cached under
+// the registry rather than the mirror.
+func pullFrom(pr pullrequest.PullRequest, ep upstream.Endpoint, imagePath string, platformArch string, platformOs string) (int, error) {
+	itemcnt := 0
+	if err := upstream.CheckRateLimit(ep.Host()); err != nil {
+		return itemcnt, err
+	}
+	opts, err := config.ConfigFor(ep.Host())
+	if err != nil {
+		return itemcnt, err
+	}
+	// urlFor returns the url to pull the passed reference from the endpoint, and the url to
+	// cache the manifest under - which is empty to cache it under the url it was pulled from
+	urlFor := func(reference string) (string, string) {
+		if ep.Mirror == "" {
+			return pr.UrlWithDigest(reference), ""
+		}
+		return ep.Url(pr.Repository, reference), pr.UrlWithDigest(reference)
+	}
+	cacheUrl := ""
 	opts.Url = pr.Url()
+	if ep.Mirror != "" {
+		opts.Url, cacheUrl = ep.Url(pr.Repository, pr.Reference), pr.Url()
+	}
 	opts.OStype = platformOs
 	opts.ArchType = platformArch
 	puller, err := imgpull.NewPullerWith(opts)
@@ -86,28 +138,37 @@ func doPull(imageUrl string, imagePath string, platformArch string, platformOs s
 		return itemcnt, err
 	}
 	md, err := puller.HeadManifest()
+	upstream.RecordRateLimitError(ep.Host(), upstream.FromPullError(err))
 	if err != nil {
 		return itemcnt, err
 	}
-	mh, cnt, err := getFromCacheOrRemote(puller, md.Digest, pr.IsLatest(), md.IsImageManifest(), imagePath)
+	mh, cnt, err := getFromCacheOrRemote(puller, md.Digest, pr.IsLatest(), md.IsImageManifest(), imagePath, cacheUrl)
 	if err != nil {
 		return itemcnt, err
 	}
 	itemcnt += cnt
 	if mh.IsManifestList() {
-		digest, err := mh.GetImageDigestFor(platformOs, platformArch)
-		if err != nil {
-			return itemcnt, err
+		platforms := config.PlatformsFor(pr.Remote)
+		if len(platforms) == 0 {
+			platforms = []string{platformOs + "/" + platformArch}
 		}
-		if pr.PullType == pullrequest.ByDigest {
-			// if the manifest list was pulled by digest rather than by tag, then set the ref for the image
-			// manifest to be a digest as well
-			puller.SetUrl(pr.UrlWithDigest(digest))
+		digests := helpers.PlatformDigests(mh, platforms)
+		if len(digests) == 0 {
+			return itemcnt, fmt.Errorf("no image manifest for platform(s) %v in %q", platforms, pr.Url())
 		}
-		if mh, cnt, err = getFromCacheOrRemote(puller, digest, pr.IsLatest(), true, imagePath); err != nil {
-			return itemcnt, err
+		for _, digest := range digests {
+			if pr.PullType == pullrequest.ByDigest {
+				// if the manifest list was pulled by digest rather than by tag, then set the ref for the image
+				// manifest to be a digest as well
+				var url string
+				url, cacheUrl = urlFor(digest)
+				puller.SetUrl(url)
+			}
+			if _, cnt, err = getFromCacheOrRemote(puller, digest, pr.IsLatest(), true, imagePath, cacheUrl); err != nil {
+				return itemcnt, err
+			}
+			itemcnt += cnt
 		}
-		itemcnt += cnt
 	}
 	return itemcnt, nil
 }
@@ -115,8 +176,9 @@ func doPull(imageUrl string, imagePath string, platformArch string, platformOs s
 // getFromCacheOrRemote first checks the file system for a manifest whose digest matches the
 // passed 'digest' arg. If already present on the file system, then does nothing. Otherwise pulls
 // from the upstream using the url in the passed puller and saves the manifest (and blobs if an
-// image url) to the file system.
-func getFromCacheOrRemote(puller imgpull.Puller, digest string, isLatest bool, isImageManifest bool, imagePath string) (imgpull.ManifestHolder, int, error) {
+// image url) to the file system. The manifest is saved with the passed url, or with the url in
+// the puller if the passed url is empty.
+func getFromCacheOrRemote(puller imgpull.Puller, digest string, isLatest bool, isImageManifest bool, imagePath string, imageUrl string) (imgpull.ManifestHolder, int, error) {
 	if mh, found := serialize.MhFromFilesystem(digest, isLatest, imagePath); found {
 		log.Infof("already cached: %s", puller.GetUrl())
 		return mh, 0, nil
@@ -132,6 +194,9 @@ func getFromCacheOrRemote(puller imgpull.Puller, digest string, isLatest bool, i
 	if err != nil {
 		return imgpull.ManifestHolder{}, 0, err
 	}
+	if imageUrl != "" {
+		mh.ImageUrl = imageUrl
+	}
 	if err := serialize.MhToFilesystem(mh, imagePath, false); err != nil {
 		return imgpull.ManifestHolder{}, 0, err
 	}
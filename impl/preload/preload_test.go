@@ -3,15 +3,19 @@ package preload
 import (
 	"fmt"
 	"io"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/aceeric/ociregistry/impl/config"
 	"github.com/aceeric/ociregistry/impl/globals"
 	"github.com/aceeric/ociregistry/impl/serialize"
+	"github.com/aceeric/ociregistry/impl/upstream"
 	"github.com/aceeric/ociregistry/mock"
 
+	"github.com/aceeric/imgpull/pkg/imgpull"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -58,6 +62,45 @@ func TestPreload(t *testing.T) {
 	}
 }
 
+var platformsConfig = `
+---
+platforms:
+  - %s
+registries:
+  - name: %s
+    scheme: http
+`
+
+// Tests that the configured platforms override the OS and architecture passed to doPull. The
+// mock server only serves the linux/amd64 image manifest from the hello-world manifest list.
+func TestPreloadPlatforms(t *testing.T) {
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	defer server.Close()
+	for _, tst := range []struct {
+		platform string
+		cnt      int
+		ok       bool
+	}{
+		{"linux/amd64", 2, true},
+		{"linux/s390x", 1, false},
+		{"plan9/amd64", 1, false},
+	} {
+		if err := config.SetConfigFromStr([]byte(fmt.Sprintf(platformsConfig, tst.platform, url))); err != nil {
+			t.FailNow()
+		}
+		d, err := os.MkdirTemp("", "")
+		if err != nil {
+			t.FailNow()
+		}
+		defer os.RemoveAll(d)
+		serialize.CreateDirs(d, true)
+		cnt, err := doPull(url+"/hello-world:latest", d, "arm64", "linux")
+		if cnt != tst.cnt || (err == nil) != tst.ok {
+			t.Errorf("platform %s: expected count %d, got %d, err: %v", tst.platform, tst.cnt, cnt, err)
+		}
+	}
+}
+
 var loadConfig = `
 ---
 registries:
@@ -94,3 +137,46 @@ func TestLoad(t *testing.T) {
 		t.Fail()
 	}
 }
+
+var mirrorsConfig = `
+---
+registries:
+  - name: mirrored.io
+    mirrors: [%[1]s, %[2]s]
+    mirrorCooldown: 1h
+  - name: %[1]s
+    scheme: http
+  - name: %[2]s
+    scheme: http
+`
+
+// Preloads through a registry whose first mirror is down and checks that the image is pulled
+// from the second mirror and cached under the registry name.
+func TestPreloadMirrors(t *testing.T) {
+	defer upstream.ResetMirrors()
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	defer server.Close()
+	down := httptest.NewServer(nil)
+	down.Close()
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(mirrorsConfig, strings.TrimPrefix(down.URL, "http://"), url))); err != nil {
+		t.FailNow()
+	}
+	d, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(d)
+	serialize.CreateDirs(d, true)
+	if cnt, err := doPull("mirrored.io/hello-world:latest", d, "amd64", "linux"); err != nil || cnt != 2 {
+		t.FailNow()
+	}
+	serialize.WalkTheCache(d, func(mh imgpull.ManifestHolder, _ os.FileInfo) error {
+		if !strings.HasPrefix(mh.ImageUrl, "mirrored.io/hello-world") {
+			t.Errorf("manifest cached as %q", mh.ImageUrl)
+		}
+		return nil
+	})
+	if eps := upstream.Endpoints("mirrored.io"); len(eps) != 1 || eps[0].Mirror != url {
+		t.FailNow()
+	}
+}
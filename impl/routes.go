@@ -0,0 +1,153 @@
+package impl
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// repositoryRoute is the Echo route for the endpoints that have a repository name in the path:
+// manifests, blobs, uploads, tags, and referrers. A repository name can have any number of
+// segments (e.g. 'registry.gitlab.com/group/subgroup/project/image') which can't be expressed
+// in the OpenAPI spec, so these endpoints are routed by the 'dispatch' function rather than
+// by the generated code.
+const repositoryRoute = "/v2/*"
+
+// RegisterRepositoryHandlers registers the handler for the endpoints under /v2 that have a
+// repository name in the path. Echo prefers static routes (like /v2/auth) over the wildcard
+// so the endpoints generated from the OpenAPI spec are unaffected.
+func RegisterRepositoryHandlers(e *echo.Echo, r *OciRegistry) {
+	e.Any(repositoryRoute, r.dispatch)
+}
+
+// SkipValidation is an Echo skipper for the OpenAPI validator middleware. It skips validation
+// for the repository endpoints since they are not in the OpenAPI spec.
+func SkipValidation(ctx echo.Context) bool {
+	return ctx.Path() == repositoryRoute
+}
+
+// dispatch parses a repository endpoint path and calls the handler for it. The endpoint is
+// identified from the right end of the path so the repository name - everything to the left
+// of the endpoint - can have any number of segments:
+//
+//	/v2/<name>/manifests/<reference>
+//	/v2/<name>/blobs/<digest>
+//	/v2/<name>/blobs/uploads/
+//	/v2/<name>/blobs/uploads/<reference>
+//	/v2/<name>/tags/list
+//	/v2/<name>/referrers/<digest>
+//
+// The upstream is resolved from the repository name by the handlers the same way as always: from
+// the X-Registry header, the 'ns' query param, or the first segment of the name. If token auth is
+// enabled then the request is authorized for the repository name before the handler is called.
+func (r *OciRegistry) dispatch(ctx echo.Context) error {
+	segs := strings.Split(strings.TrimPrefix(ctx.Request().URL.Path, "/v2/"), "/")
+	n := len(segs)
+	method := ctx.Request().Method
+	switch {
+	case n >= 4 && segs[n-3] == "blobs" && segs[n-2] == "uploads":
+		name, reference := segs[:n-3], segs[n-1]
+		if !validName(name) {
+			break
+		}
+		if re := authorizeRepository(ctx, name, "push"); re != nil {
+			return re.Send(ctx)
+		}
+		if reference == "" && method == http.MethodPost {
+			return r.handleV2PostBlobsUploads(ctx, queryParam(ctx, "digest"), queryParam(ctx, "mount"), name...)
+		}
+		switch method {
+		case http.MethodGet:
+			return r.handleV2GetBlobsUploads(ctx, reference, name...)
+		case http.MethodPatch:
+			return r.handleV2PatchBlobsUploads(ctx, reference, name...)
+		case http.MethodPut:
+			return r.handleV2PutBlobsUploads(ctx, reference, queryParam(ctx, "digest"), name...)
+		case http.MethodDelete:
+			return r.handleV2DeleteBlobsUploads(ctx, reference, name...)
+		}
+		return unsupported(ctx)
+	case n >= 3 && segs[n-2] == "blobs" && segs[n-1] == "uploads" && method == http.MethodPost:
+		// some clients omit the trailing slash
+		if validName(segs[:n-2]) {
+			if re := authorizeRepository(ctx, segs[:n-2], "push"); re != nil {
+				return re.Send(ctx)
+			}
+			return r.handleV2PostBlobsUploads(ctx, queryParam(ctx, "digest"), queryParam(ctx, "mount"), segs[:n-2]...)
+		}
+	case n >= 3 && segs[n-2] == "manifests":
+		name, reference := segs[:n-2], segs[n-1]
+		if !validName(name) || reference == "" {
+			break
+		}
+		if re := authorizeRepository(ctx, name, actionFor(method)); re != nil {
+			return re.Send(ctx)
+		}
+		switch method {
+		case http.MethodGet, http.MethodHead:
+			return r.handleV2ManifestsReference(ctx, reference, queryParam(ctx, "ns"), method, name...)
+		case http.MethodPut:
+			return r.handleV2PutManifestsReference(ctx, reference, name...)
+		case http.MethodDelete:
+			return r.handleV2DeleteManifestsReference(ctx, reference, queryParam(ctx, "ns"), name...)
+		}
+		return unsupported(ctx)
+	case n >= 3 && segs[n-2] == "blobs":
+		name, digest := segs[:n-2], segs[n-1]
+		if !validName(name) || digest == "" {
+			break
+		}
+		if re := authorizeRepository(ctx, name, actionFor(method)); re != nil {
+			return re.Send(ctx)
+		}
+		switch method {
+		case http.MethodGet:
+			return r.handleV2BlobsDigest(ctx, digest, name...)
+		case http.MethodHead:
+			return r.handleV2HeadBlobsDigest(ctx, digest, name...)
+		case http.MethodDelete:
+			return r.handleV2DeleteBlobsDigest(ctx, digest, name...)
+		}
+		return unsupported(ctx)
+	case n >= 3 && segs[n-2] == "tags" && segs[n-1] == "list":
+		if !validName(segs[:n-2]) {
+			break
+		}
+		if re := authorizeRepository(ctx, segs[:n-2], actionFor(method)); re != nil {
+			return re.Send(ctx)
+		}
+		if method == http.MethodGet {
+			return r.handleV2TagsList(ctx, queryParam(ctx, "n"), queryParam(ctx, "last"), queryParam(ctx, "ns"), segs[:n-2]...)
+		}
+		return unsupported(ctx)
+	case n >= 3 && segs[n-2] == "referrers":
+		name, digest := segs[:n-2], segs[n-1]
+		if !validName(name) || digest == "" {
+			break
+		}
+		if re := authorizeRepository(ctx, name, actionFor(method)); re != nil {
+			return re.Send(ctx)
+		}
+		if method == http.MethodGet {
+			return r.handleV2Referrers(ctx, digest, queryParam(ctx, "artifactType"), queryParam(ctx, "ns"), name...)
+		}
+		return unsupported(ctx)
+	}
+	return echo.ErrNotFound
+}
+
+// validName returns true if the passed repository name segments are not empty.
+func validName(name []string) bool {
+	return len(name) != 0 && !slices.Contains(name, "")
+}
+
+// queryParam returns a pointer to the value of the passed query param, or nil if the query
+// param is not present, which is how the generated code passes optional params.
+func queryParam(ctx echo.Context, name string) *string {
+	if values, exists := ctx.Request().URL.Query()[name]; exists && len(values) != 0 {
+		return &values[0]
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package impl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aceeric/ociregistry/api"
+	"github.com/aceeric/ociregistry/impl/cache"
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/serialize"
+
+	"github.com/labstack/echo/v4"
+	middleware "github.com/oapi-codegen/echo-middleware"
+)
+
+// Routes requests with a six-segment repository name through Echo configured the same way
+// as the server. The hosted namespace is used so nothing is pulled from an upstream.
+func TestDispatch(t *testing.T) {
+	cache.ResetCache()
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	serialize.CreateDirs(td, true)
+	cfg := strings.ReplaceAll(fmt.Sprintf(hostedCfg, td), "local.registry", "registry.gitlab.com")
+	if err := config.SetConfigFromStr([]byte(cfg)); err != nil {
+		t.FailNow()
+	}
+	swagger, err := api.GetSwagger()
+	if err != nil {
+		t.FailNow()
+	}
+	swagger.Servers = nil
+	r := NewOciRegistry(nil)
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(middleware.OapiRequestValidatorWithOptions(swagger, &middleware.Options{Skipper: SkipValidation}))
+	api.RegisterHandlers(e, r)
+	RegisterRepositoryHandlers(e, r)
+
+	name := "registry.gitlab.com/group/subgroup/project/component/image"
+	digest := "sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57"
+	for _, tst := range []struct {
+		method string
+		path   string
+		status int
+		code   ErrorCode
+	}{
+		{http.MethodGet, "/v2/", http.StatusOK, ""},
+		{http.MethodGet, "/v2/_catalog", http.StatusOK, ""},
+		{http.MethodGet, "/v2/" + name + "/manifests/v1", http.StatusNotFound, ManifestUnknown},
+		{http.MethodHead, "/v2/" + name + "/manifests/v1", http.StatusNotFound, ""},
+		{http.MethodPatch, "/v2/" + name + "/manifests/v1", http.StatusMethodNotAllowed, Unsupported},
+		{http.MethodGet, "/v2/" + name + "/blobs/" + digest, http.StatusNotFound, BlobUnknown},
+		{http.MethodGet, "/v2/" + name + "/tags/list", http.StatusNotFound, NameUnknown},
+		{http.MethodGet, "/v2/" + name + "/referrers/" + digest, http.StatusOK, ""},
+		{http.MethodPost, "/v2/" + name + "/blobs/uploads/", http.StatusAccepted, ""},
+		{http.MethodPost, "/v2/" + name + "/blobs/uploads", http.StatusAccepted, ""},
+		{http.MethodGet, "/v2/" + name + "/blobs/uploads/unknown", http.StatusNotFound, BlobUploadUnknown},
+		{http.MethodGet, "/v2/" + name, http.StatusNotFound, NameUnknown},
+		{http.MethodGet, "/v2//manifests/v1", http.StatusNotFound, NameUnknown},
+	} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(tst.method, tst.path, nil))
+		if rec.Code != tst.status {
+			t.Errorf("%s %s: expected %d, got %d", tst.method, tst.path, tst.status, rec.Code)
+			continue
+		}
+		if tst.code != "" {
+			if re := errorBody(t, rec); re.Code != tst.code {
+				t.Errorf("%s %s: expected %s, got %s", tst.method, tst.path, tst.code, re.Code)
+			}
+		}
+		if tst.status == http.StatusAccepted && !strings.HasPrefix(rec.Header().Get("Location"), "/v2/"+name+"/blobs/uploads/") {
+			t.Errorf("%s %s: unexpected location %q", tst.method, tst.path, rec.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package serverauth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// htpasswd has the users from an htpasswd file. The file is re-read when its modification
+// time or size changes, so users can be added and removed without restarting the server.
+type htpasswd struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	users   map[string]string
+}
+
+// newHtpasswd loads the passed htpasswd file. An error is returned if the file can't be read
+// so that a bad path fails server startup.
+func newHtpasswd(path string) (*htpasswd, error) {
+	h := &htpasswd{path: path}
+	if err := h.reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// hash returns the password hash for the passed user from the htpasswd file, re-reading the
+// file first if it changed. If the file can't be re-read then the users from the last good
+// read are used.
+func (h *htpasswd) hash(user string) (string, bool) {
+	h.Lock()
+	defer h.Unlock()
+	if err := h.reload(); err != nil {
+		log.Warnf("unable to reload htpasswd file %s, using the previously loaded users: %s", h.path, err)
+	}
+	hash, exists := h.users[user]
+	return hash, exists
+}
+
+// reload reads the htpasswd file if it changed since it was last read. Only bcrypt hashes are
+// supported. Entries with any other kind of hash are logged and skipped.
+func (h *htpasswd) reload() error {
+	fi, err := os.Stat(h.path)
+	if err != nil {
+		return err
+	}
+	if h.users != nil && fi.ModTime().Equal(h.modTime) && fi.Size() == h.size {
+		return nil
+	}
+	f, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	users := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(line, ":")
+		if !found || user == "" {
+			return fmt.Errorf("invalid entry at line %d of htpasswd file %s", lineNum, h.path)
+		}
+		if !isBcrypt(hash) {
+			log.Warnf("skipping user %q in htpasswd file %s, only bcrypt hashes are supported", user, h.path)
+			continue
+		}
+		users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	log.Infof("loaded %d user(s) from htpasswd file %s", len(users), h.path)
+	h.users = users
+	h.modTime = fi.ModTime()
+	h.size = fi.Size()
+	return nil
+}
+
+// isBcrypt returns true if the passed hash looks like a bcrypt hash, e.g. from 'htpasswd -B'.
+func isBcrypt(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
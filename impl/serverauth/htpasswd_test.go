@@ -0,0 +1,59 @@
+package serverauth
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Checks users from an htpasswd file, and that changes to the file are picked up without
+// calling Init again.
+func TestHtpasswd(t *testing.T) {
+	defer Init(config.ServerAuthCfg{})
+	hash, err := bcrypt.GenerateFromPassword([]byte("xyzzy"), bcrypt.MinCost)
+	if err != nil {
+		t.FailNow()
+	}
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	entries := fmt.Sprintf("# users\nfrobozz:%s\nplugh:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n", hash)
+	if err := os.WriteFile(path, []byte(entries), 0600); err != nil {
+		t.FailNow()
+	}
+	if err := Init(config.ServerAuthCfg{Mode: "basic", Htpasswd: path}); err != nil || Mode() != ModeBasic {
+		t.FailNow()
+	}
+	if !CheckUser("frobozz", "xyzzy") || CheckUser("frobozz", "plugh") || CheckUser("plugh", "password") {
+		t.Fail()
+	}
+	entries += fmt.Sprintf("zork:%s\n", hash)
+	if err := os.WriteFile(path, []byte(entries), 0600); err != nil {
+		t.FailNow()
+	}
+	// make sure the change is seen even if the file system has coarse timestamps
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.FailNow()
+	}
+	if !CheckUser("zork", "xyzzy") || !CheckUser("frobozz", "xyzzy") {
+		t.Fail()
+	}
+	// a file that goes away leaves the last loaded users in place
+	os.Remove(path)
+	if !CheckUser("zork", "xyzzy") {
+		t.Fail()
+	}
+	for _, cfg := range []config.ServerAuthCfg{
+		{Mode: "basic"},
+		{Mode: "basic", Htpasswd: path},
+	} {
+		if err := Init(cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
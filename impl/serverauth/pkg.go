@@ -0,0 +1,5 @@
+// Package serverauth implements authentication of downstream clients - i.e. the clients that
+// pull from the server, as opposed to the upstream registries that the server pulls from. It
+// issues and verifies the signed bearer tokens that the /v2/auth endpoint hands out, and checks
+// user credentials.
+package serverauth
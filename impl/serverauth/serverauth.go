@@ -0,0 +1,99 @@
+package serverauth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Defined modes
+const (
+	ModeNone  = "none"
+	ModeBasic = "basic"
+	ModeToken = "token"
+)
+
+// BasicRealm is the realm in basic auth challenges.
+const BasicRealm = "ociregistry"
+
+// defaultExpiry is the lifetime of a token if the configuration doesn't specify one.
+const defaultExpiry = 5 * time.Minute
+
+// state has everything set up by the Init function. It is only written by Init so no
+// lock is needed. The htpasswd users have their own lock since they are reloaded.
+var state struct {
+	mode     string
+	users    map[string]string
+	htpasswd *htpasswd
+	issuer   *issuer
+}
+
+// Init sets up server auth from the passed configuration. In token mode the signing key is
+// loaded so that a bad key fails server startup rather than the first token request. The
+// same goes for the htpasswd file, if configured.
+func Init(cfg config.ServerAuthCfg) error {
+	mode := strings.ToLower(cfg.Mode)
+	if mode == "" {
+		mode = ModeNone
+	}
+	users := map[string]string{}
+	for _, user := range cfg.Users {
+		if user.Name == "" || user.Password == "" {
+			return fmt.Errorf("server auth users require a name and a password")
+		}
+		users[user.Name] = user.Password
+	}
+	var h *htpasswd
+	if cfg.Htpasswd != "" {
+		var err error
+		if h, err = newHtpasswd(cfg.Htpasswd); err != nil {
+			return fmt.Errorf("unable to load htpasswd file: %s", err)
+		}
+	}
+	var iss *issuer
+	switch mode {
+	case ModeNone:
+	case ModeBasic:
+		if len(users) == 0 && h == nil {
+			return fmt.Errorf("basic auth requires users or an htpasswd file")
+		}
+	case ModeToken:
+		var err error
+		if iss, err = newIssuer(cfg.Token); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported server auth mode: %s", cfg.Mode)
+	}
+	state.mode = mode
+	state.users = users
+	state.htpasswd = h
+	state.issuer = iss
+	return nil
+}
+
+// Mode returns the configured mode.
+func Mode() string {
+	if state.mode == "" {
+		return ModeNone
+	}
+	return state.mode
+}
+
+// CheckUser returns true if the passed user is configured - or is in the htpasswd file - and
+// the passed password matches the bcrypt hash of the user's password. Configured users take
+// precedence over the htpasswd file.
+func CheckUser(user, password string) bool {
+	hash, exists := state.users[user]
+	if !exists && state.htpasswd != nil {
+		hash, exists = state.htpasswd.hash(user)
+	}
+	if !exists {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
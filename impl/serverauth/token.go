@@ -0,0 +1,298 @@
+package serverauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/helpers"
+)
+
+// Access is one entry in the 'access' claim of a token, in the form used by the Docker
+// token specification, e.g. {"type": "repository", "name": "docker.io/library/hello-world",
+// "actions": ["pull"]}.
+type Access struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// Claims are the claims in a token issued by the server.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  string   `json:"aud"`
+	Expiry    int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	IssuedAt  int64    `json:"iat"`
+	ID        string   `json:"jti"`
+	Access    []Access `json:"access"`
+}
+
+// header is the JOSE header of a token.
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// issuer signs and verifies tokens with one key.
+type issuer struct {
+	key     crypto.Signer
+	alg     string
+	kid     string
+	name    string
+	service string
+	realm   string
+	expiry  time.Duration
+}
+
+var (
+	// ErrInvalidToken is returned by Verify for a token that is malformed, has a bad signature,
+	// or was not issued by this server for this service.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned by Verify for a token that is expired or not yet valid.
+	ErrExpiredToken = errors.New("token is expired")
+)
+
+// newIssuer loads the signing key and returns an issuer configured from the passed config.
+func newIssuer(cfg config.ServerTokenCfg) (*issuer, error) {
+	if cfg.SigningKey == "" {
+		return nil, errors.New("token auth requires a signing key")
+	}
+	key, alg, err := loadKey(cfg.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(pub)
+	iss := &issuer{
+		key:     key,
+		alg:     alg,
+		kid:     hex.EncodeToString(sum[:8]),
+		name:    helpers.ValueOr(cfg.Issuer, "ociregistry"),
+		service: helpers.ValueOr(cfg.Service, "ociregistry"),
+		realm:   cfg.Realm,
+		expiry:  defaultExpiry,
+	}
+	if cfg.Expiry != "" {
+		if iss.expiry, err = time.ParseDuration(cfg.Expiry); err != nil {
+			return nil, fmt.Errorf("invalid token expiry %q: %s", cfg.Expiry, err)
+		}
+	}
+	return iss, nil
+}
+
+// loadKey loads an RSA or ECDSA P-256 private key from the passed PEM file and returns it
+// along with the JWS algorithm to sign with.
+func loadKey(keyFile string) (crypto.Signer, string, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, "", err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, "", fmt.Errorf("no PEM data in signing key file %s", keyFile)
+	}
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to parse signing key file %s: %s", keyFile, err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, "RS256", nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, "", fmt.Errorf("unsupported curve in signing key file %s, only P-256 is supported", keyFile)
+		}
+		return k, "ES256", nil
+	}
+	return nil, "", fmt.Errorf("unsupported key type in signing key file %s", keyFile)
+}
+
+// ParseScope parses a scope param like 'repository:docker.io/library/hello-world:pull,push'
+// into an Access. The name can contain a colon (e.g. a registry with a port) so the type is
+// everything to the left of the first colon and the actions are everything to the right of
+// the last colon.
+func ParseScope(scope string) (Access, error) {
+	typ, rest, found := strings.Cut(scope, ":")
+	if !found {
+		return Access{}, fmt.Errorf("invalid scope %q", scope)
+	}
+	i := strings.LastIndex(rest, ":")
+	if i <= 0 || i == len(rest)-1 {
+		return Access{}, fmt.Errorf("invalid scope %q", scope)
+	}
+	return Access{Type: typ, Name: rest[:i], Actions: strings.Split(rest[i+1:], ",")}, nil
+}
+
+// Allows returns true if the receiver grants the passed action on the passed resource.
+func (c Claims) Allows(typ, name, action string) bool {
+	for _, a := range c.Access {
+		if a.Type == typ && a.Name == name && (slices.Contains(a.Actions, action) || slices.Contains(a.Actions, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Issue returns a signed token for the passed subject granting the passed access, along with
+// the lifetime of the token in seconds.
+func Issue(subject string, access []Access) (string, int, error) {
+	iss := state.issuer
+	if iss == nil {
+		return "", 0, errors.New("token auth is not configured")
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", 0, err
+	}
+	now := time.Now()
+	claims := Claims{
+		Issuer:    iss.name,
+		Subject:   subject,
+		Audience:  iss.service,
+		Expiry:    now.Add(iss.expiry).Unix(),
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+		ID:        hex.EncodeToString(jti),
+		Access:    access,
+	}
+	h, err := json.Marshal(header{Alg: iss.alg, Typ: "JWT", Kid: iss.kid})
+	if err != nil {
+		return "", 0, err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", 0, err
+	}
+	signingInput := encode(h) + "." + encode(c)
+	sig, err := iss.sign([]byte(signingInput))
+	if err != nil {
+		return "", 0, err
+	}
+	return signingInput + "." + encode(sig), int(iss.expiry.Seconds()), nil
+}
+
+// Verify checks the signature, issuer, audience, and lifetime of the passed token and if it is
+// valid, returns the claims.
+func Verify(token string) (Claims, error) {
+	iss := state.issuer
+	if iss == nil {
+		return Claims{}, errors.New("token auth is not configured")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrInvalidToken
+	}
+	var h header
+	if b, err := decode(parts[0]); err != nil || json.Unmarshal(b, &h) != nil || h.Alg != iss.alg {
+		return Claims{}, ErrInvalidToken
+	}
+	sig, err := decode(parts[2])
+	if err != nil || !iss.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return Claims{}, ErrInvalidToken
+	}
+	var claims Claims
+	if b, err := decode(parts[1]); err != nil || json.Unmarshal(b, &claims) != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	if claims.Issuer != iss.name || claims.Audience != iss.service {
+		return Claims{}, ErrInvalidToken
+	}
+	if now := time.Now().Unix(); now >= claims.Expiry || now < claims.NotBefore {
+		return Claims{}, ErrExpiredToken
+	}
+	return claims, nil
+}
+
+// Service returns the service name that tokens are issued for.
+func Service() string {
+	if state.issuer == nil {
+		return ""
+	}
+	return state.issuer.service
+}
+
+// Realm returns the configured token endpoint URL, or the empty string if the realm should be
+// derived from the request.
+func Realm() string {
+	if state.issuer == nil {
+		return ""
+	}
+	return state.issuer.realm
+}
+
+// sign signs the passed signing input. ECDSA signatures are encoded as the fixed-size
+// concatenation of r and s as required by JWS, rather than ASN.1.
+func (iss *issuer) sign(input []byte) ([]byte, error) {
+	sum := sha256.Sum256(input)
+	switch k := iss.key.(type) {
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+	return nil, errors.New("unsupported signing key")
+}
+
+// verify verifies the passed signature of the passed signing input.
+func (iss *issuer) verify(input []byte, sig []byte) bool {
+	sum := sha256.Sum256(input)
+	switch k := iss.key.(type) {
+	case *rsa.PrivateKey:
+		return rsa.VerifyPKCS1v15(&k.PublicKey, crypto.SHA256, sum[:], sig) == nil
+	case *ecdsa.PrivateKey:
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(&k.PublicKey, sum[:], r, s)
+	}
+	return false
+}
+
+// encode encodes the passed bytes for a token.
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decode decodes one part of a token.
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,138 @@
+package serverauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aceeric/ociregistry/impl/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeKey writes the passed private key as PKCS8 PEM into a temp dir and returns the path.
+func writeKey(t *testing.T, key any) string {
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.FailNow()
+	}
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}), 0600); err != nil {
+		t.FailNow()
+	}
+	return keyFile
+}
+
+// Issues and verifies tokens signed with an EC and an RSA key, and checks that expired,
+// tampered, and foreign tokens are rejected.
+func TestIssueVerify(t *testing.T) {
+	defer Init(config.ServerAuthCfg{})
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.FailNow()
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.FailNow()
+	}
+	access := []Access{{Type: "repository", Name: "docker.io/library/hello-world", Actions: []string{"pull"}}}
+	for _, key := range []any{ecKey, rsaKey} {
+		cfg := config.ServerAuthCfg{Mode: "token", Token: config.ServerTokenCfg{SigningKey: writeKey(t, key)}}
+		if err := Init(cfg); err != nil || Mode() != ModeToken {
+			t.FailNow()
+		}
+		token, expiresIn, err := Issue("frobozz", access)
+		if err != nil || expiresIn != 300 {
+			t.FailNow()
+		}
+		claims, err := Verify(token)
+		if err != nil || claims.Subject != "frobozz" {
+			t.FailNow()
+		}
+		if !claims.Allows("repository", "docker.io/library/hello-world", "pull") || claims.Allows("repository", "docker.io/library/hello-world", "push") {
+			t.Fail()
+		}
+		parts := strings.Split(token, ".")
+		if _, err := Verify(parts[0] + "." + encode([]byte(`{"sub":"admin"}`)) + "." + parts[2]); err != ErrInvalidToken {
+			t.Fail()
+		}
+		// a token from another service is not accepted
+		cfg.Token.Service = "other"
+		if err := Init(cfg); err != nil {
+			t.FailNow()
+		}
+		if _, err := Verify(token); err != ErrInvalidToken {
+			t.Fail()
+		}
+		cfg.Token.Expiry = "-1s"
+		if err := Init(cfg); err != nil {
+			t.FailNow()
+		}
+		token, _, _ = Issue("frobozz", access)
+		if _, err := Verify(token); err != ErrExpiredToken {
+			t.Fail()
+		}
+	}
+}
+
+// Checks that server auth init fails for bad configurations.
+func TestInit(t *testing.T) {
+	defer Init(config.ServerAuthCfg{})
+	if err := Init(config.ServerAuthCfg{}); err != nil || Mode() != ModeNone {
+		t.Fail()
+	}
+	for _, cfg := range []config.ServerAuthCfg{
+		{Mode: "frobozz"},
+		{Mode: "token"},
+		{Mode: "token", Token: config.ServerTokenCfg{SigningKey: "/no/such/file"}},
+		{Users: []config.ServerUserCfg{{Name: "frobozz"}}},
+	} {
+		if err := Init(cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	for _, tst := range []struct {
+		scope string
+		valid bool
+		name  string
+		acts  int
+	}{
+		{"repository:docker.io/library/hello-world:pull", true, "docker.io/library/hello-world", 1},
+		{"repository:localhost:8080/hello-world:pull,push", true, "localhost:8080/hello-world", 2},
+		{"registry:catalog:*", true, "catalog", 1},
+		{"repository:hello-world", false, "", 0},
+		{"repository:hello-world:", false, "", 0},
+		{"frobozz", false, "", 0},
+	} {
+		a, err := ParseScope(tst.scope)
+		if tst.valid != (err == nil) {
+			t.Errorf("%s: unexpected error %v", tst.scope, err)
+		} else if tst.valid && (a.Name != tst.name || len(a.Actions) != tst.acts) {
+			t.Errorf("%s: unexpected access %+v", tst.scope, a)
+		}
+	}
+}
+
+func TestCheckUser(t *testing.T) {
+	defer Init(config.ServerAuthCfg{})
+	hash, err := bcrypt.GenerateFromPassword([]byte("xyzzy"), bcrypt.MinCost)
+	if err != nil {
+		t.FailNow()
+	}
+	if err := Init(config.ServerAuthCfg{Users: []config.ServerUserCfg{{Name: "frobozz", Password: string(hash)}}}); err != nil {
+		t.FailNow()
+	}
+	if !CheckUser("frobozz", "xyzzy") || CheckUser("frobozz", "plugh") || CheckUser("plugh", "xyzzy") {
+		t.Fail()
+	}
+}
@@ -0,0 +1,117 @@
+package impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/upstream"
+
+	"github.com/labstack/echo/v4"
+)
+
+// tagsList is the body of a tags list response as defined by the distribution spec.
+type tagsList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// catalog is the body of a catalog response.
+type catalog struct {
+	Repositories []string `json:"repositories"`
+}
+
+// upstreamTags gets one page of tags for the repository in the passed PullRequest from
+// the upstream. The bool return value is true if the upstream indicated (with a Link header)
+// that there are more tags. If the registry has mirrors then each is tried in order.
+func upstreamTags(pr pullrequest.PullRequest, n *string, last *string, timeout int) ([]string, bool, error) {
+	q := url.Values{}
+	if n != nil {
+		q.Set("n", *n)
+	}
+	if last != nil {
+		q.Set("last", *last)
+	}
+	path := "tags/list"
+	if len(q) != 0 {
+		path += "?" + q.Encode()
+	}
+	tl := tagsList{}
+	more := false
+	err := upstream.WithEndpoints(pr.Remote, pr.Repository, timeout, func(client *upstream.Client) error {
+		resp, err := client.Get(path, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return upstream.NewError(resp.StatusCode, "tags list for %q returned status %d", client.Repository(), resp.StatusCode)
+		}
+		more = resp.Header.Get("Link") != ""
+		return json.NewDecoder(resp.Body).Decode(&tl)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return tl.Tags, more, nil
+}
+
+// tagsListResponse writes the passed tags to the response. If 'more' is true then a Link
+// header is added pointing to the next page.
+func tagsListResponse(ctx echo.Context, tags []string, more bool, repoSegments ...string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	if more && len(tags) != 0 {
+		ctx.Response().Header().Set("Link", nextLink(ctx, len(tags), tags[len(tags)-1]))
+	}
+	return ctx.JSON(http.StatusOK, tagsList{Name: strings.Join(repoSegments, "/"), Tags: tags})
+}
+
+// pageSize parses the 'n' pagination param. If nil then -1 is returned meaning no limit.
+func pageSize(n *string) (int, error) {
+	if n == nil {
+		return -1, nil
+	}
+	size, err := strconv.Atoi(*n)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid value for n: %q", *n)
+	}
+	return size, nil
+}
+
+// paginate implements the 'n' and 'last' pagination params of the distribution spec over
+// the passed lexically sorted list. The returned list has at most 'n' items that sort after
+// 'last'. The bool return value is true if there are items after the returned page.
+func paginate(items []string, n *string, last *string) ([]string, bool, error) {
+	size, err := pageSize(n)
+	if err != nil {
+		return nil, false, err
+	}
+	start := 0
+	if last != nil {
+		start, _ = slices.BinarySearch(items, *last)
+		if start < len(items) && items[start] == *last {
+			start++
+		}
+	}
+	items = items[start:]
+	if size < 0 || size >= len(items) {
+		return items, false, nil
+	}
+	return items[:size], true, nil
+}
+
+// nextLink returns an RFC 5988 Link header value for the page following the page ending with
+// 'last', relative to the current request so that query params like 'ns' are carried forward.
+func nextLink(ctx echo.Context, n int, last string) string {
+	q := ctx.Request().URL.Query()
+	q.Set("n", strconv.Itoa(n))
+	q.Set("last", last)
+	return fmt.Sprintf("<%s?%s>; rel=\"next\"", ctx.Request().URL.Path, q.Encode())
+}
@@ -0,0 +1,77 @@
+package impl
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/pullrequest"
+	"github.com/aceeric/ociregistry/impl/upstream"
+	"github.com/aceeric/ociregistry/mock"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []string{"a", "b", "c", "d"}
+	strp := func(s string) *string { return &s }
+	tests := []struct {
+		n      *string
+		last   *string
+		expect string
+		more   bool
+	}{
+		{nil, nil, "a,b,c,d", false},
+		{strp("2"), nil, "a,b", true},
+		{strp("2"), strp("b"), "c,d", false},
+		{strp("1"), strp("bb"), "c", true},
+		{nil, strp("d"), "", false},
+		{strp("0"), nil, "", true},
+		{strp("10"), nil, "a,b,c,d", false},
+	}
+	for _, test := range tests {
+		page, more, err := paginate(items, test.n, test.last)
+		if err != nil || strings.Join(page, ",") != test.expect || more != test.more {
+			t.Fail()
+		}
+	}
+	if _, _, err := paginate(items, strp("-1"), nil); err == nil {
+		t.Fail()
+	}
+	if _, _, err := paginate(items, strp("x"), nil); err == nil {
+		t.Fail()
+	}
+}
+
+var mirrorsCfg = `
+---
+registries:
+  - name: mirrored.io
+    mirrors: [%[1]s, %[2]s]
+    mirrorCooldown: 1h
+  - name: %[1]s
+    scheme: http
+  - name: %[2]s
+    scheme: http
+`
+
+// Gets the tags list through a registry whose first mirror is down and checks that the second
+// mirror answers and that the first mirror is then skipped.
+func TestUpstreamTagsMirrors(t *testing.T) {
+	defer upstream.ResetMirrors()
+	server, url := mock.Server(mock.NewMockParams(mock.NONE, mock.HTTP))
+	defer server.Close()
+	down := httptest.NewServer(nil)
+	down.Close()
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf(mirrorsCfg, strings.TrimPrefix(down.URL, "http://"), url))); err != nil {
+		t.FailNow()
+	}
+	pr := pullrequest.PullRequest{Remote: "mirrored.io", Repository: "hello-world"}
+	if tags, _, err := upstreamTags(pr, nil, nil, 1000); err != nil || !slices.Contains(tags, "latest") {
+		t.FailNow()
+	}
+	if eps := upstream.Endpoints("mirrored.io"); len(eps) != 1 || eps[0].Mirror != url {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,4 @@
+// Package upload manages blob upload sessions for the hosted namespace. An upload is written to
+// a file under the 'uploads' directory of the image cache and, once the digest is verified, is
+// moved into the 'blobs' directory.
+package upload
@@ -0,0 +1,215 @@
+package upload
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/globals"
+	"github.com/aceeric/ociregistry/impl/serialize"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// session is one in-progress blob upload. The hash is updated as chunks are appended
+// so the digest can be verified without re-reading the file. Updated is when the session
+// was started or last had a chunk appended.
+type session struct {
+	sync.Mutex
+	repository string
+	file       string
+	size       int64
+	hash       hash.Hash
+	updated    time.Time
+}
+
+// defaultTtl is used if the upload ttl is not configured or can't be parsed
+const defaultTtl = 24 * time.Hour
+
+// uploadSessions holds the in-progress uploads keyed by session ID.
+type uploadSessions struct {
+	sync.Mutex
+	sessions map[string]*session
+}
+
+var (
+	// ErrUnknown means the upload session does not exist.
+	ErrUnknown = errors.New("upload session unknown")
+	// ErrRange means a chunk did not start at the end of the data uploaded so far.
+	ErrRange = errors.New("chunk is out of order")
+	// ErrDigest means the digest is malformed or does not match the uploaded data.
+	ErrDigest = errors.New("digest invalid")
+	// us is the upload session map
+	us = uploadSessions{
+		sessions: make(map[string]*session),
+	}
+)
+
+// Start starts an upload session for the passed repository and returns the session ID.
+func Start(imagePath string, repository string) (string, error) {
+	dir := filepath.Join(imagePath, globals.UploadPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	f, err := os.Create(filepath.Join(dir, id))
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	us.Lock()
+	defer us.Unlock()
+	us.sessions[id] = &session{
+		repository: repository,
+		file:       f.Name(),
+		hash:       sha256.New(),
+		updated:    time.Now(),
+	}
+	return id, nil
+}
+
+// Size returns the number of bytes uploaded so far in the passed session.
+func Size(id string, repository string) (int64, error) {
+	s, err := get(id, repository)
+	if err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.size, nil
+}
+
+// Append appends the data from the passed reader to the passed session and returns the number
+// of bytes uploaded so far. If start is not negative then it is the offset of the chunk from the
+// Content-Range header, and must equal the number of bytes uploaded so far.
+func Append(id string, repository string, start int64, r io.Reader) (int64, error) {
+	s, err := get(id, repository)
+	if err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if start >= 0 && start != s.size {
+		return s.size, ErrRange
+	}
+	f, err := os.OpenFile(s.file, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return s.size, err
+	}
+	defer f.Close()
+	n, err := io.Copy(io.MultiWriter(f, s.hash), r)
+	s.size += n
+	s.updated = time.Now()
+	return s.size, err
+}
+
+// Complete ends the passed session. The digest of the uploaded data is checked against the passed
+// digest (e.g. 'sha256:...') and, if they match, the file is moved into the blobs directory. If the
+// digest doesn't match then the session remains open so the client can retry.
+func Complete(imagePath string, id string, repository string, digest string) error {
+	s, err := get(id, repository)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	hexDigest, found := strings.CutPrefix(digest, "sha256:")
+	if !found || len(hexDigest) != 64 {
+		return fmt.Errorf("%w: only sha256 digests are supported: %q", ErrDigest, digest)
+	}
+	if actual := hex.EncodeToString(s.hash.Sum(nil)); actual != hexDigest {
+		return fmt.Errorf("%w: uploaded data has digest sha256:%s, expected %s", ErrDigest, actual, digest)
+	}
+	if exists, _ := serialize.BlobExists(imagePath, hexDigest); exists {
+		// the blob may be in use so leave it alone - the content is the same anyway
+		os.Remove(s.file)
+	} else if err := os.Rename(s.file, filepath.Join(imagePath, globals.BlobPath, hexDigest)); err != nil {
+		return err
+	}
+	remove(id)
+	return nil
+}
+
+// Cancel ends the passed session and removes any data uploaded so far.
+func Cancel(id string, repository string) error {
+	s, err := get(id, repository)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	remove(id)
+	return os.Remove(s.file)
+}
+
+// Expire ends the sessions that have been idle for longer than the upload ttl - see 'Ttl' - and
+// removes the data uploaded to them, since a client that abandons an upload doesn't cancel it.
+// The count of expired sessions is returned.
+func Expire() int {
+	ttl := Ttl()
+	us.Lock()
+	sessions := maps.Clone(us.sessions)
+	us.Unlock()
+	cnt := 0
+	for id, s := range sessions {
+		s.Lock()
+		if time.Since(s.updated) > ttl && remove(id) {
+			log.Infof("upload %s for %s expired after %s", id, s.repository, ttl)
+			os.Remove(s.file)
+			cnt++
+		}
+		s.Unlock()
+	}
+	return cnt
+}
+
+// Ttl returns how long an upload can be idle before it is expired, from the hosted namespace
+// configuration.
+func Ttl() time.Duration {
+	ttl, err := time.ParseDuration(config.GetHostedConfig().UploadTtl)
+	if err != nil || ttl <= 0 {
+		return defaultTtl
+	}
+	return ttl
+}
+
+// Clean removes any uploads left over from a prior run of the server, since the sessions
+// are only tracked in memory.
+func Clean(imagePath string) error {
+	return os.RemoveAll(filepath.Join(imagePath, globals.UploadPath))
+}
+
+// get returns the session for the passed ID if it exists and is for the passed repository.
+func get(id string, repository string) (*session, error) {
+	us.Lock()
+	defer us.Unlock()
+	if s, exists := us.sessions[id]; exists && s.repository == repository {
+		return s, nil
+	}
+	return nil, ErrUnknown
+}
+
+// remove removes the passed session from the session map. It returns false if the session was
+// already removed.
+func remove(id string) bool {
+	us.Lock()
+	defer us.Unlock()
+	_, exists := us.sessions[id]
+	delete(us.sessions, id)
+	return exists
+}
@@ -0,0 +1,111 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/globals"
+)
+
+// Uploads a blob in two chunks and verifies that out-of-order chunks, the wrong
+// repository, and the wrong digest are rejected.
+func TestUpload(t *testing.T) {
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	os.MkdirAll(filepath.Join(td, globals.BlobPath), 0755)
+	blob := []byte("0123456789")
+	sum := sha256.Sum256(blob)
+	digest := hex.EncodeToString(sum[:])
+
+	id, err := Start(td, "local.registry/test")
+	if err != nil {
+		t.FailNow()
+	}
+	if size, err := Append(id, "local.registry/test", 0, bytes.NewReader(blob[:4])); err != nil || size != 4 {
+		t.FailNow()
+	}
+	if _, err := Append(id, "local.registry/test", 0, bytes.NewReader(blob[4:])); !errors.Is(err, ErrRange) {
+		t.FailNow()
+	}
+	if _, err := Append(id, "local.registry/other", 4, bytes.NewReader(blob[4:])); !errors.Is(err, ErrUnknown) {
+		t.FailNow()
+	}
+	if size, err := Append(id, "local.registry/test", -1, bytes.NewReader(blob[4:])); err != nil || size != 10 {
+		t.FailNow()
+	}
+	if err := Complete(td, id, "local.registry/test", "sha256:"+digest[1:]+"0"); !errors.Is(err, ErrDigest) {
+		t.FailNow()
+	}
+	// the session survives a bad digest
+	if err := Complete(td, id, "local.registry/test", "sha256:"+digest); err != nil {
+		t.FailNow()
+	}
+	if b, err := os.ReadFile(filepath.Join(td, globals.BlobPath, digest)); err != nil || !bytes.Equal(b, blob) {
+		t.FailNow()
+	}
+	if _, err := Size(id, "local.registry/test"); !errors.Is(err, ErrUnknown) {
+		t.FailNow()
+	}
+}
+
+func TestCancel(t *testing.T) {
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	id, err := Start(td, "local.registry/test")
+	if err != nil {
+		t.FailNow()
+	}
+	if err := Cancel(id, "local.registry/test"); err != nil {
+		t.FailNow()
+	}
+	if _, err := os.Stat(filepath.Join(td, globals.UploadPath, id)); !os.IsNotExist(err) {
+		t.FailNow()
+	}
+	if err := Cancel(id, "local.registry/test"); !errors.Is(err, ErrUnknown) {
+		t.FailNow()
+	}
+}
+
+// Expires a session that has been idle for longer than the upload ttl.
+func TestExpire(t *testing.T) {
+	td, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(td)
+	idle, err := Start(td, "local.registry/test")
+	if err != nil {
+		t.FailNow()
+	}
+	active, err := Start(td, "local.registry/test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer Cancel(active, "local.registry/test")
+	s, _ := get(idle, "local.registry/test")
+	s.updated = time.Now().Add(-Ttl() - time.Minute)
+	if Expire() != 1 {
+		t.FailNow()
+	}
+	if _, err := Size(idle, "local.registry/test"); !errors.Is(err, ErrUnknown) {
+		t.FailNow()
+	}
+	if _, err := os.Stat(filepath.Join(td, globals.UploadPath, idle)); !os.IsNotExist(err) {
+		t.FailNow()
+	}
+	if _, err := Size(active, "local.registry/test"); err != nil {
+		t.FailNow()
+	}
+}
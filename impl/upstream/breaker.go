@@ -0,0 +1,176 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/metrics"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BreakerState is the state of the circuit breaker for a registry.
+type BreakerState int
+
+const (
+	// BreakerClosed means pulls go to the registry.
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen means one pull has been let through to probe the registry and other
+	// pulls fail fast until it completes.
+	BreakerHalfOpen
+	// BreakerOpen means pulls from the registry fail fast.
+	BreakerOpen
+)
+
+// String returns the name of the receiver for the logs and the /cmd/breaker/list endpoint.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// BreakerStatus is the state of the circuit breaker for one registry.
+type BreakerStatus struct {
+	Registry string
+	State    BreakerState
+	Failures int
+	// Until is when an open breaker half-opens, or when a half-open breaker gives up on its
+	// probe and lets another pull through
+	Until time.Time
+}
+
+// ErrCircuitOpen is wrapped by the error that a pull from a registry fails with when the
+// circuit breaker for the registry is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+const (
+	// defaultBreakerFailures is used if the breaker failures is not configured
+	defaultBreakerFailures = 5
+	// defaultBreakerOpenFor is used if the breaker open duration is not configured or can't
+	// be parsed
+	defaultBreakerOpenFor = 30 * time.Second
+)
+
+// breakers has the circuit breaker status of each registry that has been pulled from, keyed
+// by registry. Like mirror health, this is passive - a registry is only marked failed when a
+// pull from it fails.
+var breakers = struct {
+	sync.Mutex
+	registries map[string]*BreakerStatus
+}{
+	registries: map[string]*BreakerStatus{},
+}
+
+// CheckBreaker returns nil if a pull from the passed registry can go ahead. If the circuit
+// breaker for the registry is open then an Error with a 503 status that wraps ErrCircuitOpen
+// is returned so the caller fails fast rather than waiting on an upstream that is down. Once
+// the breaker has been open for the configured duration, the next pull is let through as a
+// probe, and the caller must report how it went with 'RecordPull'.
+func CheckBreaker(registry string) error {
+	enabled, _, openFor := breakerSettings()
+	if !enabled {
+		return nil
+	}
+	breakers.Lock()
+	defer breakers.Unlock()
+	b, exists := breakers.registries[registry]
+	if !exists || b.State == BreakerClosed {
+		return nil
+	}
+	if time.Now().Before(b.Until) {
+		metrics.IncBreakerRejectionsByNs(registry)
+		return &Error{
+			Status: http.StatusServiceUnavailable,
+			Err:    fmt.Errorf("upstream %s is unavailable: %w until %s", registry, ErrCircuitOpen, b.Until.Format(time.RFC3339)),
+		}
+	}
+	log.Infof("circuit breaker for %s is half-open, probing the upstream", registry)
+	b.setState(BreakerHalfOpen)
+	b.Until = time.Now().Add(openFor)
+	return nil
+}
+
+// RecordPull records the result of a pull from the passed registry. An error that means the
+// upstream is unavailable counts as a failure, and a success - or any other error, since it
+// means the upstream answered - closes the breaker. The breaker opens after the configured
+// number of consecutive failures, or after one failed probe when it is half-open.
+func RecordPull(registry string, err error) {
+	enabled, failures, openFor := breakerSettings()
+	if !enabled {
+		return
+	}
+	breakers.Lock()
+	defer breakers.Unlock()
+	b, exists := breakers.registries[registry]
+	if !exists {
+		b = &BreakerStatus{Registry: registry}
+		breakers.registries[registry] = b
+	}
+	if !Unavailable(err) {
+		if b.State != BreakerClosed {
+			log.Infof("circuit breaker for %s closed", registry)
+		}
+		b.Failures = 0
+		b.setState(BreakerClosed)
+		return
+	}
+	b.Failures++
+	if b.State == BreakerHalfOpen || b.Failures >= failures {
+		log.Warnf("circuit breaker for %s opened for %s after %d failures, the last error was: %s", registry, openFor, b.Failures, err)
+		b.setState(BreakerOpen)
+		b.Until = time.Now().Add(openFor)
+	}
+}
+
+// GetBreakers returns the circuit breaker status of every registry that has been pulled from
+// since the server started, sorted by registry.
+func GetBreakers() []BreakerStatus {
+	breakers.Lock()
+	defer breakers.Unlock()
+	statuses := []BreakerStatus{}
+	for _, b := range breakers.registries {
+		statuses = append(statuses, *b)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Registry < statuses[j].Registry
+	})
+	return statuses
+}
+
+// ResetBreakers closes all the circuit breakers. It supports testing.
+func ResetBreakers() {
+	breakers.Lock()
+	defer breakers.Unlock()
+	breakers.registries = map[string]*BreakerStatus{}
+}
+
+// setState sets the state of the receiver and the breaker state metric. Must be called with
+// the breakers locked.
+func (b *BreakerStatus) setState(state BreakerState) {
+	b.State = state
+	metrics.SetBreakerStateByNs(b.Registry, float64(state))
+}
+
+// breakerSettings returns whether the circuit breaker is enabled, the number of consecutive
+// failures that open it, and how long it stays open.
+func breakerSettings() (bool, int, time.Duration) {
+	cfg := config.GetBreakerConfig()
+	failures := cfg.Failures
+	if failures <= 0 {
+		failures = defaultBreakerFailures
+	}
+	openFor, err := time.ParseDuration(cfg.OpenFor)
+	if err != nil || openFor <= 0 {
+		openFor = defaultBreakerOpenFor
+	}
+	return cfg.Enabled, failures, openFor
+}
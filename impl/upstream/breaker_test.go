@@ -0,0 +1,73 @@
+package upstream
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+)
+
+var breakerConfig = `
+---
+breakerConfig:
+  enabled: true
+  failures: 2
+  openFor: 1h
+`
+
+func TestBreaker(t *testing.T) {
+	defer ResetBreakers()
+	if err := config.SetConfigFromStr([]byte(breakerConfig)); err != nil {
+		t.FailNow()
+	}
+	state := func() BreakerState {
+		return GetBreakers()[0].State
+	}
+	// half-open the breaker by backdating when it opened
+	expire := func() {
+		breakers.Lock()
+		defer breakers.Unlock()
+		breakers.registries["docker.io"].Until = time.Now().Add(-time.Second)
+	}
+	if CheckBreaker("docker.io") != nil {
+		t.FailNow()
+	}
+	// a 404 means the upstream is up so it doesn't count as a failure
+	RecordPull("docker.io", errors.New("connection refused"))
+	RecordPull("docker.io", NewError(http.StatusNotFound, "not found"))
+	RecordPull("docker.io", errors.New("connection refused"))
+	if state() != BreakerClosed || CheckBreaker("docker.io") != nil {
+		t.FailNow()
+	}
+	RecordPull("docker.io", NewError(http.StatusBadGateway, "bad gateway"))
+	if state() != BreakerOpen {
+		t.FailNow()
+	}
+	err := CheckBreaker("docker.io")
+	var ue *Error
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &ue) || ue.Status != http.StatusServiceUnavailable {
+		t.FailNow()
+	}
+	if CheckBreaker("quay.io") != nil {
+		t.FailNow()
+	}
+	// one probe is let through when half-open and a failed probe re-opens the breaker
+	expire()
+	if CheckBreaker("docker.io") != nil || state() != BreakerHalfOpen || CheckBreaker("docker.io") == nil {
+		t.FailNow()
+	}
+	RecordPull("docker.io", errors.New("connection refused"))
+	if state() != BreakerOpen || CheckBreaker("docker.io") == nil {
+		t.FailNow()
+	}
+	expire()
+	if CheckBreaker("docker.io") != nil {
+		t.FailNow()
+	}
+	RecordPull("docker.io", nil)
+	if state() != BreakerClosed || GetBreakers()[0].Failures != 0 || CheckBreaker("docker.io") != nil {
+		t.FailNow()
+	}
+}
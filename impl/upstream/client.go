@@ -0,0 +1,285 @@
+package upstream
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+
+	"github.com/aceeric/imgpull/pkg/imgpull"
+)
+
+// Client talks to the v2 API of one repository on one upstream registry. Auth is
+// negotiated lazily: the first request goes out with no credentials (or with an
+// externally provided token if one is configured) and if the upstream answers
+// with a 401 then the WWW-Authenticate challenge is satisfied and the request is
+// retried once.
+type Client struct {
+	opts       imgpull.PullerOpts
+	client     *http.Client
+	host       string
+	server     string
+	repository string
+	authHdr    string
+	// idle is how long reading a response body can go without receiving any data - see
+	// 'NewStreamClient'
+	idle time.Duration
+}
+
+// ErrIdleTimeout is returned by a read of a response body from a client created by
+// 'NewStreamClient' when no data is received for the idle timeout.
+var ErrIdleTimeout = errors.New("no data received from the upstream within the idle timeout")
+
+var bearerParamRe = regexp.MustCompile(`(realm|service|scope)\s*=\s*"([^"]*)"`)
+
+// WithEndpoints calls the passed function with a Client for the passed repository (e.g.
+// 'library/hello-world') on each endpoint of the passed registry (e.g. 'docker.io') in order -
+// see 'Endpoints' - until the function succeeds. Each failure is recorded against its endpoint,
+// and the error from the last endpoint is returned if the function fails for every endpoint.
+// The timeout is in milliseconds and applies to each request.
+func WithEndpoints(registry, repository string, timeout int, fn func(*Client) error) error {
+	var err error
+	for _, ep := range Endpoints(registry) {
+		var client *Client
+		if client, err = NewEndpointClient(ep, repository, timeout); err != nil {
+			continue
+		}
+		if err = fn(client); err != nil {
+			ep.Failed(err)
+			continue
+		}
+		ep.Succeeded()
+		return nil
+	}
+	return err
+}
+
+// NewEndpointClient returns a Client for the passed repository on the passed endpoint.
+// Connection and credential settings come from the registry configuration for the host
+// of the endpoint - so a mirror doesn't get the credentials of the registry it mirrors.
+func NewEndpointClient(ep Endpoint, repository string, timeout int) (*Client, error) {
+	opts, err := config.ConfigFor(ep.Host())
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.TlsCfg != nil {
+		transport.TLSClientConfig = opts.TlsCfg
+	}
+	c := &Client{
+		opts: opts,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(timeout) * time.Millisecond,
+		},
+		host:       ep.Host(),
+		server:     fmt.Sprintf("%s://%s", opts.Scheme, serverFor(ep.Host())),
+		repository: repositoryFor(ep.Host(), ep.Repository(repository)),
+	}
+	if opts.Token != "" {
+		c.authHdr = "Basic " + opts.Token
+	}
+	return c, nil
+}
+
+// NewStreamClient is like 'NewEndpointClient' for downloads that can take arbitrarily long, like
+// blobs. There is no overall timeout. Instead the upstream must answer with the response headers
+// within the passed idle timeout, and each read of a response body fails once the idle timeout
+// passes without any data being received. The idle timeout is in milliseconds.
+func NewStreamClient(ep Endpoint, repository string, idle int) (*Client, error) {
+	c, err := NewEndpointClient(ep, repository, 0)
+	if err != nil {
+		return nil, err
+	}
+	c.idle = time.Duration(idle) * time.Millisecond
+	c.client.Transport.(*http.Transport).ResponseHeaderTimeout = c.idle
+	return c, nil
+}
+
+// Repository returns the repository as it is used in upstream API paths, which differs
+// from the requested repository for DockerHub official images.
+func (c *Client) Repository() string {
+	return c.repository
+}
+
+// Get performs a GET on the passed path which must be relative to the repository,
+// e.g. 'tags/list?n=10'. The caller is responsible for closing the response body.
+func (c *Client) Get(path string, hdrs map[string]string) (*http.Response, error) {
+	return c.send(http.MethodGet, path, hdrs)
+}
+
+// Head performs a HEAD on the passed path which must be relative to the repository,
+// e.g. 'manifests/latest'. The caller is responsible for closing the response body.
+func (c *Client) Head(path string, hdrs map[string]string) (*http.Response, error) {
+	return c.send(http.MethodHead, path, hdrs)
+}
+
+// send implements Get and Head.
+func (c *Client) send(method, path string, hdrs map[string]string) (*http.Response, error) {
+	url := fmt.Sprintf("%s/v2/%s/%s", c.server, c.repository, path)
+	resp, err := c.do(method, url, hdrs)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized || c.opts.Token != "" {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("Www-Authenticate")
+	resp.Body.Close()
+	if err := c.authenticate(challenge); err != nil {
+		return nil, err
+	}
+	return c.do(method, url, hdrs)
+}
+
+// do runs one request with the receiver's current auth header. The rate limit headers
+// of the response - if any - are recorded for the host.
+func (c *Client) do(method, url string, hdrs map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range hdrs {
+		req.Header.Set(k, v)
+	}
+	if c.authHdr != "" {
+		req.Header.Set("Authorization", c.authHdr)
+	}
+	if c.idle <= 0 {
+		resp, err := c.client.Do(req)
+		if err == nil {
+			RecordRateLimit(c.host, resp)
+		}
+		return resp, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	RecordRateLimit(c.host, resp)
+	resp.Body = &idleBody{
+		body:   resp.Body,
+		idle:   c.idle,
+		cancel: cancel,
+		timer:  time.AfterFunc(c.idle, cancel),
+	}
+	return resp, nil
+}
+
+// idleBody is a response body that cancels the request - which fails the pending read - if no
+// data is received for the idle timeout.
+type idleBody struct {
+	body   io.ReadCloser
+	idle   time.Duration
+	cancel context.CancelFunc
+	timer  *time.Timer
+}
+
+// Read implements io.Reader. Each read that receives data restarts the idle timeout.
+func (ib *idleBody) Read(p []byte) (int, error) {
+	n, err := ib.body.Read(p)
+	if n > 0 && !ib.timer.Reset(ib.idle) {
+		// the timer already fired and canceled the request
+		return n, ErrIdleTimeout
+	}
+	if err != nil && err != io.EOF && !ib.timer.Stop() {
+		return n, ErrIdleTimeout
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (ib *idleBody) Close() error {
+	ib.timer.Stop()
+	ib.cancel()
+	return ib.body.Close()
+}
+
+// authenticate satisfies the passed WWW-Authenticate challenge. A bearer challenge gets
+// a token from the realm using the configured user/pass if any, and a basic challenge
+// just uses the configured user/pass.
+func (c *Client) authenticate(challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		c.authHdr = "Basic " + c.basicCreds()
+		return nil
+	case "bearer":
+		ba := map[string]string{}
+		for _, m := range bearerParamRe.FindAllStringSubmatch(params, -1) {
+			ba[m[1]] = m[2]
+		}
+		if ba["realm"] == "" {
+			return fmt.Errorf("unable to parse auth challenge: %q", challenge)
+		}
+		scope := ba["scope"]
+		if scope == "" {
+			scope = fmt.Sprintf("repository:%s:pull", c.repository)
+		}
+		q := url.Values{}
+		q.Set("scope", scope)
+		q.Set("service", ba["service"])
+		req, err := http.NewRequest(http.MethodGet, ba["realm"]+"?"+q.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		if c.opts.Username != "" && c.opts.Password != "" {
+			req.Header.Set("Authorization", "Basic "+c.basicCreds())
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("auth attempt failed. Status: %d", resp.StatusCode)
+		}
+		token := struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return err
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		c.authHdr = "Bearer " + token.Token
+		return nil
+	}
+	return fmt.Errorf("unable to parse auth challenge: %q", challenge)
+}
+
+// basicCreds returns the configured user/pass encoded for a basic auth header.
+func (c *Client) basicCreds() string {
+	return base64.StdEncoding.EncodeToString([]byte(c.opts.Username + ":" + c.opts.Password))
+}
+
+// serverFor maps a registry to the host that serves its API. This is only different
+// for DockerHub.
+func serverFor(remote string) string {
+	if remote == "docker.io" {
+		return "index.docker.io"
+	}
+	return remote
+}
+
+// repositoryFor handles DockerHub official images which are requested like 'hello-world'
+// but are served by the API as 'library/hello-world'.
+func repositoryFor(remote, repository string) string {
+	if (remote == "docker.io" || remote == "index.docker.io") && !strings.Contains(repository, "/") {
+		return "library/" + repository
+	}
+	return repository
+}
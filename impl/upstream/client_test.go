@@ -0,0 +1,50 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aceeric/ociregistry/impl/config"
+)
+
+// Checks that the bearer challenge params are escaped in the token request.
+func TestAuthenticate(t *testing.T) {
+	scope := "repository:foo/bar:pull&push=1"
+	service := "my registry"
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if r.URL.Query().Get("scope") != scope || r.URL.Query().Get("service") != service {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"token":"xyz"}`))
+		case r.Header.Get("Authorization") != "Bearer xyz":
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="%s",scope="%s"`, server.URL, service, scope))
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Write([]byte(`{"name":"foo/bar","tags":["latest"]}`))
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf("registries:\n  - name: %s\n    scheme: http\n", host))); err != nil {
+		t.FailNow()
+	}
+	client, err := NewEndpointClient(Endpoint{Registry: host}, "foo/bar", 1000)
+	if err != nil {
+		t.FailNow()
+	}
+	resp, err := client.Get("tags/list", nil)
+	if err != nil {
+		t.FailNow()
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fail()
+	}
+}
@@ -0,0 +1,77 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Error is returned when an upstream registry answers a request with an HTTP error
+// status. It allows the REST API handlers to pass the upstream status through to the
+// client rather than turning every upstream failure into a 500. If RetryAfter is non-zero
+// then it is how long the client should wait before trying again.
+type Error struct {
+	Status     int
+	Err        error
+	RetryAfter time.Duration
+}
+
+// statusRe matches the HTTP status in the errors returned by the imgpull library. The
+// library doesn't return typed errors so the status has to be parsed from the text.
+var statusRe = regexp.MustCompile(`(?:Status: |status code |failed with status )(\d{3})|^(\d{3}) from server`)
+
+// NewError returns an Error for the passed status with an error message formatted from
+// the passed format string and args.
+func NewError(status int, format string, args ...any) *Error {
+	return &Error{Status: status, Err: fmt.Errorf(format, args...)}
+}
+
+// ResponseError returns an Error for the status of the passed response with an error message
+// formatted from the passed format string and args. If the response has a Retry-After header
+// then it is parsed into the RetryAfter field.
+func ResponseError(resp *http.Response, format string, args ...any) *Error {
+	return &Error{Status: resp.StatusCode, Err: fmt.Errorf(format, args...), RetryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// FromPullError looks for an HTTP error status in the passed error from the imgpull library.
+// If one is found then the error is returned wrapped in an Error, otherwise the passed error
+// is returned as is.
+func FromPullError(err error) error {
+	if err == nil {
+		return nil
+	}
+	m := statusRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	status, _ := strconv.Atoi(m[1] + m[2])
+	if status < 400 {
+		return err
+	}
+	return &Error{Status: status, Err: err}
+}
+
+// Unavailable returns true if the passed error from a pull means that the upstream is down or
+// overloaded rather than that it answered: a connection error, a timeout, a server error, or a
+// 429. Another error status - e.g. a 404 - means the upstream is up but doesn't have the image
+// or won't give it to us.
+func Unavailable(err error) bool {
+	var ue *Error
+	if errors.As(err, &ue) {
+		return ue.Status >= http.StatusInternalServerError || ue.Status == http.StatusTooManyRequests
+	}
+	return err != nil
+}
@@ -0,0 +1,35 @@
+package upstream
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFromPullError(t *testing.T) {
+	tests := []struct {
+		msg    string
+		status int
+	}{
+		{"get manifests attempt failed. Status: 404", 404},
+		{"auth attempt failed. Status: 401", 401},
+		{"basic auth returned status code 403", 403},
+		{`head manifests for "foo" failed with status 429`, 429},
+		{`404 from server for blob digest "sha256:abc"`, 404},
+		{"dial tcp 127.0.0.1:8080: connect: connection refused", 0},
+		{"error getting blob - expected 100 bytes, got 50 bytes instead", 0},
+	}
+	for _, test := range tests {
+		err := FromPullError(errors.New(test.msg))
+		var ue *Error
+		if errors.As(err, &ue) {
+			if ue.Status != test.status {
+				t.Fail()
+			}
+		} else if test.status != 0 {
+			t.Fail()
+		}
+		if err.Error() != test.msg {
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package upstream
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/metrics"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Priority orders the pulls that are waiting for the concurrent pull limits. A lower value goes
+// first, and pulls with the same priority go in the order they arrived.
+type Priority int
+
+const (
+	// PriorityInteractive is for pulls that a client is waiting on. It is the default.
+	PriorityInteractive Priority = iota
+	// PriorityPrefetch is for pulls that the server does in the background, like pulling the
+	// platform images of a manifest list or revalidating a tag.
+	PriorityPrefetch
+	// PriorityPreload is for pulls of the images in a preload file.
+	PriorityPreload
+)
+
+// priorityKey is the context key for the pull priority.
+type priorityKey struct{}
+
+// raisable is a pull priority that can be raised while the pull waits - see
+// 'WithRaisablePriority'. It is guarded by the pull limits lock.
+type raisable struct {
+	priority Priority
+}
+
+// WithPriority returns a copy of the passed context with the passed pull priority.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// WithRaisablePriority is like 'WithPriority' but also returns a function that raises the pull
+// priority of the returned context to the passed priority if it is higher. If a pull with the
+// returned context is waiting for the concurrent pull limits then it moves up the queue. This
+// supports a pull that is shared by more than one caller, so that a client that joins a pull the
+// server started in the background doesn't wait at the background priority.
+func WithRaisablePriority(ctx context.Context, priority Priority) (context.Context, func(Priority)) {
+	r := &raisable{priority: priority}
+	return context.WithValue(ctx, priorityKey{}, r), func(priority Priority) {
+		raisePriority(r, priority)
+	}
+}
+
+// PriorityOf returns the pull priority of the passed context, or PriorityInteractive if the
+// context has none.
+func PriorityOf(ctx context.Context) Priority {
+	if r, ok := ctx.Value(priorityKey{}).(*raisable); ok {
+		pullLimits.Lock()
+		defer pullLimits.Unlock()
+		return r.priority
+	}
+	return priorityOf(ctx)
+}
+
+// priorityOf implements 'PriorityOf' for a context whose priority - if it is raisable - is
+// read with the pull limits locked.
+func priorityOf(ctx context.Context) Priority {
+	switch priority := ctx.Value(priorityKey{}).(type) {
+	case Priority:
+		return priority
+	case *raisable:
+		return priority.priority
+	}
+	return PriorityInteractive
+}
+
+// waiter is a pull waiting for the concurrent pull limits. The ready channel is closed when the
+// pull can go ahead. If the priority of the pull can be raised then raisable is the priority.
+type waiter struct {
+	registry string
+	priority Priority
+	raisable *raisable
+	seq      uint64
+	ready    chan struct{}
+}
+
+// pullLimits has the pulls in progress - overall and by registry - and the queue of pulls that
+// are waiting, in the order they are let through: by priority and then by arrival.
+var pullLimits = struct {
+	sync.Mutex
+	running  int
+	byReg    map[string]int
+	queue    []*waiter
+	queued   map[string]int
+	sequence uint64
+}{
+	byReg:  map[string]int{},
+	queued: map[string]int{},
+}
+
+// Acquire waits until a pull from the passed registry is within the configured limits on concurrent
+// pulls - overall, and for the registry - and returns a function that the caller must call when the
+// pull is done. Pulls that can't go ahead right away wait in a queue in priority order - see
+// 'WithPriority' and 'WithRaisablePriority' - and first-come first-served within a priority. A
+// waiting pull doesn't hold up pulls from other registries that are within their limits. If the
+// passed context is done before the pull can go ahead then an error is returned.
+func Acquire(ctx context.Context, registry string) (func(), error) {
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			releasePull(registry)
+		})
+	}
+	pullLimits.Lock()
+	if canPull(registry) {
+		startPull(registry)
+		pullLimits.Unlock()
+		return release, nil
+	}
+	pullLimits.sequence++
+	w := &waiter{
+		registry: registry,
+		priority: priorityOf(ctx),
+		seq:      pullLimits.sequence,
+		ready:    make(chan struct{}),
+	}
+	w.raisable, _ = ctx.Value(priorityKey{}).(*raisable)
+	idx, _ := slices.BinarySearchFunc(pullLimits.queue, w, queueOrder)
+	pullLimits.queue = slices.Insert(pullLimits.queue, idx, w)
+	setQueued(registry, 1)
+	pullLimits.Unlock()
+
+	log.Debugf("pull from %s is waiting for the concurrent pull limits", registry)
+	start := time.Now()
+	select {
+	case <-w.ready:
+		metrics.ObservePullQueueWaitByNs(registry, time.Since(start).Seconds())
+		return release, nil
+	case <-ctx.Done():
+		pullLimits.Lock()
+		defer pullLimits.Unlock()
+		if idx := slices.Index(pullLimits.queue, w); idx >= 0 {
+			pullLimits.queue = slices.Delete(pullLimits.queue, idx, idx+1)
+			setQueued(registry, -1)
+		} else {
+			// the pull was let through at the same time the context was done
+			endPull(registry)
+			dispatch()
+		}
+		return nil, fmt.Errorf("gave up waiting to pull from %s: %w", registry, context.Cause(ctx))
+	}
+}
+
+// QueuedPulls returns the count of pulls from the passed registry that are waiting for the
+// concurrent pull limits. It supports testing.
+func QueuedPulls(registry string) int {
+	pullLimits.Lock()
+	defer pullLimits.Unlock()
+	return pullLimits.queued[registry]
+}
+
+// ResetLimits clears the pulls in progress and the queue. It supports testing.
+func ResetLimits() {
+	pullLimits.Lock()
+	defer pullLimits.Unlock()
+	pullLimits.running = 0
+	pullLimits.byReg = map[string]int{}
+	pullLimits.queue = nil
+	pullLimits.queued = map[string]int{}
+}
+
+// releasePull ends a pull from the passed registry and lets through the waiting pulls that
+// are now within the limits.
+func releasePull(registry string) {
+	pullLimits.Lock()
+	defer pullLimits.Unlock()
+	endPull(registry)
+	dispatch()
+}
+
+// dispatch lets through the waiting pulls that are within the limits, in queue order. Must be
+// called with the pull limits locked.
+func dispatch() {
+	for idx := 0; idx < len(pullLimits.queue); {
+		w := pullLimits.queue[idx]
+		if !canPull(w.registry) {
+			idx++
+			continue
+		}
+		pullLimits.queue = slices.Delete(pullLimits.queue, idx, idx+1)
+		setQueued(w.registry, -1)
+		startPull(w.registry)
+		close(w.ready)
+	}
+}
+
+// raisePriority raises the passed priority to the passed priority if it is higher, and moves
+// the waiting pulls with the passed priority up the queue.
+func raisePriority(r *raisable, priority Priority) {
+	pullLimits.Lock()
+	defer pullLimits.Unlock()
+	if priority >= r.priority {
+		return
+	}
+	r.priority = priority
+	for _, w := range pullLimits.queue {
+		if w.raisable == r {
+			w.priority = priority
+		}
+	}
+	slices.SortFunc(pullLimits.queue, queueOrder)
+}
+
+// queueOrder orders the waiting pulls by priority and then by arrival.
+func queueOrder(a, b *waiter) int {
+	return cmp.Or(cmp.Compare(a.priority, b.priority), cmp.Compare(a.seq, b.seq))
+}
+
+// canPull returns true if another pull from the passed registry is within the limits. Must be
+// called with the pull limits locked.
+func canPull(registry string) bool {
+	limit, global := config.PullLimitsFor(registry)
+	return (limit <= 0 || pullLimits.byReg[registry] < limit) && (global <= 0 || pullLimits.running < global)
+}
+
+// startPull records a pull from the passed registry. Must be called with the pull limits locked.
+func startPull(registry string) {
+	pullLimits.running++
+	pullLimits.byReg[registry]++
+}
+
+// endPull records the end of a pull from the passed registry. Must be called with the pull limits
+// locked.
+func endPull(registry string) {
+	pullLimits.running--
+	if pullLimits.byReg[registry]--; pullLimits.byReg[registry] <= 0 {
+		delete(pullLimits.byReg, registry)
+	}
+}
+
+// setQueued adds the passed delta to the count of pulls waiting for the passed registry and sets
+// the queue depth metric. Must be called with the pull limits locked.
+func setQueued(registry string, delta int) {
+	pullLimits.queued[registry] += delta
+	metrics.SetPullQueueDepthByNs(registry, float64(pullLimits.queued[registry]))
+	if pullLimits.queued[registry] <= 0 {
+		delete(pullLimits.queued, registry)
+	}
+}
@@ -0,0 +1,89 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+)
+
+var limitsConfig = `
+---
+pullLimits:
+  global: 2
+  perRegistry: 1
+registries:
+  - name: quay.io
+    maxPulls: 2
+`
+
+func TestAcquire(t *testing.T) {
+	defer ResetLimits()
+	if err := config.SetConfigFromStr([]byte(limitsConfig)); err != nil {
+		t.FailNow()
+	}
+	queued := func(cnt int) {
+		for start := time.Now(); ; time.Sleep(5 * time.Millisecond) {
+			pullLimits.Lock()
+			n := len(pullLimits.queue)
+			pullLimits.Unlock()
+			if n == cnt {
+				return
+			}
+			if time.Since(start) > time.Second {
+				t.FailNow()
+			}
+		}
+	}
+	release, err := Acquire(context.Background(), "docker.io")
+	if err != nil {
+		t.FailNow()
+	}
+	// a prefetch pull and then an interactive pull wait for docker.io
+	granted := make(chan Priority, 2)
+	releases := make(chan func(), 2)
+	for i, priority := range []Priority{PriorityPrefetch, PriorityInteractive} {
+		go func() {
+			if r, err := Acquire(WithPriority(context.Background(), priority), "docker.io"); err == nil {
+				releases <- r
+				granted <- priority
+			}
+		}()
+		queued(i + 1)
+	}
+	// a pull from another registry isn't held up by the docker.io queue
+	releaseQuay, err := Acquire(context.Background(), "quay.io")
+	if err != nil {
+		t.FailNow()
+	}
+	// the global limit is reached so a quay.io pull waits even though quay.io allows two
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := Acquire(ctx, "quay.io"); !errors.Is(err, context.DeadlineExceeded) {
+		t.FailNow()
+	}
+	queued(2)
+	// the interactive pull goes ahead of the prefetch pull that arrived first
+	release()
+	if <-granted != PriorityInteractive {
+		t.FailNow()
+	}
+	releaseQuay()
+	select {
+	case <-granted:
+		t.FailNow()
+	case <-time.After(20 * time.Millisecond):
+	}
+	(<-releases)()
+	if <-granted != PriorityPrefetch {
+		t.FailNow()
+	}
+	(<-releases)()
+	pullLimits.Lock()
+	defer pullLimits.Unlock()
+	if pullLimits.running != 0 || len(pullLimits.queue) != 0 || len(pullLimits.queued) != 0 {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,146 @@
+package upstream
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Endpoint is one place that a registry is pulled from: either the registry itself or one of
+// its mirrors. Images are always cached under the registry name regardless of the endpoint
+// they were pulled from.
+type Endpoint struct {
+	// Registry is the registry that the image is cached under, e.g. 'docker.io'
+	Registry string
+	// Mirror is a host with an optional path prefix like 'harbor.corp/dockerhub', or empty
+	// if the endpoint is the registry itself
+	Mirror string
+	// cooldown is how long the endpoint is skipped for after a failure
+	cooldown time.Duration
+}
+
+// mirrorHealth has the mirrors that have failed, keyed by registry and mirror - see 'key' - with
+// the time until which each is skipped. A mirror that serves more than one registry has its
+// health tracked separately for each one. This is passive health checking - a mirror is only marked failed when a
+// pull from it fails.
+var mirrorHealth = struct {
+	sync.Mutex
+	until map[string]time.Time
+}{
+	until: map[string]time.Time{},
+}
+
+// Endpoints returns the endpoints to pull the passed registry from, in the order to try them.
+// If the registry has no mirrors then the registry itself is the only endpoint. Otherwise the
+// endpoints are the configured mirrors, skipping any that failed within the cooldown period. If
+// every mirror is in its cooldown period then all of them are returned so that the pull is still
+// attempted rather than failing without trying.
+func Endpoints(registry string) []Endpoint {
+	mirrors, cooldown := config.MirrorsFor(registry)
+	if len(mirrors) == 0 {
+		return []Endpoint{{Registry: registry}}
+	}
+	all := []Endpoint{}
+	healthy := []Endpoint{}
+	mirrorHealth.Lock()
+	defer mirrorHealth.Unlock()
+	now := time.Now()
+	for _, mirror := range mirrors {
+		ep := Endpoint{Registry: registry, Mirror: mirror, cooldown: cooldown}
+		all = append(all, ep)
+		if until, failed := mirrorHealth.until[ep.key()]; failed && now.Before(until) {
+			log.Debugf("skipping mirror %s for %s until %s", mirror, registry, until.Format(time.RFC3339))
+			continue
+		}
+		healthy = append(healthy, ep)
+	}
+	if len(healthy) == 0 {
+		log.Warnf("all mirrors for %s are in cooldown, trying them anyway", registry)
+		return all
+	}
+	return healthy
+}
+
+// Name returns the mirror, or the registry if the receiver is the registry itself. This is
+// the name of the endpoint in the logs and metrics.
+func (ep Endpoint) Name() string {
+	if ep.Mirror != "" {
+		return ep.Mirror
+	}
+	return ep.Registry
+}
+
+// Host returns the host to connect to for the receiver.
+func (ep Endpoint) Host() string {
+	if ep.Mirror == "" {
+		return ep.Registry
+	}
+	host, _, _ := strings.Cut(ep.Mirror, "/")
+	return host
+}
+
+// Repository returns the passed repository as it is pulled from the receiver. For a mirror, the
+// path prefix of the mirror is prepended, and for a mirror of DockerHub, the 'library/' prefix of
+// official images is added since only DockerHub itself infers it.
+func (ep Endpoint) Repository(repository string) string {
+	if ep.Mirror == "" {
+		return repository
+	}
+	_, prefix, _ := strings.Cut(ep.Mirror, "/")
+	return path.Join(prefix, repositoryFor(ep.Registry, repository))
+}
+
+// Url returns the image url to pull the passed repository and reference from the receiver. The
+// reference is a tag or a digest like 'sha256:...'.
+func (ep Endpoint) Url(repository, reference string) string {
+	sep := ":"
+	if strings.HasPrefix(reference, "sha256:") {
+		sep = "@"
+	}
+	return ep.Host() + "/" + ep.Repository(repository) + sep + reference
+}
+
+// key returns the key of the receiver in the mirror health map.
+func (ep Endpoint) key() string {
+	return ep.Registry + " " + ep.Mirror
+}
+
+// Failed records a failed pull from the receiver. If the receiver is a mirror and the error means
+// the mirror is unhealthy then it is skipped for the cooldown period. An error status from the
+// mirror other than a 429 or a server error - e.g. a 404 - doesn't make the mirror unhealthy since
+// it just means that the mirror doesn't have the image.
+func (ep Endpoint) Failed(err error) {
+	if ep.Mirror == "" {
+		return
+	}
+	if !Unavailable(err) {
+		log.Infof("mirror %s for %s failed: %s", ep.Mirror, ep.Registry, err)
+		return
+	}
+	log.Warnf("mirror %s for %s failed, skipping it for %s: %s", ep.Mirror, ep.Registry, ep.cooldown, err)
+	mirrorHealth.Lock()
+	defer mirrorHealth.Unlock()
+	mirrorHealth.until[ep.key()] = time.Now().Add(ep.cooldown)
+}
+
+// Succeeded records a successful pull from the receiver, which ends any cooldown.
+func (ep Endpoint) Succeeded() {
+	if ep.Mirror == "" {
+		return
+	}
+	mirrorHealth.Lock()
+	defer mirrorHealth.Unlock()
+	delete(mirrorHealth.until, ep.key())
+}
+
+// ResetMirrors clears the mirror health state. It supports testing.
+func ResetMirrors() {
+	mirrorHealth.Lock()
+	defer mirrorHealth.Unlock()
+	mirrorHealth.until = map[string]time.Time{}
+}
@@ -0,0 +1,65 @@
+package upstream
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aceeric/ociregistry/impl/config"
+)
+
+var mirrorsConfig = `
+---
+registries:
+  - name: docker.io
+    mirrors:
+    - harbor.corp/dockerhub
+    - registry-1.docker.io
+    mirrorCooldown: 1h
+  - name: ghcr.io
+    mirrors:
+    - harbor.corp/dockerhub
+    - registry-1.docker.io
+`
+
+func TestEndpoints(t *testing.T) {
+	defer ResetMirrors()
+	if err := config.SetConfigFromStr([]byte(mirrorsConfig)); err != nil {
+		t.FailNow()
+	}
+	if eps := Endpoints("quay.io"); len(eps) != 1 || eps[0].Name() != "quay.io" || eps[0].Url("foo/bar", "v1") != "quay.io/foo/bar:v1" {
+		t.FailNow()
+	}
+	eps := Endpoints("docker.io")
+	if len(eps) != 2 {
+		t.FailNow()
+	}
+	if eps[0].Host() != "harbor.corp" || eps[0].Url("hello-world", "latest") != "harbor.corp/dockerhub/library/hello-world:latest" {
+		t.FailNow()
+	}
+	if eps[1].Url("bitnami/redis", "sha256:abc") != "registry-1.docker.io/bitnami/redis@sha256:abc" {
+		t.FailNow()
+	}
+	// a 404 doesn't put the mirror in cooldown but a server error or a connection error does
+	eps[0].Failed(NewError(http.StatusNotFound, "not found"))
+	if len(Endpoints("docker.io")) != 2 {
+		t.FailNow()
+	}
+	eps[0].Failed(NewError(http.StatusBadGateway, "bad gateway"))
+	if eps := Endpoints("docker.io"); len(eps) != 1 || eps[0].Mirror != "registry-1.docker.io" {
+		t.FailNow()
+	}
+	// when all the mirrors are in cooldown they are all tried
+	eps[1].Failed(errors.New("connection refused"))
+	if len(Endpoints("docker.io")) != 2 {
+		t.FailNow()
+	}
+	eps[0].Succeeded()
+	if eps := Endpoints("docker.io"); len(eps) != 1 || eps[0].Mirror != "harbor.corp/dockerhub" {
+		t.FailNow()
+	}
+	// the cooldown of a mirror only applies to the registry that it failed for
+	if len(Endpoints("ghcr.io")) != 2 {
+		t.FailNow()
+	}
+}
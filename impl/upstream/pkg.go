@@ -0,0 +1,3 @@
+// Package upstream is a small authenticated HTTP client for the upstream OCI distribution
+// API endpoints that the imgpull library does not cover, such as the tags list.
+package upstream
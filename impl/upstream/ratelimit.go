@@ -0,0 +1,251 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+	"github.com/aceeric/ociregistry/impl/metrics"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrRateLimited is wrapped by the error that a pull from a host fails with while pulls from
+// the host are held back because it is out of pull quota.
+var ErrRateLimited = errors.New("pull quota exhausted")
+
+const (
+	// defaultRateLimitBackoff is used if the rate limit backoff is not configured or can't be
+	// parsed
+	defaultRateLimitBackoff = time.Minute
+	// maxRateLimitBackoff is as far as the backoff doubles
+	maxRateLimitBackoff = time.Hour
+	// staleQuota is how old the recorded quota of a host is before a pull probes the host again
+	staleQuota = time.Minute
+	// probeAccept is the accept header of the manifest HEAD request that probes the quota
+	probeAccept = "application/vnd.oci.image.index.v1+json," +
+		"application/vnd.oci.image.manifest.v1+json," +
+		"application/vnd.docker.distribution.manifest.list.v2+json," +
+		"application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// rateLimit is the pull quota of one upstream host.
+type rateLimit struct {
+	// limit and remaining are from the last rate limit headers from the host, which were
+	// recorded at updated
+	limit     int
+	remaining int
+	updated   time.Time
+	// headers is true if the host has sent rate limit headers, and probed is true if the host
+	// has been sent a probe - see 'ProbeRateLimit'
+	headers bool
+	probed  bool
+	// until is when held back pulls resume, and backoff is how long they were last held back for
+	until   time.Time
+	backoff time.Duration
+}
+
+// rateLimits has the pull quota of each upstream host that has been pulled from, keyed by the
+// host of the endpoint, so a mirror has its own quota.
+var rateLimits = struct {
+	sync.Mutex
+	hosts map[string]*rateLimit
+}{
+	hosts: map[string]*rateLimit{},
+}
+
+// CheckRateLimit returns nil if a pull from the passed host can go ahead. If pulls from the host
+// are being held back then an Error with a 429 status that wraps ErrRateLimited is returned, with
+// the time until the pulls resume in the RetryAfter field.
+func CheckRateLimit(host string) error {
+	if enabled, _, _ := rateLimitSettings(); !enabled {
+		return nil
+	}
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	rl, exists := rateLimits.hosts[host]
+	if !exists || !time.Now().Before(rl.until) {
+		return nil
+	}
+	return &Error{
+		Status:     http.StatusTooManyRequests,
+		Err:        fmt.Errorf("pulls from %s are held back: %w until %s", host, ErrRateLimited, rl.until.Format(time.RFC3339)),
+		RetryAfter: time.Until(rl.until),
+	}
+}
+
+// ProbeRateLimit is like 'CheckRateLimit' for a pull of the passed repository and reference from
+// the passed endpoint, but first makes sure the recorded pull quota of the endpoint is current.
+// Since the imgpull library doesn't expose the headers of the upstream responses, the quota is
+// read from the response to a HEAD request for the manifest - which doesn't count against the
+// DockerHub quota. The probe is only sent if the quota that was last recorded from the headers of
+// any response from the host - see 'Client' - is stale. A host is not probed again once it is seen
+// not to send rate limit headers. The probe gives up at the deadline of the passed context, and if
+// the probe fails then the pull goes ahead.
+func ProbeRateLimit(ctx context.Context, ep Endpoint, repository, reference string) error {
+	if enabled, _, _ := rateLimitSettings(); !enabled {
+		return nil
+	}
+	if err := CheckRateLimit(ep.Host()); err != nil {
+		return err
+	}
+	rateLimits.Lock()
+	rl, exists := rateLimits.hosts[ep.Host()]
+	probe := !exists || (!rl.headers && !rl.probed) || (rl.headers && time.Since(rl.updated) >= staleQuota)
+	rateLimits.Unlock()
+	if !probe {
+		return nil
+	}
+	timeout := 0
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = max(int(time.Until(deadline).Milliseconds()), 1)
+	}
+	client, err := NewEndpointClient(ep, repository, timeout)
+	if err != nil {
+		return nil
+	}
+	resp, err := client.Head("manifests/"+reference, map[string]string{"Accept": probeAccept})
+	if err != nil {
+		log.Debugf("unable to probe the pull quota of %s, the error was: %s", ep.Host(), err)
+		return nil
+	}
+	resp.Body.Close()
+	rateLimits.Lock()
+	rateLimitFor(ep.Host()).probed = true
+	rateLimits.Unlock()
+	return CheckRateLimit(ep.Host())
+}
+
+// RecordRateLimit records the pull quota in the rate limit headers of the passed response from the
+// passed host. E.g. 'RateLimit-Remaining: 76;w=21600' means 76 pulls are left in the 21600 second
+// window. If the response is a 429, or if no more than the configured minimum pulls are left, then
+// pulls from the host are held back, and if more are left then the backoff is reset.
+func RecordRateLimit(host string, resp *http.Response) {
+	enabled, minRemaining, backoff := rateLimitSettings()
+	if !enabled {
+		return
+	}
+	limit, hasLimit := quota(resp.Header.Get("RateLimit-Limit"))
+	remaining, hasRemaining := quota(resp.Header.Get("RateLimit-Remaining"))
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	rl := rateLimitFor(host)
+	if hasLimit || hasRemaining {
+		rl.updated = time.Now()
+	}
+	if hasLimit {
+		rl.headers = true
+		rl.limit = limit
+		metrics.SetRateLimitLimitByNs(host, float64(limit))
+	}
+	if hasRemaining {
+		rl.headers = true
+		rl.remaining = remaining
+		metrics.SetRateLimitRemainingByNs(host, float64(remaining))
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		rl.holdBack(host, retryAfter(resp.Header.Get("Retry-After")), backoff, "the upstream answered with a 429")
+	case hasRemaining && remaining <= minRemaining:
+		rl.holdBack(host, retryAfter(resp.Header.Get("Retry-After")), backoff, fmt.Sprintf("%d pulls are left", remaining))
+	case hasRemaining:
+		rl.until = time.Time{}
+		rl.backoff = 0
+	}
+}
+
+// RecordRateLimitError records the result of a pull from the passed host for the imgpull library,
+// which only reports a 429 in its errors - without the headers. If the passed error is a 429 then
+// pulls from the host are held back, and if it is nil then the backoff is reset.
+func RecordRateLimitError(host string, err error) {
+	enabled, _, backoff := rateLimitSettings()
+	if !enabled {
+		return
+	}
+	var ue *Error
+	if err != nil && (!errors.As(err, &ue) || ue.Status != http.StatusTooManyRequests || errors.Is(err, ErrRateLimited)) {
+		return
+	}
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	rl := rateLimitFor(host)
+	if err == nil {
+		rl.backoff = 0
+		return
+	}
+	rl.holdBack(host, ue.RetryAfter, backoff, "the upstream answered with a 429")
+}
+
+// ResetRateLimits clears the pull quotas. It supports testing.
+func ResetRateLimits() {
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	rateLimits.hosts = map[string]*rateLimit{}
+}
+
+// rateLimitFor returns the pull quota of the passed host, adding it if it doesn't exist. Must be
+// called with the rate limits locked.
+func rateLimitFor(host string) *rateLimit {
+	rl, exists := rateLimits.hosts[host]
+	if !exists {
+		rl = &rateLimit{}
+		rateLimits.hosts[host] = rl
+	}
+	return rl
+}
+
+// holdBack holds back pulls from the passed host - the host of the receiver - for the passed retry
+// after duration if it is non-zero, else for the passed backoff, doubled for each time the pulls
+// have been held back since the backoff was last reset. If pulls are already held back then they
+// stay held back until the time already set. Must be called with the rate limits locked.
+func (rl *rateLimit) holdBack(host string, retryAfter, backoff time.Duration, reason string) {
+	if time.Now().Before(rl.until) {
+		return
+	}
+	if retryAfter <= 0 {
+		if rl.backoff != 0 {
+			backoff = min(2*rl.backoff, maxRateLimitBackoff)
+		}
+		rl.backoff = backoff
+		retryAfter = backoff
+	}
+	rl.until = time.Now().Add(retryAfter)
+	log.Warnf("holding back pulls from %s for %s because %s", host, retryAfter, reason)
+}
+
+// quota parses the quota from a rate limit header value like '100;w=21600'. The bool return value
+// is false if the header is missing or can't be parsed.
+func quota(value string) (int, bool) {
+	q, _, _ := strings.Cut(value, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(q))
+	return n, err == nil
+}
+
+// retryAfter parses a Retry-After header value, which is either seconds or an HTTP date. Zero is
+// returned if the header is missing or can't be parsed.
+func retryAfter(value string) time.Duration {
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// rateLimitSettings returns whether pulls are held back for the upstream rate limits, the
+// minimum remaining pulls before pulls are held back, and the initial backoff.
+func rateLimitSettings() (bool, int, time.Duration) {
+	cfg := config.GetRateLimitConfig()
+	backoff, err := time.ParseDuration(cfg.Backoff)
+	if err != nil || backoff <= 0 {
+		backoff = defaultRateLimitBackoff
+	}
+	return cfg.Enabled, cfg.MinRemaining, backoff
+}
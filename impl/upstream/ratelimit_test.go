@@ -0,0 +1,149 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aceeric/ociregistry/impl/config"
+)
+
+var rateLimitConfig = `
+---
+rateLimitConfig:
+  enabled: true
+  minRemaining: 5
+  backoff: 1m
+`
+
+func TestRateLimit(t *testing.T) {
+	defer ResetRateLimits()
+	if err := config.SetConfigFromStr([]byte(rateLimitConfig)); err != nil {
+		t.FailNow()
+	}
+	response := func(status int, hdrs ...string) *http.Response {
+		resp := &http.Response{StatusCode: status, Header: http.Header{}}
+		for i := 0; i < len(hdrs); i += 2 {
+			resp.Header.Set(hdrs[i], hdrs[i+1])
+		}
+		return resp
+	}
+	// heldFor returns how long pulls from docker.io are held back for
+	heldFor := func() time.Duration {
+		var ue *Error
+		err := CheckRateLimit("docker.io")
+		if !errors.Is(err, ErrRateLimited) || !errors.As(err, &ue) || ue.Status != http.StatusTooManyRequests {
+			t.FailNow()
+		}
+		return ue.RetryAfter
+	}
+	// resume pulls from docker.io by backdating the hold
+	resume := func() {
+		rateLimits.Lock()
+		defer rateLimits.Unlock()
+		rateLimits.hosts["docker.io"].until = time.Now().Add(-time.Second)
+	}
+	RecordRateLimit("docker.io", response(http.StatusOK, "RateLimit-Limit", "100;w=21600", "RateLimit-Remaining", "76;w=21600"))
+	if CheckRateLimit("docker.io") != nil {
+		t.FailNow()
+	}
+	// running low on quota holds back pulls for the backoff, which doubles if the quota is
+	// still low when the pulls resume
+	RecordRateLimit("docker.io", response(http.StatusOK, "RateLimit-Remaining", "5;w=21600"))
+	if d := heldFor(); d <= 59*time.Second || d > time.Minute {
+		t.FailNow()
+	}
+	if CheckRateLimit("quay.io") != nil {
+		t.FailNow()
+	}
+	resume()
+	RecordRateLimit("docker.io", response(http.StatusOK, "RateLimit-Remaining", "3;w=21600"))
+	if d := heldFor(); d <= 119*time.Second || d > 2*time.Minute {
+		t.FailNow()
+	}
+	// another response while pulls are held back doesn't extend the hold
+	RecordRateLimit("docker.io", response(http.StatusOK, "RateLimit-Remaining", "2;w=21600"))
+	if d := heldFor(); d > 2*time.Minute {
+		t.FailNow()
+	}
+	// quota is back so pulls resume and the backoff is reset
+	RecordRateLimit("docker.io", response(http.StatusOK, "RateLimit-Remaining", "100;w=21600"))
+	if CheckRateLimit("docker.io") != nil {
+		t.FailNow()
+	}
+	// a 429 holds back pulls for the Retry-After
+	RecordRateLimit("docker.io", response(http.StatusTooManyRequests, "Retry-After", "30"))
+	if d := heldFor(); d <= 29*time.Second || d > 30*time.Second {
+		t.FailNow()
+	}
+	// a 429 from the imgpull library has no Retry-After so the backoff is used, and an error
+	// from a held back pull isn't another 429
+	resume()
+	RecordRateLimitError("docker.io", NewError(http.StatusTooManyRequests, "Status: 429"))
+	if d := heldFor(); d <= 59*time.Second || d > time.Minute {
+		t.FailNow()
+	}
+	held := CheckRateLimit("docker.io")
+	resume()
+	RecordRateLimitError("docker.io", held)
+	RecordRateLimitError("docker.io", NewError(http.StatusNotFound, "Status: 404"))
+	if CheckRateLimit("docker.io") != nil {
+		t.FailNow()
+	}
+}
+
+// Checks that a pull only probes the quota of a host when the quota recorded from the headers of
+// the last response from the host is stale, and that a host without rate limit headers is only
+// probed once.
+func TestProbeRateLimit(t *testing.T) {
+	defer ResetRateLimits()
+	var probes atomic.Int32
+	var headers atomic.Bool
+	headers.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		if headers.Load() {
+			w.Header().Set("RateLimit-Limit", "100;w=21600")
+			w.Header().Set("RateLimit-Remaining", "76;w=21600")
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	if err := config.SetConfigFromStr([]byte(fmt.Sprintf("%sregistries:\n  - name: %s\n    scheme: http\n", rateLimitConfig, host))); err != nil {
+		t.FailNow()
+	}
+	probe := func() int32 {
+		if ProbeRateLimit(context.Background(), Endpoint{Registry: host}, "foo/bar", "latest") != nil {
+			t.FailNow()
+		}
+		return probes.Load()
+	}
+	if probe() != 1 || probe() != 1 {
+		t.FailNow()
+	}
+	// a response to any request from the host refreshes the quota
+	rateLimits.Lock()
+	rateLimits.hosts[host].updated = time.Now().Add(-staleQuota)
+	rateLimits.Unlock()
+	RecordRateLimit(host, &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Ratelimit-Remaining": {"70;w=21600"}}})
+	if probe() != 1 {
+		t.FailNow()
+	}
+	rateLimits.Lock()
+	rateLimits.hosts[host].updated = time.Now().Add(-staleQuota)
+	rateLimits.Unlock()
+	if probe() != 2 {
+		t.FailNow()
+	}
+	ResetRateLimits()
+	headers.Store(false)
+	if probe() != 3 || probe() != 3 {
+		t.FailNow()
+	}
+}
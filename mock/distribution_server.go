@@ -152,6 +152,38 @@ func ServerWithCallback(params MockParams, callback *func(string)) (*httptest.Se
 			w.Header().Add("Content-Type", "application/octet-stream")
 			w.Header().Add("Date", time.Now().In(gmtTimeLoc).Format(http.TimeFormat))
 			w.Write([]byte(c1ec))
+		} else if p == "/v2/hello-world/referrers/sha256:e4ccfd825622441dcee5123f9d4a48b2eb8787d858de346106a83f0c745cc255" ||
+			p == "/v2/hello-world/manifests/sha256-e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57" {
+			// the manifest list has referrers from the referrers API and the image manifest has referrers
+			// from the tag schema. Either way the referrer is the image manifest which is contrived but
+			// it exercises the code paths.
+			body := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[`+
+				`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:e2fc4e5012d16e7fe466f5291c476431beaa1f9b90a5c2125b493ed28e2aba57",`+
+				`"size":%d,"artifactType":"application/vnd.example.sbom"}]}`, len(imageManifest))
+			w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+			w.Write([]byte(body))
+		} else if p == "/v2/hello-world/tags/list" {
+			tags := []string{"latest", "linux", "nanoserver"}
+			if last := r.URL.Query().Get("last"); last != "" {
+				for i, tag := range tags {
+					if tag > last {
+						tags = tags[i:]
+						break
+					} else if i == len(tags)-1 {
+						tags = []string{}
+					}
+				}
+			}
+			if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n < len(tags) {
+				tags = tags[:n]
+				w.Header().Set("Link", fmt.Sprintf(`</v2/hello-world/tags/list?n=%d&last=%s>; rel="next"`, n, tags[len(tags)-1]))
+			}
+			body := fmt.Sprintf(`{"name":"library/hello-world","tags":["%s"]}`, strings.Join(tags, `","`))
+			if len(tags) == 0 {
+				body = `{"name":"library/hello-world","tags":[]}`
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(body))
 		} else {
 			w.WriteHeader(http.StatusNotFound)
 		}